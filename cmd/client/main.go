@@ -2,7 +2,10 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"net/http"
@@ -11,7 +14,9 @@ import (
 	"time"
 
 	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
@@ -26,11 +31,13 @@ type FileClient struct {
 	client pbv1.FileServiceClient
 }
 
-func NewFileClient(addr string) (*FileClient, error) {
+// NewFileClient connects to addr, sending credentials as the metadata
+// pair authKey: authValue ("authorization"/"Bearer <jwt>" or "api-key"/<key>)
+func NewFileClient(addr, authKey, authValue string) (*FileClient, error) {
 	conn, err := grpc.NewClient(addr,
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithUnaryInterceptor(clientAuthInterceptor),
-		grpc.WithStreamInterceptor(clientStreamAuthInterceptor),
+		grpc.WithUnaryInterceptor(clientAuthInterceptor(authKey, authValue)),
+		grpc.WithStreamInterceptor(clientStreamAuthInterceptor(authKey, authValue)),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect: %w", err)
@@ -41,32 +48,40 @@ func NewFileClient(addr string) (*FileClient, error) {
 	}, nil
 }
 
-func clientAuthInterceptor(
-	ctx context.Context,
-	method string,
-	req, reply interface{},
-	cc *grpc.ClientConn,
-	invoker grpc.UnaryInvoker,
-	opts ...grpc.CallOption,
-) error {
-	ctx = metadata.AppendToOutgoingContext(ctx, "api-key", "dev-key-123")
-	return invoker(ctx, method, req, reply, cc, opts...)
+// clientAuthInterceptor attaches credentials to unary calls
+func clientAuthInterceptor(authKey, authValue string) grpc.UnaryClientInterceptor {
+	return func(
+		ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption,
+	) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, authKey, authValue)
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
 }
 
-func clientStreamAuthInterceptor(
-	ctx context.Context,
-	desc *grpc.StreamDesc,
-	cc *grpc.ClientConn,
-	method string,
-	streamer grpc.Streamer,
-	opts ...grpc.CallOption,
-) (grpc.ClientStream, error) {
-	ctx = metadata.AppendToOutgoingContext(ctx, "api-key", "dev-key-123")
-	return streamer(ctx, desc, cc, method, opts...)
+// clientStreamAuthInterceptor attaches credentials to streaming calls
+func clientStreamAuthInterceptor(authKey, authValue string) grpc.StreamClientInterceptor {
+	return func(
+		ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption,
+	) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, authKey, authValue)
+		return streamer(ctx, desc, cc, method, opts...)
+	}
 }
 
-// UploadFile streams a file to the server
-func (fc *FileClient) UploadFile(ctx context.Context, filePath, userID string) (*pbv1.UploadFileResponse, error) {
+// UploadFile streams a file to the server. When uploadID is set the upload is
+// resumable: the client asks the server how much it already has and only
+// sends the remaining bytes.
+func (fc *FileClient) UploadFile(ctx context.Context, filePath, uploadID string) (*pbv1.UploadFileResponse, error) {
 	//  Open file
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -80,6 +95,27 @@ func (fc *FileClient) UploadFile(ctx context.Context, filePath, userID string) (
 		return nil, fmt.Errorf("failed to stat file: %w", err)
 	}
 
+	//  Hash the whole file so the server can verify what it stored
+	sum, crc, err := fileChecksum(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash file: %w", err)
+	}
+
+	//  Find out where to resume from
+	offset := int64(0)
+	if uploadID != "" {
+		offset, err = fc.committedOffset(ctx, uploadID)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek file: %w", err)
+		}
+		if offset > 0 {
+			fmt.Printf("Resuming upload %s at byte %d\n", uploadID, offset)
+		}
+	}
+
 	// Create upload stream
 	ctx, cancel := context.WithTimeout(ctx, 30*time.Second) // Longer for files
 	defer cancel()
@@ -90,10 +126,13 @@ func (fc *FileClient) UploadFile(ctx context.Context, filePath, userID string) (
 
 	//  Send metadata first
 	metadata := &pbv1.FileMetadata{
-		Filename:    fileInfo.Name(),
-		ContentType: detectContentType(filePath),
-		Size:        fileInfo.Size(),
-		UserId:      userID,
+		Filename:     fileInfo.Name(),
+		ContentType:  detectContentType(filePath),
+		Size:         fileInfo.Size(),
+		UploadId:     uploadID,
+		ResumeOffset: offset,
+		Sha256:       sum,
+		Crc32C:       &crc,
 	}
 
 	err = stream.Send(&pbv1.UploadFileRequest{
@@ -107,7 +146,7 @@ func (fc *FileClient) UploadFile(ctx context.Context, filePath, userID string) (
 
 	//  Stream file chunks
 	buffer := make([]byte, chunkSize)
-	totalSent := int64(0)
+	totalSent := offset
 
 	for {
 		n, err := file.Read(buffer)
@@ -142,13 +181,80 @@ func (fc *FileClient) UploadFile(ctx context.Context, filePath, userID string) (
 	return resp, nil
 }
 
-// DownloadFile streams a file from the server
+// UploadFileResumable uploads a file under a fresh upload ID and resumes
+// from the committed offset whenever the stream breaks
+func (fc *FileClient) UploadFileResumable(ctx context.Context, filePath string, attempts int) (*pbv1.UploadFileResponse, error) {
+	uploadID := uuid.New().String()
+
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		resp, err := fc.UploadFile(ctx, filePath, uploadID)
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+
+		// Validation errors won't go away by retrying
+		if code := status.Code(err); code == codes.InvalidArgument || code == codes.PermissionDenied {
+			return nil, err
+		}
+
+		log.Printf("Upload attempt %d/%d failed: %v", attempt, attempts, err)
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	return nil, fmt.Errorf("upload failed after %d attempts: %w", attempts, lastErr)
+}
+
+// committedOffset asks the server how many bytes of uploadID it already has
+func (fc *FileClient) committedOffset(ctx context.Context, uploadID string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	resp, err := fc.client.GetUploadStatus(ctx, &pbv1.GetUploadStatusRequest{
+		UploadId: uploadID,
+	})
+	if status.Code(err) == codes.NotFound {
+		// Nothing stored yet, start from the beginning
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get upload status: %w", err)
+	}
+	return resp.CommittedOffset, nil
+}
+
+// DownloadFile streams a file from the server. Bytes land in outputPath+".part"
+// first; if that exists from an interrupted run, only the rest is requested.
 func (fc *FileClient) DownloadFile(ctx context.Context, fileID, outputPath string) error {
+	partPath := outputPath + ".part"
+
+	//  Pick up where a previous attempt stopped
+	offset := int64(0)
+	if info, err := os.Stat(partPath); err == nil {
+		offset = info.Size()
+	}
+
+	err := fc.downloadRange(ctx, fileID, partPath, offset)
+	if status.Code(err) == codes.OutOfRange {
+		// Partial file is longer than the remote file; it can't be ours
+		fmt.Println("Partial download does not match, starting over")
+		err = fc.downloadRange(ctx, fileID, partPath, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(partPath, outputPath)
+}
+
+// downloadRange appends everything from offset onward to partPath and
+// verifies the complete file against the server's checksum
+func (fc *FileClient) downloadRange(ctx context.Context, fileID, partPath string, offset int64) error {
 	// Create download stream
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second) // Longer for files
 	defer cancel()
 	stream, err := fc.client.DownloadFile(ctx, &pbv1.DownloadFileRequest{
 		FileId: fileID,
+		Offset: offset,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create stream: %w", err)
@@ -164,18 +270,38 @@ func (fc *FileClient) DownloadFile(ctx context.Context, fileID, outputPath strin
 	if fileInfo == nil {
 		return fmt.Errorf("expected file info in first message")
 	}
+	if fileInfo.RangeStart != offset {
+		return fmt.Errorf("server sent range starting at %d, asked for %d", fileInfo.RangeStart, offset)
+	}
 
-	fmt.Printf("Downloading: %s (%d bytes)\n", fileInfo.Filename, fileInfo.Size)
+	if offset > 0 {
+		fmt.Printf("Resuming: %s at byte %d of %d\n", fileInfo.Filename, offset, fileInfo.Size)
+	} else {
+		fmt.Printf("Downloading: %s (%d bytes)\n", fileInfo.Filename, fileInfo.Size)
+	}
 
-	//  Create output file
-	outFile, err := os.Create(outputPath)
+	//  Open output file (truncate when starting over)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	outFile, err := os.OpenFile(partPath, flags, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer outFile.Close()
 
+	// Hash while writing so corruption anywhere on the path is caught.
+	// Resumed downloads hash the bytes already on disk first.
+	hasher := sha256.New()
+	if offset > 0 {
+		if err := hashPrefix(partPath, offset, hasher); err != nil {
+			return fmt.Errorf("failed to hash partial file: %w", err)
+		}
+	}
+
 	//  Receive chunks and write to file
-	totalReceived := int64(0)
+	totalReceived := offset
 	for {
 		msg, err := stream.Recv()
 		if err == io.EOF {
@@ -190,6 +316,7 @@ func (fc *FileClient) DownloadFile(ctx context.Context, fileID, outputPath strin
 		if err != nil {
 			return fmt.Errorf("failed to write chunk: %w", err)
 		}
+		hasher.Write(chunk[:n])
 
 		totalReceived += int64(n)
 		progress := float64(totalReceived) / float64(fileInfo.Size) * 100
@@ -197,9 +324,33 @@ func (fc *FileClient) DownloadFile(ctx context.Context, fileID, outputPath strin
 	}
 	fmt.Println() // New line after progress
 
+	if totalReceived != fileInfo.Size {
+		return fmt.Errorf("incomplete download: %d of %d bytes", totalReceived, fileInfo.Size)
+	}
+
+	// Files uploaded before checksums were introduced have no digest
+	if fileInfo.Sha256 != "" {
+		if got := hex.EncodeToString(hasher.Sum(nil)); got != fileInfo.Sha256 {
+			// Don't resume from corrupt bytes next time
+			os.Remove(partPath)
+			return fmt.Errorf("checksum mismatch: expected sha256 %s, got %s", fileInfo.Sha256, got)
+		}
+	}
+
 	return nil
 }
 
+// hashPrefix feeds the first n bytes of path into h
+func hashPrefix(path string, n int64, h io.Writer) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.CopyN(h, file, n)
+	return err
+}
+
 // GetFileMetadata retrieves metadata for a file
 func (fc *FileClient) GetFileMetadata(ctx context.Context, fileID string) (*pbv1.GetFileMetadataResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -213,12 +364,11 @@ func (fc *FileClient) GetFileMetadata(ctx context.Context, fileID string) (*pbv1
 	return resp, nil
 }
 
-// ListFiles lists files for a user with pagination
-func (fc *FileClient) ListFiles(ctx context.Context, userID string, pageSize int32, pageToken string) (*pbv1.ListFilesResponse, error) {
+// ListFiles lists the caller's files with pagination
+func (fc *FileClient) ListFiles(ctx context.Context, pageSize int32, pageToken string) (*pbv1.ListFilesResponse, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	resp, err := fc.client.ListFiles(ctx, &pbv1.ListFilesRequest{
-		UserId:    userID,
 		PageSize:  pageSize,
 		PageToken: pageToken,
 	})
@@ -228,13 +378,46 @@ func (fc *FileClient) ListFiles(ctx context.Context, userID string, pageSize int
 	return resp, nil
 }
 
+// WatchFile prints processing status changes until processing finishes or ctx ends
+func (fc *FileClient) WatchFile(ctx context.Context, fileID string) error {
+	stream, err := fc.client.WatchFile(ctx, &pbv1.WatchFileRequest{FileId: fileID})
+	if err != nil {
+		return fmt.Errorf("failed to watch file: %w", err)
+	}
+
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("watch failed: %w", err)
+		}
+		fmt.Printf("  %s job %d: %s\n", ev.JobType, ev.JobId, ev.ProcessingStatus)
+		if msg := ev.GetProcessingResult().GetErrorMessage(); msg != "" {
+			fmt.Printf("  Error: %s\n", msg)
+		}
+	}
+}
+
+// GetUsage reports the caller's storage usage against their quotas
+func (fc *FileClient) GetUsage(ctx context.Context) (*pbv1.GetUsageResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	resp, err := fc.client.GetUsage(ctx, &pbv1.GetUsageRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %w", err)
+	}
+	return resp, nil
+}
+
 // DeleteFile deletes a file
-func (fc *FileClient) DeleteFile(ctx context.Context, fileID, userID string) error {
+func (fc *FileClient) DeleteFile(ctx context.Context, fileID string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	resp, err := fc.client.DeleteFile(ctx, &pbv1.DeleteFileRequest{
 		FileId: fileID,
-		UserId: userID,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to delete file: %w", err)
@@ -246,6 +429,19 @@ func (fc *FileClient) DeleteFile(ctx context.Context, fileID, userID string) err
 	return nil
 }
 
+// fileChecksum returns the SHA-256 (hex) and CRC32C of file, then rewinds it
+func fileChecksum(file *os.File) (string, uint32, error) {
+	sha := sha256.New()
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(io.MultiWriter(sha, crc), file); err != nil {
+		return "", 0, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(sha.Sum(nil)), crc.Sum32(), nil
+}
+
 // detectContentType attempts to detect MIME type, falls back to extension
 func detectContentType(filePath string) string {
 	// Try magic bytes first
@@ -274,6 +470,10 @@ func detectContentType(filePath string) string {
 		return "image/gif"
 	case ".webp":
 		return "image/webp"
+	case ".bmp":
+		return "image/bmp"
+	case ".tif", ".tiff":
+		return "image/tiff"
 	case ".pdf":
 		return "application/pdf"
 	case ".txt":
@@ -294,18 +494,27 @@ func main() {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK from client "))
 	})
+	// An API key or a bearer JWT identifies the user; the server derives user_id from it
+	authKey, authValue := "api-key", os.Getenv("UPLOADSTREAM_API_KEY")
+	if authValue == "" {
+		token := os.Getenv("UPLOADSTREAM_TOKEN")
+		if token == "" {
+			log.Fatal("UPLOADSTREAM_API_KEY or UPLOADSTREAM_TOKEN env var is required")
+		}
+		authKey, authValue = "authorization", "Bearer "+token
+	}
+
 	// Create client
-	client, err := NewFileClient(serverAddr)
+	client, err := NewFileClient(serverAddr, authKey, authValue)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
 
 	ctx := context.Background()
-	userID := "550e8400-e29b-41d4-a716-446655440000" //
 
 	// Example 1: Upload a file
 	fmt.Println("=== Uploading File ===")
-	uploadResp, err := client.UploadFile(ctx, "test-file.txt", userID)
+	uploadResp, err := client.UploadFileResumable(ctx, "test-file.txt", 3)
 	if err != nil {
 		if st, ok := status.FromError(err); ok {
 			log.Printf("%s failed: %s", st.Code(), st.Message())
@@ -332,9 +541,17 @@ func main() {
 		fmt.Printf("  Uploaded: %s\n", metadata.UploadedAt.AsTime().Format(time.RFC3339))
 	}
 
+	// Example 2b: Wait for background processing
+	fmt.Println("\n=== Watching Processing ===")
+	watchCtx, cancelWatch := context.WithTimeout(ctx, 30*time.Second)
+	if err := client.WatchFile(watchCtx, fileID); err != nil {
+		log.Printf("Watch failed: %v", err)
+	}
+	cancelWatch()
+
 	// Example 3: List files
 	fmt.Println("\n=== Listing Files ===")
-	listResp, err := client.ListFiles(ctx, userID, 10, "")
+	listResp, err := client.ListFiles(ctx, 10, "")
 	if err != nil {
 		log.Printf("List files failed: %v", err)
 	} else {
@@ -348,6 +565,14 @@ func main() {
 		}
 	}
 
+	usage, err := client.GetUsage(ctx)
+	if err != nil {
+		log.Printf("Get usage failed: %v", err)
+	} else {
+		fmt.Printf("  Usage: %d bytes in %d files (limits: %d bytes, %d files; 0 = unlimited)\n",
+			usage.User.BytesUsed, usage.User.FileCount, usage.User.MaxBytes, usage.User.MaxFiles)
+	}
+
 	// Example 4: Download file
 	fmt.Println("\n=== Downloading File ===")
 	err = client.DownloadFile(ctx, fileID, "downloaded-file.txt")
@@ -359,7 +584,7 @@ func main() {
 
 	// Example 5: Delete file
 	fmt.Println("\n=== Deleting File ===")
-	err = client.DeleteFile(ctx, fileID, userID)
+	err = client.DeleteFile(ctx, fileID)
 	if err != nil {
 		log.Printf("Delete failed: %v", err)
 	} else {
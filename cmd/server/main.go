@@ -2,28 +2,38 @@ package main
 
 import (
 	"context"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
 
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/observability"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/service"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/google/uuid"
 	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
 )
 
+var bootstrapAdminOwner = flag.String("bootstrap-admin-key", "",
+	"create an admin API key owned by this user ID, print it and exit")
+
 func main() {
+	flag.Parse()
+
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK from server"))
@@ -60,9 +70,11 @@ func main() {
 		logger.Info("metrics endpoint available at http://localhost:9090/metrics")
 	}
 
-	// Initialize storage
-	storageLayer := storage.NewFilesystemStorage("./data/files")
-	logger.Info("filesystem storage initialized")
+	// Initialize storage (STORAGE_BACKEND=filesystem|s3)
+	storageLayer, err := initStorage(logger)
+	if err != nil {
+		logger.Fatal("failed to initialize storage", zap.Error(err))
+	}
 
 	// Initialize database
 	dbURL := os.Getenv("UPLOADSTREAM")
@@ -76,27 +88,83 @@ func main() {
 	}
 	logger.Info("database connected")
 
-	// Start background worker
+	// -bootstrap-admin-key issues the first admin key, then exits
+	if *bootstrapAdminOwner != "" {
+		if err := bootstrapAdminKey(context.Background(), db, *bootstrapAdminOwner); err != nil {
+			logger.Fatal("failed to create admin key", zap.Error(err))
+		}
+		return
+	}
+
+	// Processing job changes (LISTEN/NOTIFY) wake workers and feed WatchFile streams
+	jobEvents := events.NewBus()
+	if err := events.ListenPostgres(context.Background(), dbURL, jobEvents); err != nil {
+		logger.Warn("job event listener unavailable; falling back to polling", zap.Error(err))
+		jobEvents = nil
+	}
+
+	// Start background worker pool (WORKER_CONCURRENCY, default: one per CPU)
+	workerConcurrency, _ := strconv.Atoi(os.Getenv("WORKER_CONCURRENCY"))
+	// The file server queues jobs for the processors registered here
+	thumbnailPresets, err := thumbnailPresetsFromEnv()
+	if err != nil {
+		logger.Fatal("invalid thumbnail presets", zap.Error(err))
+	}
+	processors := worker.DefaultRegistry(storageLayer, thumbnailPresets)
+	retry, retryPolicies, err := retryPoliciesFromEnv()
+	if err != nil {
+		logger.Fatal("invalid retry policies", zap.Error(err))
+	}
 	workerConfig := &worker.WorkerConfig{
-		DB:           db,
-		StoragePath:  "./data/files",
-		PollInterval: 2 * time.Second,
+		DB:            db,
+		Storage:       storageLayer,
+		Events:        jobEvents,
+		Concurrency:   workerConcurrency,
+		PollInterval:  2 * time.Second,
+		Retry:         retry,
+		RetryPolicies: retryPolicies,
+		Processors:    processors,
 	}
 	processingWorker := worker.NewProcessingWorker(workerConfig)
 	processingWorker.Start(context.Background())
 
+	// Garbage-collect abandoned resumable uploads
+	uploadReaper := worker.NewUploadSessionReaper(&worker.ReaperConfig{
+		DB:      db,
+		Storage: storageLayer,
+	})
+	uploadReaper.Start(context.Background())
+
+	// API keys from the database, plus bearer JWTs if configured
+	var verifier *middleware.JWTVerifier
+	if os.Getenv("JWT_HS256_SECRET") != "" || os.Getenv("JWT_JWKS_FILE") != "" {
+		verifier, err = middleware.NewJWTVerifier(middleware.JWTConfig{
+			HS256Secret: []byte(os.Getenv("JWT_HS256_SECRET")),
+			JWKSFile:    os.Getenv("JWT_JWKS_FILE"),
+			Issuer:      os.Getenv("JWT_ISSUER"),
+			Audience:    os.Getenv("JWT_AUDIENCE"),
+			Leeway:      30 * time.Second,
+		})
+		if err != nil {
+			logger.Fatal("failed to configure JWT authentication", zap.Error(err))
+		}
+	} else {
+		logger.Info("JWT authentication disabled; accepting API keys only")
+	}
+	authenticator := middleware.NewAuthenticator(verifier, db)
+
 	// Build gRPC server with observability interceptors
 	grpcServerOpts := []grpc.ServerOption{
 		// Auth interceptors
 		grpc.UnaryInterceptor(
 			middleware.ChainUnaryInterceptors(
-				middleware.UnaryAuthInterceptor,
+				middleware.UnaryAuthInterceptor(authenticator),
 				middleware.UnaryLoggingInterceptor(logger),
 			),
 		),
 		grpc.StreamInterceptor(
 			middleware.ChainStreamInterceptors(
-				middleware.StreamAuthInterceptor,
+				middleware.StreamAuthInterceptor(authenticator),
 				middleware.StreamLoggingInterceptor(logger),
 			),
 		),
@@ -115,9 +183,32 @@ func main() {
 	grpcServer := grpc.NewServer(grpcServerOpts...)
 
 	// Register service
-	fileServer := service.NewFileServer(storageLayer, db)
+	quotas, err := quotaConfigFromEnv()
+	if err != nil {
+		logger.Fatal("invalid quota configuration", zap.Error(err))
+	}
+
+	variants, err := variantConfigFromEnv()
+	if err != nil {
+		logger.Fatal("invalid image variant configuration", zap.Error(err))
+	}
+
+	pageTokenKey := []byte(os.Getenv("PAGE_TOKEN_SECRET"))
+	if len(pageTokenKey) == 0 {
+		logger.Warn("PAGE_TOKEN_SECRET not set; page tokens won't survive a restart or work across replicas")
+	}
+
+	fileServer := service.NewFileServer(storageLayer, db, service.FileServerOptions{
+		Quotas:       quotas,
+		Events:       jobEvents,
+		Jobs:         processors,
+		Variants:     variants,
+		PageTokenKey: pageTokenKey,
+	})
 	pbv1.RegisterFileServiceServer(grpcServer, fileServer)
 	logger.Info("FileService registered")
+	pbv1.RegisterAdminServiceServer(grpcServer, service.NewAdminServer(db))
+	logger.Info("AdminService registered")
 
 	// Listen and serve
 	lis, err := net.Listen("tcp", ":50051")
@@ -135,6 +226,7 @@ func main() {
 		logger.Info("shutdown signal received", zap.String("signal", sig.String()))
 
 		processingWorker.Stop()
+		uploadReaper.Stop()
 		grpcServer.GracefulStop()
 		logger.Info("server shutdown complete")
 	}()
@@ -143,3 +235,174 @@ func main() {
 		logger.Error("server failed", zap.Error(err))
 	}
 }
+
+// initStorage picks the storage backend from the environment. The filesystem
+// backend ties the server to one volume; use s3 to run several replicas.
+func initStorage(logger *zap.Logger) (service.StorageInterface, error) {
+	switch backend := os.Getenv("STORAGE_BACKEND"); backend {
+	case "", "filesystem":
+		logger.Info("filesystem storage initialized")
+		return storage.NewFilesystemStorage("./data/files"), nil
+	case "s3":
+		cfg := storage.S3Config{
+			Endpoint:  os.Getenv("S3_ENDPOINT"),
+			Region:    os.Getenv("S3_REGION"),
+			Bucket:    os.Getenv("S3_BUCKET"),
+			AccessKey: os.Getenv("S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("S3_SECRET_KEY"),
+			UseSSL:    os.Getenv("S3_USE_SSL") != "false",
+			Prefix:    os.Getenv("S3_PREFIX"),
+		}
+		if cfg.Endpoint == "" || cfg.Bucket == "" {
+			return nil, fmt.Errorf("S3_ENDPOINT and S3_BUCKET are required for the s3 backend")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		s3Storage, err := storage.NewS3Storage(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("s3 storage initialized",
+			zap.String("endpoint", cfg.Endpoint),
+			zap.String("bucket", cfg.Bucket),
+		)
+		return s3Storage, nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", backend)
+	}
+}
+
+// quotaConfigFromEnv reads the default quotas (unset or 0 = unlimited)
+func quotaConfigFromEnv() (service.QuotaConfig, error) {
+	var cfg service.QuotaConfig
+	for _, v := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"QUOTA_USER_MAX_BYTES", &cfg.User.MaxBytes},
+		{"QUOTA_USER_MAX_FILES", &cfg.User.MaxFiles},
+		{"QUOTA_TENANT_MAX_BYTES", &cfg.Tenant.MaxBytes},
+		{"QUOTA_TENANT_MAX_FILES", &cfg.Tenant.MaxFiles},
+	} {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("%s must be a non-negative integer, got %q", v.name, raw)
+		}
+		*v.dst = n
+	}
+	return cfg, nil
+}
+
+// variantConfigFromEnv reads the GetImageVariant limits (unset or 0 = default)
+func variantConfigFromEnv() (service.VariantConfig, error) {
+	var cfg service.VariantConfig
+	for _, v := range []struct {
+		name string
+		dst  *int
+	}{
+		{"VARIANT_MAX_WIDTH", &cfg.MaxWidth},
+		{"VARIANT_MAX_HEIGHT", &cfg.MaxHeight},
+		{"VARIANT_CONCURRENCY", &cfg.Concurrency},
+		{"VARIANT_MAX_SOURCE_PIXELS", &cfg.MaxSourcePixels},
+	} {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("%s must be a non-negative integer, got %q", v.name, raw)
+		}
+		*v.dst = n
+	}
+	return cfg, nil
+}
+
+// thumbnailPresetsFromEnv reads a JSON array of thumbnail presets from
+// THUMBNAIL_PRESETS, or from the file named by THUMBNAIL_PRESETS_FILE. It
+// returns nil, meaning the defaults, if neither is set.
+func thumbnailPresetsFromEnv() ([]worker.ThumbnailPreset, error) {
+	data := []byte(os.Getenv("THUMBNAIL_PRESETS"))
+	if path := os.Getenv("THUMBNAIL_PRESETS_FILE"); path != "" {
+		if len(data) > 0 {
+			return nil, fmt.Errorf("set THUMBNAIL_PRESETS or THUMBNAIL_PRESETS_FILE, not both")
+		}
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return worker.ParseThumbnailPresets(data)
+}
+
+// retryPoliciesFromEnv reads the default retry policy, DefaultRetryPolicy
+// with WORKER_RETRY_MAX_ATTEMPTS, WORKER_RETRY_INITIAL_BACKOFF and
+// WORKER_RETRY_MAX_BACKOFF applied, and per-job-type overrides of it from a
+// JSON object in WORKER_RETRY_POLICIES, e.g.
+// {"document": {"max_attempts": 2, "initial_backoff": "1m"}}.
+func retryPoliciesFromEnv() (worker.RetryPolicy, map[string]worker.RetryPolicy, error) {
+	retry := worker.DefaultRetryPolicy
+	if raw := os.Getenv("WORKER_RETRY_MAX_ATTEMPTS"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return retry, nil, fmt.Errorf("WORKER_RETRY_MAX_ATTEMPTS must be an integer, got %q", raw)
+		}
+		retry.MaxAttempts = n
+	}
+	for _, v := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"WORKER_RETRY_INITIAL_BACKOFF", &retry.InitialBackoff},
+		{"WORKER_RETRY_MAX_BACKOFF", &retry.MaxBackoff},
+	} {
+		raw := os.Getenv(v.name)
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return retry, nil, fmt.Errorf("%s must be a duration such as 30s, got %q", v.name, raw)
+		}
+		*v.dst = d
+	}
+	if err := retry.Validate(); err != nil {
+		return retry, nil, err
+	}
+
+	raw := os.Getenv("WORKER_RETRY_POLICIES")
+	if raw == "" {
+		return retry, nil, nil
+	}
+	policies, err := worker.ParseRetryPolicies([]byte(raw), retry)
+	return retry, policies, err
+}
+
+// bootstrapAdminKey creates an admin-scoped API key and prints it once
+func bootstrapAdminKey(ctx context.Context, db *database.PostgresDB, ownerID string) error {
+	secret, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		return err
+	}
+	key := &database.APIKey{
+		ID:      uuid.New().String(),
+		OwnerID: ownerID,
+		Name:    "bootstrap admin",
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  []string{string(middleware.ScopeAdmin)},
+	}
+	if err := db.CreateAPIKey(ctx, key); err != nil {
+		return err
+	}
+	fmt.Printf("key_id: %s\napi-key: %s\n", key.ID, secret)
+	return nil
+}
@@ -3,10 +3,15 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresDB struct {
@@ -26,16 +31,47 @@ func NewPostgresDB(connectionString string) (*PostgresDB, error) {
 	return &PostgresDB{db: db}, nil
 }
 
-func (p *PostgresDB) SaveFile(ctx context.Context, fileID string, metadata *pbv1.FileMetadata, size int64) error {
+// SaveFile records a stored file and charges it to owner's usage in one
+// transaction. It returns ErrQuotaExceeded (and saves nothing) if the file
+// would push the user or tenant past limits. sanitization, if set, records
+// the metadata removed from the upload before it was stored.
+func (p *PostgresDB) SaveFile(ctx context.Context, fileID string, owner Owner, metadata *pbv1.FileMetadata, size int64, checksum Checksum, sanitization *Sanitization, limits QuotaLimits) error {
 	fileType := DeriveFileType(metadata.ContentType)
 
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		policy, originalSHA256 *string
+		originalSize           *int64
+		removed                []string
+	)
+	if sanitization != nil {
+		policy, originalSHA256 = &sanitization.Policy, &sanitization.OriginalSHA256
+		originalSize = &sanitization.OriginalSize
+		removed = sanitization.Removed
+		if removed == nil {
+			removed = []string{}
+		}
+	}
+
+	tags := metadata.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+
 	query := `
-        INSERT INTO files (id, user_id, filename, content_type, size, storage_path, uploaded_at, file_type, deleted_at)
-        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+        INSERT INTO files (id, user_id, filename, content_type, size, storage_path, uploaded_at, file_type, deleted_at,
+                           sha256, crc32c, tenant_id, metadata_policy, metadata_removed, original_size, original_sha256,
+                           tags, custom_metadata)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
     `
-	_, err := p.db.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		fileID,
-		metadata.UserId,
+		owner.UserID,
 		metadata.Filename,
 		metadata.ContentType,
 		size,
@@ -43,18 +79,87 @@ func (p *PostgresDB) SaveFile(ctx context.Context, fileID string, metadata *pbv1
 		time.Now(),
 		string(fileType),
 		nil,
+		checksum.SHA256,
+		int64(checksum.CRC32C),
+		owner.TenantID,
+		policy,
+		pq.Array(removed),
+		originalSize,
+		originalSHA256,
+		pq.Array(tags),
+		CustomMetadata(metadata.CustomMetadata),
 	)
+	if err != nil {
+		return err
+	}
+
+	if err := chargeUsage(ctx, tx, QuotaScopeUser, owner.UserID, size, 1, limits.User); err != nil {
+		return err
+	}
+	if owner.TenantID != "" {
+		if err := chargeUsage(ctx, tx, QuotaScopeTenant, owner.TenantID, size, 1, limits.Tenant); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// chargeUsage adds size bytes in files files to an owner's usage if it fits
+// limit. The row lock taken by the UPDATE serializes concurrent uploads.
+func chargeUsage(ctx context.Context, tx *sql.Tx, scope QuotaScope, ownerID string, size, files int64, limit Limit) error {
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO storage_usage (owner_type, owner_id) VALUES ($1, $2)
+        ON CONFLICT (owner_type, owner_id) DO NOTHING
+    `, scope, ownerID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE storage_usage
+        SET bytes_used = bytes_used + $3::BIGINT, file_count = file_count + $6::BIGINT, updated_at = NOW()
+        WHERE owner_type = $1 AND owner_id = $2
+          AND ($4::BIGINT = 0 OR bytes_used + $3::BIGINT <= $4::BIGINT)
+          AND ($5::BIGINT = 0 OR file_count + $6::BIGINT <= $5::BIGINT)
+    `, scope, ownerID, size, limit.MaxBytes, limit.MaxFiles, files)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// releaseUsage gives back one file of size bytes
+func releaseUsage(ctx context.Context, tx *sql.Tx, scope QuotaScope, ownerID string, size int64) error {
+	_, err := tx.ExecContext(ctx, `
+        UPDATE storage_usage
+        SET bytes_used = GREATEST(bytes_used - $3::BIGINT, 0), file_count = GREATEST(file_count - 1, 0), updated_at = NOW()
+        WHERE owner_type = $1 AND owner_id = $2
+    `, scope, ownerID, size)
 	return err
 }
 
 func (p *PostgresDB) GetFile(ctx context.Context, fileID string) (*FileRecord, error) {
 	query := `
-        SELECT id, user_id, filename, content_type, size, storage_path, uploaded_at, deleted_at
+        SELECT id, user_id, filename, content_type, size, storage_path, uploaded_at, deleted_at,
+               COALESCE(sha256, ''), COALESCE(crc32c, 0),
+               metadata_policy, metadata_removed, COALESCE(original_size, 0), COALESCE(original_sha256, ''),
+               tags, custom_metadata
         FROM files
         WHERE id = $1 AND deleted_at IS NULL
     `
 
 	var file FileRecord
+	var crc32c int64
+	var policy sql.NullString
+	var sanitization Sanitization
 	err := p.db.QueryRowContext(ctx, query, fileID).Scan(
 		&file.ID,
 		&file.UserID,
@@ -64,24 +169,98 @@ func (p *PostgresDB) GetFile(ctx context.Context, fileID string) (*FileRecord, e
 		&file.StoragePath,
 		&file.UploadedAt,
 		&file.DeletedAt,
+		&file.Checksum.SHA256,
+		&crc32c,
+		&policy,
+		pq.Array(&sanitization.Removed),
+		&sanitization.OriginalSize,
+		&sanitization.OriginalSHA256,
+		pq.Array(&file.Tags),
+		&file.CustomMetadata,
 	)
 
 	if err == sql.ErrNoRows {
 		return nil, err
 	}
+	file.Checksum.CRC32C = uint32(crc32c)
+	if policy.Valid {
+		sanitization.Policy = policy.String
+		file.Sanitization = &sanitization
+	}
 
 	return &file, err
 }
 
-func (p *PostgresDB) ListFiles(ctx context.Context, userID string, limit, offset int) ([]*FileRecord, error) {
-	query := `
-        SELECT id, user_id, filename, content_type, size, storage_path, uploaded_at
-        FROM files
-        WHERE user_id = $1 AND deleted_at IS NULL
-        ORDER BY uploaded_at DESC
-        LIMIT $2 OFFSET $3
-    `
-	rows, err := p.db.QueryContext(ctx, query, userID, limit, offset)
+// ListFiles returns up to limit of the user's files that match filter, in
+// sort order, starting after the cursor if there is one
+func (p *PostgresDB) ListFiles(ctx context.Context, userID string, filter FileFilter, sort FileSort, after *FileCursor, limit int) ([]*FileRecord, error) {
+	where := []string{"f.user_id = $1", "f.deleted_at IS NULL"}
+	args := []any{userID}
+	// add adds a condition on the next parameter, which cond refers to as %s
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, "$"+strconv.Itoa(len(args))))
+	}
+	if filter.FileType != "" {
+		add("f.file_type = %s", string(filter.FileType))
+	}
+	if filter.ContentTypePrefix != "" {
+		add(`lower(f.content_type) LIKE %s ESCAPE '\'`, likePrefix(strings.ToLower(filter.ContentTypePrefix)))
+	}
+	if filter.MinSize > 0 {
+		add("f.size >= %s", filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		add("f.size <= %s", filter.MaxSize)
+	}
+	if !filter.UploadedAfter.IsZero() {
+		add("f.uploaded_at >= %s", filter.UploadedAfter)
+	}
+	if !filter.UploadedBefore.IsZero() {
+		add("f.uploaded_at < %s", filter.UploadedBefore)
+	}
+	if filter.Status != "" {
+		add("("+fileStatusQuery+") = %s", filter.Status)
+	}
+	if filter.NamePrefix != "" {
+		add(`lower(f.filename) LIKE %s ESCAPE '\'`, likePrefix(strings.ToLower(filter.NamePrefix)))
+	}
+
+	column := "f.uploaded_at"
+	switch sort.Field {
+	case SortByName:
+		column = `lower(f.filename) COLLATE "C"`
+	case SortBySize:
+		column = "f.size"
+	}
+	direction, op := "ASC", ">"
+	if sort.Descending {
+		direction, op = "DESC", "<"
+	}
+	if after != nil {
+		// Compare the cursor's key the way the column is sorted; names are
+		// lowercased by Postgres on both sides
+		var key any = after.UploadedAt
+		keyExpr := "$%d"
+		switch sort.Field {
+		case SortByName:
+			key, keyExpr = after.Name, `lower($%d::TEXT) COLLATE "C"`
+		case SortBySize:
+			key = after.Size
+		}
+		args = append(args, key, after.ID)
+		where = append(where, fmt.Sprintf("(%s, f.id) %s ("+keyExpr+", $%d)", column, op, len(args)-1, len(args)))
+	}
+
+	query := fmt.Sprintf(`
+        SELECT `+fileEntryColumns+`
+        FROM files f
+        `+fileEntryJoins+`
+        WHERE %s
+        ORDER BY %s %s, f.id %s
+        LIMIT $%d
+    `, strings.Join(where, " AND "), column, direction, direction, len(args)+1)
+	rows, err := p.db.QueryContext(ctx, query, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -89,99 +268,1086 @@ func (p *PostgresDB) ListFiles(ctx context.Context, userID string, limit, offset
 
 	var files []*FileRecord
 	for rows.Next() {
-		var f FileRecord
-		if err := rows.Scan(&f.ID, &f.UserID, &f.Name, &f.ContentType, &f.Size, &f.StoragePath, &f.UploadedAt); err != nil {
+		f, err := scanFileEntry(rows)
+		if err != nil {
 			return nil, err
 		}
-		files = append(files, &f)
+		files = append(files, f)
 	}
 	return files, rows.Err()
 }
 
-func (p *PostgresDB) DeleteFile(ctx context.Context, fileID, userID string) error {
+// fileEntryColumns are the columns of file f that listings return, read by
+// scanFileEntry. They need fileEntryJoins.
+const fileEntryColumns = `f.id, f.user_id, f.filename, f.content_type, f.size, f.storage_path, f.uploaded_at,
+               f.tags, f.custom_metadata, COALESCE(h.blurhash, ''), (` + fileStatusQuery + `),
+               d.width, d.height, d.thumbnail`
+
+// fileEntryJoins joins what processing derived from file f. Each is one
+// lateral lookup by the jobs' file_id index, so a page costs one query.
+const fileEntryJoins = `LEFT JOIN LATERAL (` + imageHashesQuery + `) h ON TRUE
+        LEFT JOIN LATERAL (` + thumbnailQuery + `) d ON TRUE`
+
+// scanFileEntry scans fileEntryColumns, followed by extra columns into dest
+func scanFileEntry(rows *sql.Rows, dest ...any) (*FileRecord, error) {
+	var f FileRecord
+	var width, height sql.NullInt64
+	var thumbnail []byte
+	err := rows.Scan(append([]any{
+		&f.ID, &f.UserID, &f.Name, &f.ContentType, &f.Size, &f.StoragePath, &f.UploadedAt,
+		pq.Array(&f.Tags), &f.CustomMetadata, &f.BlurHash, &f.ProcessingStatus,
+		&width, &height, &thumbnail,
+	}, dest...)...)
+	if err != nil {
+		return nil, err
+	}
+	f.Width, f.Height = int(width.Int64), int(height.Int64)
+	if thumbnail != nil {
+		f.Thumbnail = &Artifact{}
+		if err := json.Unmarshal(thumbnail, f.Thumbnail); err != nil {
+			return nil, err
+		}
+	}
+	return &f, nil
+}
+
+// likePrefix is a LIKE pattern (with \ as the escape character) matching
+// strings that start with prefix
+func likePrefix(prefix string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+}
+
+// fileStatusQuery is file f's overall processing status, summarized from its
+// jobs like the service's aggregateState: unfinished jobs win ('processing'
+// once any job has started), then 'failed' if any failed, else 'completed'.
+// A file without jobs is 'completed'.
+const fileStatusQuery = `
+            SELECT CASE
+                       WHEN bool_or(j.status NOT IN ('completed', 'failed', 'dead_letter')) THEN
+                           CASE WHEN bool_or(j.status IN ('processing', 'completed', 'failed', 'dead_letter'))
+                                THEN 'processing' ELSE 'pending' END
+                       WHEN bool_or(j.status IN ('failed', 'dead_letter')) THEN 'failed'
+                       ELSE 'completed'
+                   END
+            FROM processing_jobs j
+            WHERE j.file_id = f.id`
+
+// thumbnailQuery selects file f's dimensions and smallest thumbnail from the
+// completed job that measured it: the "small" preset's if there is one, else
+// the narrowest. Results from before thumbnail kinds existed have untyped
+// small, medium and large thumbnails.
+const thumbnailQuery = `
+            SELECT (j.result->>'width')::INT AS width, (j.result->>'height')::INT AS height,
+                   (SELECT a FROM jsonb_array_elements(j.result->'artifacts') AS a
+                    WHERE a->>'kind' = 'thumbnail'
+                       OR (a->>'kind' IS NULL AND a->>'name' IN ('small', 'medium', 'large'))
+                    ORDER BY a->>'name' = 'small' DESC, (a->>'width')::INT
+                    LIMIT 1) AS thumbnail
+            FROM processing_jobs j
+            WHERE j.file_id = f.id AND j.status = 'completed' AND j.result->'width' IS NOT NULL
+            ORDER BY j.id
+            LIMIT 1`
+
+// imageHashesQuery selects the hashes (JobResult.Hashes) of file f, from
+// whichever completed job computed them
+const imageHashesQuery = `
+            SELECT j.result->'hashes'->>'phash' AS phash,
+                   j.result->'hashes'->>'dhash' AS dhash,
+                   j.result->'hashes'->>'blurhash' AS blurhash
+            FROM processing_jobs j
+            WHERE j.file_id = f.id AND j.status = 'completed' AND j.result->'hashes' IS NOT NULL
+            LIMIT 1`
+
+// FindSimilarImages returns the user's other files whose perceptual hash
+// (hashKey: "phash" or "dhash" in ImageHashes) is at most maxDistance bits
+// from hash, closest first
+func (p *PostgresDB) FindSimilarImages(ctx context.Context, userID, fileID, hashKey, hash string, maxDistance, limit int) ([]*SimilarFile, error) {
+	// Hashes are 16 hex digits; XOR them as bit strings and count the ones
+	query := `
+        SELECT ` + fileEntryColumns + `, f.distance
+        FROM (
+            SELECT f.*,
+                   length(replace(
+                       (('x' || CASE $3 WHEN 'dhash' THEN h.dhash ELSE h.phash END)::BIT(64) # ('x' || $4::TEXT)::BIT(64))::TEXT,
+                       '0', '')) AS distance
+            FROM files f
+            JOIN LATERAL (` + imageHashesQuery + `) h ON TRUE
+            WHERE f.user_id = $1 AND f.id <> $2 AND f.deleted_at IS NULL
+        ) f
+        ` + fileEntryJoins + `
+        WHERE f.distance <= $5
+        ORDER BY f.distance ASC, f.uploaded_at DESC
+        LIMIT $6
+    `
+	rows, err := p.db.QueryContext(ctx, query, userID, fileID, hashKey, hash, maxDistance, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var similar []*SimilarFile
+	for rows.Next() {
+		var distance int
+		f, err := scanFileEntry(rows, &distance)
+		if err != nil {
+			return nil, err
+		}
+		similar = append(similar, &SimilarFile{File: f, Distance: distance})
+	}
+	return similar, rows.Err()
+}
+
+// searchHeadlineOptions picks up to two fragments around the matches for
+// SearchResult.Snippet
+const searchHeadlineOptions = "StartSel=" + SnippetStart + ", StopSel=" + SnippetStop + ", MaxFragments=2, MaxWords=20, MinWords=8"
+
+// SearchFiles returns the user's files matching query (web search syntax)
+// that have all of tags, best match first
+func (p *PostgresDB) SearchFiles(ctx context.Context, userID, query string, tags []string, limit, offset int) ([]*SearchResult, error) {
+	if tags == nil {
+		tags = []string{}
+	}
+	// Ranks are computed for every match, snippets only for the page
+	sqlQuery := `
+        SELECT ` + fileEntryColumns + `, f.rank,
+               ts_headline('english', translate(concat_ws(E'\n',
+                   f.filename,
+                   array_to_string(f.tags, ' '),
+                   (SELECT string_agg(key || ' ' || value, E'\n') FROM jsonb_each_text(f.custom_metadata)),
+                   f.content_text), E'\x02\x03', ''), f.query, $6)
+        FROM (
+            SELECT f.id, f.user_id, f.filename, f.content_type, f.size, f.storage_path, f.uploaded_at,
+                   f.tags, f.custom_metadata, f.content_text, q.query, ts_rank(f.search_vector, q.query) AS rank
+            FROM files f, websearch_to_tsquery('english', $2) AS q(query)
+            WHERE f.user_id = $1 AND f.deleted_at IS NULL
+              AND f.search_vector @@ q.query AND f.tags @> $3::TEXT[]
+            ORDER BY rank DESC, f.uploaded_at DESC, f.id
+            LIMIT $4 OFFSET $5
+        ) f
+        ` + fileEntryJoins + `
+        ORDER BY f.rank DESC, f.uploaded_at DESC, f.id
+    `
+	rows, err := p.db.QueryContext(ctx, sqlQuery, userID, query, pq.Array(tags), limit, offset, searchHeadlineOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []*SearchResult
+	for rows.Next() {
+		var r SearchResult
+		f, err := scanFileEntry(rows, &r.Rank, &r.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		r.File = f
+		results = append(results, &r)
+	}
+	return results, rows.Err()
+}
+
+// DeleteFile soft-deletes a file and releases its usage, cached variants
+// included. It returns the storage keys of the file's cached variants and
+// job artifacts, which the caller removes from storage.
+func (p *PostgresDB) DeleteFile(ctx context.Context, fileID, userID string) ([]string, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
         UPDATE files 
         SET deleted_at = NOW() 
         WHERE id = $1 AND user_id = $2 AND deleted_at IS NULL
+        RETURNING size, tenant_id
     `
-	result, err := p.db.ExecContext(ctx, query, fileID, userID)
+	var (
+		size     int64
+		tenantID string
+	)
+	if err := tx.QueryRowContext(ctx, query, fileID, userID).Scan(&size, &tenantID); err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+        WITH variants AS (
+            DELETE FROM image_variants WHERE file_id = $1 RETURNING storage_key, size
+        )
+        SELECT storage_key, size FROM variants
+        UNION ALL
+        SELECT a->>'path', 0
+        FROM processing_jobs j, jsonb_array_elements(j.result->'artifacts') AS a
+        WHERE j.file_id = $1 AND a->>'path' <> ''
+    `, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var keys []string
+	for rows.Next() {
+		var key string
+		var variantSize int64
+		if err := rows.Scan(&key, &variantSize); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		size += variantSize
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := releaseUsage(ctx, tx, QuotaScopeUser, userID, size); err != nil {
+		return nil, err
+	}
+	if tenantID != "" {
+		if err := releaseUsage(ctx, tx, QuotaScopeTenant, tenantID, size); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, tx.Commit()
+}
+
+// RecordImageVariant records the cached variant of fileID at key and
+// charges its size to the file's owners within limits. A variant recorded
+// before isn't charged again. It returns sql.ErrNoRows if the file has been
+// deleted and ErrQuotaExceeded if the variant doesn't fit.
+func (p *PostgresDB) RecordImageVariant(ctx context.Context, fileID, key string, size int64, limits QuotaLimits) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The lock makes a concurrent DeleteFile wait, or this see its result
+	var userID, tenantID string
+	err = tx.QueryRowContext(ctx, `
+        SELECT user_id, tenant_id FROM files WHERE id = $1 AND deleted_at IS NULL FOR SHARE
+    `, fileID).Scan(&userID, &tenantID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO image_variants (storage_key, file_id, size) VALUES ($1, $2, $3)
+        ON CONFLICT (storage_key) DO NOTHING
+    `, key, fileID, size)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
-	rows, _ := result.RowsAffected()
 	if rows == 0 {
-		return sql.ErrNoRows
+		return nil // Already recorded and charged
 	}
-	return nil
+
+	if err := chargeUsage(ctx, tx, QuotaScopeUser, userID, size, 0, limits.User); err != nil {
+		return err
+	}
+	if tenantID != "" {
+		if err := chargeUsage(ctx, tx, QuotaScopeTenant, tenantID, size, 0, limits.Tenant); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
-func (p *PostgresDB) CreateProcessingJob(ctx context.Context, fileID string) (int64, error) {
+// GetQuotaUsage returns an owner's usage and limit overrides (zero usage if
+// they have never uploaded)
+func (p *PostgresDB) GetQuotaUsage(ctx context.Context, scope QuotaScope, ownerID string) (*QuotaUsage, error) {
+	query := `
+        SELECT COALESCE(u.bytes_used, 0), COALESCE(u.file_count, 0), q.max_bytes, q.max_files
+        FROM (SELECT $1::TEXT AS owner_type, $2::TEXT AS owner_id) o
+        LEFT JOIN storage_usage u ON u.owner_type = o.owner_type AND u.owner_id = o.owner_id
+        LEFT JOIN storage_quotas q ON q.owner_type = o.owner_type AND q.owner_id = o.owner_id
+    `
+	var usage QuotaUsage
+	err := p.db.QueryRowContext(ctx, query, scope, ownerID).Scan(
+		&usage.BytesUsed,
+		&usage.FileCount,
+		&usage.MaxBytes,
+		&usage.MaxFiles,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// SetQuota overrides an owner's limits; nil fields fall back to the server default
+func (p *PostgresDB) SetQuota(ctx context.Context, scope QuotaScope, ownerID string, maxBytes, maxFiles *int64) error {
+	query := `
+        INSERT INTO storage_quotas (owner_type, owner_id, max_bytes, max_files)
+        VALUES ($1, $2, $3, $4)
+        ON CONFLICT (owner_type, owner_id)
+        DO UPDATE SET max_bytes = EXCLUDED.max_bytes, max_files = EXCLUDED.max_files, updated_at = NOW()
+    `
+	_, err := p.db.ExecContext(ctx, query, scope, ownerID, maxBytes, maxFiles)
+	return err
+}
+
+// GetTenantMetadataPolicy returns a tenant's metadata policy, or "" if it has none
+func (p *PostgresDB) GetTenantMetadataPolicy(ctx context.Context, tenantID string) (string, error) {
+	var policy string
+	err := p.db.QueryRowContext(ctx,
+		`SELECT COALESCE(metadata_policy, '') FROM tenant_settings WHERE tenant_id = $1`, tenantID).Scan(&policy)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return policy, err
+}
+
+// SetTenantMetadataPolicy sets a tenant's metadata policy; "" clears it
+func (p *PostgresDB) SetTenantMetadataPolicy(ctx context.Context, tenantID, policy string) error {
+	query := `
+        INSERT INTO tenant_settings (tenant_id, metadata_policy)
+        VALUES ($1, NULLIF($2, ''))
+        ON CONFLICT (tenant_id)
+        DO UPDATE SET metadata_policy = EXCLUDED.metadata_policy, updated_at = NOW()
+    `
+	_, err := p.db.ExecContext(ctx, query, tenantID, policy)
+	return err
+}
+
+// GetTenantVariantLimits returns a tenant's image variant size limits; 0
+// means the tenant has no limit of its own
+func (p *PostgresDB) GetTenantVariantLimits(ctx context.Context, tenantID string) (maxWidth, maxHeight int, err error) {
+	err = p.db.QueryRowContext(ctx, `
+        SELECT COALESCE(variant_max_width, 0), COALESCE(variant_max_height, 0)
+        FROM tenant_settings WHERE tenant_id = $1
+    `, tenantID).Scan(&maxWidth, &maxHeight)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return maxWidth, maxHeight, err
+}
+
+// SetTenantVariantLimits sets a tenant's image variant size limits; 0
+// clears a limit
+func (p *PostgresDB) SetTenantVariantLimits(ctx context.Context, tenantID string, maxWidth, maxHeight int) error {
+	query := `
+        INSERT INTO tenant_settings (tenant_id, variant_max_width, variant_max_height)
+        VALUES ($1, NULLIF($2, 0), NULLIF($3, 0))
+        ON CONFLICT (tenant_id)
+        DO UPDATE SET variant_max_width = EXCLUDED.variant_max_width,
+                      variant_max_height = EXCLUDED.variant_max_height,
+                      updated_at = NOW()
+    `
+	_, err := p.db.ExecContext(ctx, query, tenantID, maxWidth, maxHeight)
+	return err
+}
+
+// CreateProcessingJob queues a job of jobType for a file. A file has at most
+// one job per type.
+func (p *PostgresDB) CreateProcessingJob(ctx context.Context, fileID, jobType string) (int64, error) {
 	var jobID int64
 	query := `
-        INSERT INTO processing_jobs (file_id, status, retry_count, max_retries)
-        VALUES ($1, 'pending', 0, 3)
+        INSERT INTO processing_jobs (file_id, job_type, status, retry_count)
+        VALUES ($1, $2, 'pending', 0)
         RETURNING id
     `
-	err := p.db.QueryRowContext(ctx, query, fileID).Scan(&jobID)
+	err := p.db.QueryRowContext(ctx, query, fileID, jobType).Scan(&jobID)
 	return jobID, err
 }
 
-func (p *PostgresDB) GetNextPendingJob(ctx context.Context) (*ProcessingJob, error) {
+// ClaimNextJob picks the oldest runnable job, marks it processing and leases
+// it to workerID for lease. The row is locked with SKIP LOCKED inside a
+// transaction, so concurrent workers (in this process or on other replicas)
+// never claim the same job. Returns nil if there is nothing to do.
+func (p *PostgresDB) ClaimNextJob(ctx context.Context, workerID string, lease time.Duration) (*ProcessingJob, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
 	query := `
-        SELECT id, file_id, status, retry_count, max_retries, error_message
+        SELECT id, file_id, job_type, status, retry_count, error_message, run_after
         FROM processing_jobs
-        WHERE status = 'pending' AND retry_count < max_retries
-              AND (updated_at < NOW() - INTERVAL '5 minutes' OR retry_count = 0)
-        ORDER BY created_at ASC
+        WHERE status = 'pending' AND run_after <= NOW()
+        ORDER BY run_after ASC, id ASC
         LIMIT 1
         FOR UPDATE SKIP LOCKED
     `
 	var job ProcessingJob
-	var errorMsg sql.NullString
-	err := p.db.QueryRowContext(ctx, query).Scan(
-		&job.ID, &job.FileID, &job.Status, &job.RetryCount, &job.MaxRetries, &errorMsg,
+	err = tx.QueryRowContext(ctx, query).Scan(
+		&job.ID, &job.FileID, &job.JobType, &job.Status, &job.RetryCount, &job.ErrorMessage, &job.RunAfter,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
-	if err == nil && errorMsg.Valid {
-		job.ErrorMessage = &errorMsg.String
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+        UPDATE processing_jobs
+        SET status = 'processing', worker_id = $2, lease_expires_at = NOW() + $3 * INTERVAL '1 second',
+            started_at = NOW(), updated_at = NOW()
+        WHERE id = $1
+        RETURNING lease_expires_at, started_at
+    `, job.ID, workerID, lease.Seconds()).Scan(&job.LeaseExpiresAt, &job.StartedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	job.Status = "processing"
+	job.WorkerID = &workerID
+	return &job, nil
+}
+
+// HeartbeatJob extends workerID's lease on a job. It returns ErrLeaseLost if
+// the job is no longer leased to workerID (e.g. the lease expired and the
+// job was requeued).
+func (p *PostgresDB) HeartbeatJob(ctx context.Context, jobID int64, workerID string, lease time.Duration) error {
+	result, err := p.db.ExecContext(ctx, `
+        UPDATE processing_jobs
+        SET lease_expires_at = NOW() + $3 * INTERVAL '1 second'
+        WHERE id = $1 AND worker_id = $2 AND status = 'processing'
+    `, jobID, workerID, lease.Seconds())
+	if err != nil {
+		return err
+	}
+	return leaseHeld(result)
+}
+
+// RequeueExpiredJobs releases processing jobs whose lease ran out and
+// records the lost attempt. retryAt is given each job, its RetryCount
+// already counting the lost attempt, and returns when it may run again, or
+// nil to dead-letter it. Jobs from before leases existed (no lease at all)
+// are treated as expired. It returns the jobs with their new status and the
+// worker that lost them.
+func (p *PostgresDB) RequeueExpiredJobs(ctx context.Context, limit int, retryAt func(job *ProcessingJob) *time.Time) ([]*ProcessingJob, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT id, file_id, job_type, retry_count, worker_id, started_at
+        FROM processing_jobs
+        WHERE status = 'processing' AND (lease_expires_at IS NULL OR lease_expires_at < NOW())
+        ORDER BY lease_expires_at ASC NULLS FIRST
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []*ProcessingJob
+	for rows.Next() {
+		var job ProcessingJob
+		if err := rows.Scan(&job.ID, &job.FileID, &job.JobType, &job.RetryCount, &job.WorkerID, &job.StartedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, job := range jobs {
+		owner := "unknown"
+		if job.WorkerID != nil {
+			owner = *job.WorkerID
+		}
+		errorMsg := "lease expired on worker " + owner
+		job.ErrorMessage = &errorMsg
+		job.RetryCount++
+
+		job.Status = "dead_letter"
+		at := retryAt(job)
+		if at != nil {
+			job.Status = "pending"
+			job.RunAfter = *at
+		}
+
+		_, err := tx.ExecContext(ctx, `
+            UPDATE processing_jobs
+            SET status = $2, retry_count = $3, error_message = $4, run_after = COALESCE($5, run_after),
+                worker_id = NULL, lease_expires_at = NULL, started_at = NULL, updated_at = NOW()
+            WHERE id = $1
+        `, job.ID, job.Status, job.RetryCount, errorMsg, at)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.ExecContext(ctx, `
+            INSERT INTO processing_job_attempts (job_id, worker_id, started_at, error_message)
+            VALUES ($1, $2, $3, $4)
+        `, job.ID, job.WorkerID, job.StartedAt, errorMsg)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return &job, err
+	return jobs, tx.Commit()
 }
 
-func (p *PostgresDB) UpdateJobStatus(ctx context.Context, jobID int64, status, errorMsg string) error {
+// UpdateJobStatus moves a job to status and releases its lease. With a
+// workerID it only applies while that worker holds the lease (ErrLeaseLost
+// otherwise); an empty workerID updates unconditionally.
+func (p *PostgresDB) UpdateJobStatus(ctx context.Context, jobID int64, workerID, status, errorMsg string) error {
 	query := `
         UPDATE processing_jobs
-        SET status = $1, error_message = $2, retry_count = retry_count + 1, updated_at = NOW()
-        WHERE id = $3
+        SET status = $1, error_message = NULLIF($2, ''), updated_at = NOW(),
+            worker_id = NULL, lease_expires_at = NULL
+        WHERE id = $3 AND ($4 = '' OR (worker_id = $4 AND status = 'processing'))
     `
-	_, err := p.db.ExecContext(ctx, query, status, errorMsg, jobID)
-	return err
+	result, err := p.db.ExecContext(ctx, query, status, errorMsg, jobID, workerID)
+	if err != nil {
+		return err
+	}
+	if workerID == "" {
+		return nil
+	}
+	return leaseHeld(result)
+}
+
+// FailJobAttempt records a failed attempt and releases the lease. The job is
+// retried after retryAt, or moved to dead_letter if retryAt is nil. workerID
+// works as in UpdateJobStatus.
+func (p *PostgresDB) FailJobAttempt(ctx context.Context, jobID int64, workerID, errorMsg string, retryAt *time.Time) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	status := "dead_letter"
+	if retryAt != nil {
+		status = "pending"
+	}
+
+	var (
+		owner     *string
+		startedAt *time.Time
+	)
+	err = tx.QueryRowContext(ctx, `
+        WITH current AS (
+            SELECT id, worker_id, started_at FROM processing_jobs WHERE id = $1 FOR UPDATE
+        )
+        UPDATE processing_jobs j
+        SET status = $2, error_message = $3, retry_count = j.retry_count + 1,
+            run_after = COALESCE($4, j.run_after), updated_at = NOW(),
+            worker_id = NULL, lease_expires_at = NULL, started_at = NULL
+        FROM current
+        WHERE j.id = current.id AND ($5 = '' OR (current.worker_id = $5 AND j.status = 'processing'))
+        RETURNING current.worker_id, current.started_at
+    `, jobID, status, errorMsg, retryAt, workerID).Scan(&owner, &startedAt)
+	if err == sql.ErrNoRows {
+		if workerID == "" {
+			return sql.ErrNoRows
+		}
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO processing_job_attempts (job_id, worker_id, started_at, error_message)
+        VALUES ($1, $2, $3, $4)
+    `, jobID, owner, startedAt, errorMsg)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (p *PostgresDB) CompleteJob(ctx context.Context, jobID int64, thumbSmall, thumbMed, thumbLarge string, width, height int) error {
+// ReleaseJob hands a job back to the queue without counting an attempt,
+// for jobs a worker stopped running because it is shutting down. workerID
+// works as in UpdateJobStatus.
+func (p *PostgresDB) ReleaseJob(ctx context.Context, jobID int64, workerID string) error {
 	query := `
         UPDATE processing_jobs
-        SET status = 'completed', thumbnail_small = $1, thumbnail_medium = $2, thumbnail_large = $3,
-            original_width = $4, original_height = $5, completed_at = NOW(), updated_at = NOW()
-        WHERE id = $6
+        SET status = 'pending', updated_at = NOW(),
+            worker_id = NULL, lease_expires_at = NULL, started_at = NULL
+        WHERE id = $1 AND ($2 = '' OR (worker_id = $2 AND status = 'processing'))
     `
-	_, err := p.db.ExecContext(ctx, query, thumbSmall, thumbMed, thumbLarge, width, height, jobID)
-	return err
+	result, err := p.db.ExecContext(ctx, query, jobID, workerID)
+	if err != nil {
+		return err
+	}
+	if workerID == "" {
+		return nil
+	}
+	return leaseHeld(result)
 }
 
-func (p *PostgresDB) GetJobByFileID(ctx context.Context, fileID string) (*ProcessingJob, error) {
+// CompleteJob stores a job's result and releases its lease. Text the job
+// extracted is indexed for SearchFiles, up to MaxSearchTextBytes. workerID
+// works as in UpdateJobStatus.
+func (p *PostgresDB) CompleteJob(ctx context.Context, jobID int64, workerID string, result *JobResult) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	query := `
-        SELECT id, file_id, status, error_message, thumbnail_small, thumbnail_medium, thumbnail_large,
-               original_width, original_height
+        UPDATE processing_jobs
+        SET status = 'completed', result = $1, completed_at = NOW(), updated_at = NOW(),
+            worker_id = NULL, lease_expires_at = NULL
+        WHERE id = $2 AND ($3 = '' OR (worker_id = $3 AND status = 'processing'))
+        RETURNING file_id
+    `
+	var fileID string
+	err = tx.QueryRowContext(ctx, query, result, jobID, workerID).Scan(&fileID)
+	if err == sql.ErrNoRows {
+		if workerID == "" {
+			return nil
+		}
+		return ErrLeaseLost
+	}
+	if err != nil {
+		return err
+	}
+
+	if text := result.SearchText(); text != "" {
+		text = strings.ToValidUTF8(truncateUTF8(text, MaxSearchTextBytes), "")
+		text = strings.ReplaceAll(text, "\x00", "")
+		if _, err := tx.ExecContext(ctx, `UPDATE files SET content_text = $1 WHERE id = $2`, text, fileID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// leaseHeld turns "no row matched the worker's lease" into ErrLeaseLost
+func leaseHeld(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// ListJobsByFileID returns every processing job of a file, by job type
+func (p *PostgresDB) ListJobsByFileID(ctx context.Context, fileID string) ([]*ProcessingJob, error) {
+	query := `
+        SELECT ` + jobColumns + `
         FROM processing_jobs
         WHERE file_id = $1
+        ORDER BY job_type ASC
     `
+	return p.queryJobs(ctx, query, fileID)
+}
+
+// jobColumns are read by scanJob
+const jobColumns = `
+        id, file_id, job_type, status, retry_count, error_message, result, worker_id,
+        lease_expires_at, run_after, started_at, created_at, updated_at, completed_at`
+
+func scanJob(row interface{ Scan(...any) error }) (*ProcessingJob, error) {
 	var job ProcessingJob
-	err := p.db.QueryRowContext(ctx, query, fileID).Scan(
-		&job.ID, &job.FileID, &job.Status, &job.ErrorMessage, &job.ThumbnailSmall, &job.ThumbnailMedium,
-		&job.ThumbnailLarge, &job.OriginalWidth, &job.OriginalHeight,
+	err := row.Scan(
+		&job.ID, &job.FileID, &job.JobType, &job.Status, &job.RetryCount, &job.ErrorMessage, &job.Result, &job.WorkerID,
+		&job.LeaseExpiresAt, &job.RunAfter, &job.StartedAt, &job.CreatedAt, &job.UpdatedAt, &job.CompletedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func (p *PostgresDB) GetJob(ctx context.Context, jobID int64) (*ProcessingJob, error) {
+	query := `SELECT ` + jobColumns + ` FROM processing_jobs WHERE id = $1`
+	return scanJob(p.db.QueryRowContext(ctx, query, jobID))
+}
+
+// ListDeadLetterJobs returns dead-lettered jobs newest first, starting after
+// job ID beforeID (0 = from the newest)
+func (p *PostgresDB) ListDeadLetterJobs(ctx context.Context, beforeID int64, limit int) ([]*ProcessingJob, error) {
+	query := `
+        SELECT ` + jobColumns + `
+        FROM processing_jobs
+        WHERE status = 'dead_letter' AND ($1::BIGINT = 0 OR id < $1)
+        ORDER BY id DESC
+        LIMIT $2
+    `
+	return p.queryJobs(ctx, query, beforeID, limit)
+}
+
+func (p *PostgresDB) queryJobs(ctx context.Context, query string, args ...any) ([]*ProcessingJob, error) {
+	rows, err := p.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*ProcessingJob
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, rows.Err()
+}
+
+// ListJobAttempts returns a job's failed attempts, oldest first
+func (p *PostgresDB) ListJobAttempts(ctx context.Context, jobID int64) ([]*JobAttempt, error) {
+	query := `
+        SELECT worker_id, started_at, finished_at, error_message
+        FROM processing_job_attempts
+        WHERE job_id = $1
+        ORDER BY id ASC
+    `
+	rows, err := p.db.QueryContext(ctx, query, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attempts []*JobAttempt
+	for rows.Next() {
+		var attempt JobAttempt
+		if err := rows.Scan(&attempt.WorkerID, &attempt.StartedAt, &attempt.FinishedAt, &attempt.ErrorMessage); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, &attempt)
+	}
+	return attempts, rows.Err()
+}
+
+// RequeueJob gives a dead-lettered job a fresh set of attempts. Its attempt
+// history is kept. Returns sql.ErrNoRows if the job isn't dead-lettered.
+func (p *PostgresDB) RequeueJob(ctx context.Context, jobID int64) error {
+	result, err := p.db.ExecContext(ctx, `
+        UPDATE processing_jobs
+        SET status = 'pending', retry_count = 0, run_after = NOW(), updated_at = NOW()
+        WHERE id = $1 AND status = 'dead_letter'
+    `, jobID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// CreateUploadSession inserts a session claimed by session.ClaimID. It
+// returns ErrUploadSessionClaimed if another stream created it first.
+func (p *PostgresDB) CreateUploadSession(ctx context.Context, session *UploadSession) error {
+	query := `
+        INSERT INTO upload_sessions (upload_id, user_id, filename, content_type, size, storage_path, expires_at,
+                                     claim_id, active_until)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, NULLIF($8, ''), NOW() + $9 * INTERVAL '1 second')
+        ON CONFLICT (upload_id) DO NOTHING
+    `
+	result, err := p.db.ExecContext(ctx, query,
+		session.UploadID,
+		session.UserID,
+		session.Filename,
+		session.ContentType,
+		session.Size,
+		session.StoragePath,
+		session.ExpiresAt,
+		session.ClaimID,
+		session.ClaimTTL.Seconds(),
+	)
+	if err != nil {
+		return err
+	}
+	return sessionClaimed(result)
+}
+
+// ClaimUploadSession gives claimID the session for ttl, unless another
+// claim on it is still active (ErrUploadSessionClaimed)
+func (p *PostgresDB) ClaimUploadSession(ctx context.Context, uploadID, claimID string, ttl time.Duration) error {
+	result, err := p.db.ExecContext(ctx, `
+        UPDATE upload_sessions
+        SET claim_id = $2, active_until = NOW() + $3 * INTERVAL '1 second', updated_at = NOW()
+        WHERE upload_id = $1 AND (active_until IS NULL OR active_until < NOW())
+    `, uploadID, claimID, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	return sessionClaimed(result)
+}
+
+// RenewUploadSessionClaim extends claimID's claim by ttl. It returns
+// ErrUploadSessionClaimed if the claim lapsed and another stream took over.
+func (p *PostgresDB) RenewUploadSessionClaim(ctx context.Context, uploadID, claimID string, ttl time.Duration) error {
+	result, err := p.db.ExecContext(ctx, `
+        UPDATE upload_sessions
+        SET active_until = NOW() + $3 * INTERVAL '1 second'
+        WHERE upload_id = $1 AND claim_id = $2
+    `, uploadID, claimID, ttl.Seconds())
+	if err != nil {
+		return err
+	}
+	return sessionClaimed(result)
+}
+
+// ReleaseUploadSession ends claimID's claim, if it still holds it
+func (p *PostgresDB) ReleaseUploadSession(ctx context.Context, uploadID, claimID string) error {
+	_, err := p.db.ExecContext(ctx, `
+        UPDATE upload_sessions
+        SET claim_id = NULL, active_until = NULL
+        WHERE upload_id = $1 AND claim_id = $2
+    `, uploadID, claimID)
+	return err
+}
+
+func sessionClaimed(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrUploadSessionClaimed
+	}
+	return nil
+}
+
+func (p *PostgresDB) GetUploadSession(ctx context.Context, uploadID string) (*UploadSession, error) {
+	query := `
+        SELECT upload_id, user_id, filename, content_type, size, storage_path, created_at, updated_at, expires_at
+        FROM upload_sessions
+        WHERE upload_id = $1
+    `
+	var session UploadSession
+	err := p.db.QueryRowContext(ctx, query, uploadID).Scan(
+		&session.UploadID,
+		&session.UserID,
+		&session.Filename,
+		&session.ContentType,
+		&session.Size,
+		&session.StoragePath,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+		&session.ExpiresAt,
 	)
-	return &job, err
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (p *PostgresDB) ExtendUploadSession(ctx context.Context, uploadID string, expiresAt time.Time) error {
+	query := `
+        UPDATE upload_sessions
+        SET expires_at = $1, updated_at = NOW()
+        WHERE upload_id = $2
+    `
+	_, err := p.db.ExecContext(ctx, query, expiresAt, uploadID)
+	return err
+}
+
+func (p *PostgresDB) DeleteUploadSession(ctx context.Context, uploadID string) error {
+	_, err := p.db.ExecContext(ctx, `DELETE FROM upload_sessions WHERE upload_id = $1`, uploadID)
+	return err
+}
+
+func (p *PostgresDB) ListExpiredUploadSessions(ctx context.Context, limit int) ([]*UploadSession, error) {
+	query := `
+        SELECT upload_id, user_id, filename, content_type, size, storage_path, created_at, updated_at, expires_at
+        FROM upload_sessions
+        WHERE expires_at < NOW()
+        ORDER BY expires_at ASC
+        LIMIT $1
+    `
+	rows, err := p.db.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []*UploadSession
+	for rows.Next() {
+		var s UploadSession
+		if err := rows.Scan(&s.UploadID, &s.UserID, &s.Filename, &s.ContentType, &s.Size,
+			&s.StoragePath, &s.CreatedAt, &s.UpdatedAt, &s.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &s)
+	}
+	return sessions, rows.Err()
+}
+
+const apiKeyColumns = `id, owner_id, name, prefix, key_hash, scopes, tenant_id, created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (*APIKey, error) {
+	var key APIKey
+	err := row.Scan(
+		&key.ID,
+		&key.OwnerID,
+		&key.Name,
+		&key.Prefix,
+		&key.KeyHash,
+		pq.Array(&key.Scopes),
+		&key.TenantID,
+		&key.CreatedAt,
+		&key.ExpiresAt,
+		&key.LastUsedAt,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (p *PostgresDB) CreateAPIKey(ctx context.Context, key *APIKey) error {
+	query := `
+        INSERT INTO api_keys (id, owner_id, name, prefix, key_hash, scopes, expires_at, tenant_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING created_at
+    `
+	return p.db.QueryRowContext(ctx, query,
+		key.ID,
+		key.OwnerID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+		key.TenantID,
+	).Scan(&key.CreatedAt)
+}
+
+// GetAPIKeyByHash looks up a key by the hash of its secret, including revoked
+// and expired keys; callers decide whether the key is still usable
+func (p *PostgresDB) GetAPIKeyByHash(ctx context.Context, keyHash []byte) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE key_hash = $1`
+	return scanAPIKey(p.db.QueryRowContext(ctx, query, keyHash))
+}
+
+func (p *PostgresDB) GetAPIKey(ctx context.Context, keyID string) (*APIKey, error) {
+	query := `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = $1`
+	return scanAPIKey(p.db.QueryRowContext(ctx, query, keyID))
+}
+
+// ListAPIKeys returns keys newest first; an empty ownerID lists every owner
+func (p *PostgresDB) ListAPIKeys(ctx context.Context, ownerID string, includeRevoked bool) ([]*APIKey, error) {
+	query := `
+        SELECT ` + apiKeyColumns + `
+        FROM api_keys
+        WHERE ($1 = '' OR owner_id = $1) AND ($2 OR revoked_at IS NULL)
+        ORDER BY created_at DESC
+    `
+	rows, err := p.db.QueryContext(ctx, query, ownerID, includeRevoked)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes a key; it returns sql.ErrNoRows if the key doesn't
+// exist or was already revoked
+func (p *PostgresDB) RevokeAPIKey(ctx context.Context, keyID string) error {
+	result, err := p.db.ExecContext(ctx,
+		`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, keyID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RotateAPIKey stores replacement and retires the old key in one transaction.
+// The old key keeps working until graceUntil (revoked immediately if nil).
+func (p *PostgresDB) RotateAPIKey(ctx context.Context, oldKeyID string, replacement *APIKey, graceUntil *time.Time) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var result sql.Result
+	if graceUntil == nil {
+		result, err = tx.ExecContext(ctx,
+			`UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND revoked_at IS NULL`, oldKeyID)
+	} else {
+		result, err = tx.ExecContext(ctx, `
+            UPDATE api_keys SET expires_at = LEAST(COALESCE(expires_at, $2), $2)
+            WHERE id = $1 AND revoked_at IS NULL
+        `, oldKeyID, *graceUntil)
+	}
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO api_keys (id, owner_id, name, prefix, key_hash, scopes, expires_at, tenant_id)
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+        RETURNING created_at
+    `,
+		replacement.ID,
+		replacement.OwnerID,
+		replacement.Name,
+		replacement.Prefix,
+		replacement.KeyHash,
+		pq.Array(replacement.Scopes),
+		replacement.ExpiresAt,
+		replacement.TenantID,
+	).Scan(&replacement.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TouchAPIKey records that a key was used. Writes are throttled to one per
+// minute per key so busy clients don't turn every RPC into an UPDATE.
+func (p *PostgresDB) TouchAPIKey(ctx context.Context, keyID string) error {
+	_, err := p.db.ExecContext(ctx, `
+        UPDATE api_keys SET last_used_at = NOW()
+        WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+    `, keyID)
+	return err
 }
@@ -1,6 +1,10 @@
 package database
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -15,8 +19,176 @@ type FileRecord struct {
 	UploadedAt  time.Time
 	DeletedAt   *time.Time
 	FileType    FileType
+	Checksum    Checksum
+	// Sanitization is set if metadata was removed before the file was stored
+	Sanitization *Sanitization
+	// Set only by the listing queries (ListFiles, FindSimilarImages,
+	// SearchFiles), from the file's processing jobs:
+	// BlurHash is the image's placeholder once hashed
+	BlurHash string
+	// ProcessingStatus is the overall status of the jobs, as in FileFilter
+	ProcessingStatus string
+	// Width and Height are the original's, and Thumbnail the smallest
+	// thumbnail, once a job has measured the file
+	Width, Height int
+	Thumbnail     *Artifact
+	// Tags and CustomMetadata are set by the uploader
+	Tags           []string
+	CustomMetadata CustomMetadata
 }
 
+// CustomMetadata is a file's application-defined key/value pairs, stored as
+// JSONB
+type CustomMetadata map[string]string
+
+func (m CustomMetadata) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *CustomMetadata) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	}
+	return fmt.Errorf("cannot scan %T into CustomMetadata", src)
+}
+
+// SearchResult is a file found by SearchFiles
+type SearchResult struct {
+	File *FileRecord
+	Rank float64
+	// Snippet is an excerpt around the matches, each wrapped in
+	// SnippetStart and SnippetStop
+	Snippet string
+}
+
+// Markers around matches in SearchResult.Snippet. They are control
+// characters so they can't be confused with markup in the text, which has
+// them removed.
+const (
+	SnippetStart = "\x02"
+	SnippetStop  = "\x03"
+)
+
+// MaxSearchTextBytes is how much extracted text (JobResult.Text) is indexed
+// per file
+const MaxSearchTextBytes = 256 << 10
+
+// SimilarFile is a file found by FindSimilarImages
+type SimilarFile struct {
+	File     *FileRecord
+	Distance int // Hamming distance between the perceptual hashes
+}
+
+// FileFilter narrows ListFiles. Zero fields don't filter.
+type FileFilter struct {
+	FileType          FileType
+	ContentTypePrefix string
+	MinSize, MaxSize  int64 // Inclusive
+	// UploadedAfter is inclusive, UploadedBefore exclusive
+	UploadedAfter, UploadedBefore time.Time
+	// Status is the file's overall processing status: "pending",
+	// "processing", "completed" or "failed"
+	Status     string
+	NamePrefix string // Case-insensitive
+}
+
+// FileSortField is a column ListFiles can sort by
+type FileSortField string
+
+const (
+	SortByUploadedAt FileSortField = "uploaded_at"
+	SortByName       FileSortField = "filename"
+	SortBySize       FileSortField = "size"
+)
+
+// FileSort orders ListFiles. Names sort case-insensitively by code point.
+// Ties are broken by file ID in the same direction, so the order is total
+// and a FileCursor pages through it without repeats or gaps.
+type FileSort struct {
+	Field      FileSortField
+	Descending bool
+}
+
+// FileCursor is a position in a ListFiles order: the sort key and ID of the
+// last file of a page. Only the key of the sort's field is used.
+type FileCursor struct {
+	UploadedAt time.Time
+	Name       string
+	Size       int64
+	ID         string
+}
+
+// CursorAfter is the position just after f
+func CursorAfter(f *FileRecord) FileCursor {
+	return FileCursor{UploadedAt: f.UploadedAt, Name: f.Name, Size: f.Size, ID: f.ID}
+}
+
+// Sanitization records how an upload was rewritten to remove metadata
+type Sanitization struct {
+	Policy         string   // sanitize.Policy name
+	Removed        []string // Kinds of metadata removed, e.g. "gps"
+	OriginalSize   int64
+	OriginalSHA256 string
+}
+
+// Checksum holds the digests computed while a file was streamed to storage
+type Checksum struct {
+	SHA256 string // lowercase hex; empty for files stored before checksums
+	CRC32C uint32
+}
+
+// Owner identifies who a file is charged to
+type Owner struct {
+	UserID   string
+	TenantID string // Empty when the caller isn't part of a tenant
+}
+
+// QuotaScope says whether a quota or usage row belongs to a user or a tenant
+type QuotaScope string
+
+const (
+	QuotaScopeUser   QuotaScope = "user"
+	QuotaScopeTenant QuotaScope = "tenant"
+)
+
+// Limit caps total bytes and file count; 0 means unlimited
+type Limit struct {
+	MaxBytes int64
+	MaxFiles int64
+}
+
+// QuotaLimits are the limits SaveFile enforces for a file's owner
+type QuotaLimits struct {
+	User   Limit
+	Tenant Limit // Ignored when the owner has no tenant
+}
+
+// QuotaUsage is an owner's current usage plus any per-owner limit overrides
+type QuotaUsage struct {
+	BytesUsed int64
+	FileCount int64
+	MaxBytes  *int64 // nil = use the server default
+	MaxFiles  *int64
+}
+
+// ErrLeaseLost is returned when a worker updates a job it no longer holds the lease on
+var ErrLeaseLost = errors.New("processing job lease lost")
+
+// ErrUploadSessionClaimed is returned when another stream holds an upload session
+var ErrUploadSessionClaimed = errors.New("upload session is in use by another stream")
+
+// ErrQuotaExceeded is returned by SaveFile when the file doesn't fit the owner's quota
+var ErrQuotaExceeded = errors.New("storage quota exceeded")
+
 type FileType string
 
 const (
@@ -24,24 +196,206 @@ const (
 	FileTypeVideo    FileType = "video"
 	FileTypeAudio    FileType = "audio"
 	FileTypeDocument FileType = "document"
+	FileTypeArchive  FileType = "archive"
 	FileTypeOther    FileType = "other"
 )
 
 type ProcessingJob struct {
-	ID              int64
-	FileID          string
-	Status          string
-	RetryCount      int
-	MaxRetries      int
-	ErrorMessage    *string
-	ThumbnailSmall  string
-	ThumbnailMedium string
-	ThumbnailLarge  string
-	OriginalWidth   int
-	OriginalHeight  int
-	CreatedAt       time.Time
-	UpdatedAt       time.Time
-	CompletedAt     *time.Time
+	ID             int64
+	FileID         string
+	JobType        string // Which processor runs it, e.g. "thumbnail"
+	Status         string
+	RetryCount     int        // Failed attempts so far
+	ErrorMessage   *string    // Last attempt's error
+	Result         *JobResult // Set once completed
+	WorkerID       *string    // Worker holding the lease while processing
+	LeaseExpiresAt *time.Time // Requeued by the lease reaper after this
+	RunAfter       time.Time  // Not claimed before this (retry backoff)
+	StartedAt      *time.Time // When the current attempt was claimed
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	CompletedAt    *time.Time
+}
+
+// JobResult is what a processor derived from a file, stored as JSONB
+type JobResult struct {
+	// Dimensions of the original as displayed, for files that have them
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+	// Processor-specific values, e.g. EXIF fields or a page count
+	Attributes map[string]string `json:"attributes,omitempty"`
+	// Files written to storage, e.g. thumbnails
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// EXIF/XMP metadata of images
+	Image *ImageMetadata `json:"image,omitempty"`
+	// Perceptual hashes and placeholder of images
+	Hashes *ImageHashes `json:"hashes,omitempty"`
+	// Page count, document info and text of PDFs
+	Document *DocumentMetadata `json:"document,omitempty"`
+	// Text extracted from text and JSON files. CompleteJob indexes it for
+	// SearchFiles rather than storing it with the result.
+	Text string `json:"-"`
+}
+
+// SearchText is the text r extracted from its file, if any
+func (r *JobResult) SearchText() string {
+	if r == nil {
+		return ""
+	}
+	if r.Text != "" || r.Document == nil {
+		return r.Text
+	}
+	texts := make([]string, 0, len(r.Document.Pages))
+	for _, page := range r.Document.Pages {
+		if page.Text != "" {
+			texts = append(texts, page.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// DocumentMetadata is what DocumentProcessor reads from a PDF
+type DocumentMetadata struct {
+	PageCount  int        `json:"page_count"`
+	Title      string     `json:"title,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Subject    string     `json:"subject,omitempty"`
+	Keywords   string     `json:"keywords,omitempty"`
+	Creator    string     `json:"creator,omitempty"`  // Application the original was made with
+	Producer   string     `json:"producer,omitempty"` // Application that wrote the PDF
+	CreatedAt  *time.Time `json:"created_at,omitempty"`
+	ModifiedAt *time.Time `json:"modified_at,omitempty"`
+	// Pages from the first, up to the processor's page limit
+	Pages []DocumentPage `json:"pages,omitempty"`
+}
+
+type DocumentPage struct {
+	Number int     `json:"number"` // From 1
+	Width  float64 `json:"width"`  // Points (1/72 inch), as displayed
+	Height float64 `json:"height"`
+	Text   string  `json:"text,omitempty"`
+	// TextTruncated is set when the processor's text limit cut the text short
+	TextTruncated bool `json:"text_truncated,omitempty"`
+}
+
+// ImageHashes identify an image by its content. The perceptual hashes are
+// 16 hex digits (imagehash.Hash); near duplicates differ in few bits.
+type ImageHashes struct {
+	PHash    string `json:"phash"`
+	DHash    string `json:"dhash"`
+	BlurHash string `json:"blurhash"`
+}
+
+// ImageMetadata is what ImageProcessor reads from an image's EXIF and XMP
+type ImageMetadata struct {
+	CapturedAt   *time.Time        `json:"captured_at,omitempty"`
+	CameraMake   string            `json:"camera_make,omitempty"`
+	CameraModel  string            `json:"camera_model,omitempty"`
+	LensModel    string            `json:"lens_model,omitempty"`
+	Software     string            `json:"software,omitempty"`
+	Orientation  int               `json:"orientation,omitempty"`   // EXIF tag (1-8); thumbnails are already upright
+	ExposureTime string            `json:"exposure_time,omitempty"` // Seconds, e.g. "1/250"
+	FNumber      float64           `json:"f_number,omitempty"`
+	ISO          int               `json:"iso,omitempty"`
+	FocalLength  float64           `json:"focal_length,omitempty"` // Millimeters
+	GPS          *GPSPosition      `json:"gps,omitempty"`
+	XMP          map[string]string `json:"xmp,omitempty"` // Selected properties, e.g. "dc:title"
+}
+
+type GPSPosition struct {
+	Latitude  float64  `json:"latitude"`
+	Longitude float64  `json:"longitude"`
+	Altitude  *float64 `json:"altitude,omitempty"` // Meters above sea level
+}
+
+// Artifact kinds
+const (
+	ArtifactThumbnail = "thumbnail" // Made per thumbnail preset
+	ArtifactPreview   = "preview"   // e.g. animated GIF and document previews
+)
+
+// Artifact is a file a processor derived from an upload
+type Artifact struct {
+	Name        string `json:"name"`           // Unique per file, e.g. "small"
+	Kind        string `json:"kind,omitempty"` // e.g. ArtifactThumbnail
+	Path        string `json:"path"`           // Storage key
+	ContentType string `json:"content_type,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+}
+
+// Artifact returns the artifact called name, or nil
+func (r *JobResult) Artifact(name string) *Artifact {
+	if r == nil {
+		return nil
+	}
+	for i := range r.Artifacts {
+		if r.Artifacts[i].Name == name {
+			return &r.Artifacts[i]
+		}
+	}
+	return nil
+}
+
+func (r *JobResult) Value() (driver.Value, error) {
+	if r == nil {
+		return nil, nil
+	}
+	return json.Marshal(r)
+}
+
+func (r *JobResult) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		*r = JobResult{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, r)
+	case string:
+		return json.Unmarshal([]byte(v), r)
+	}
+	return fmt.Errorf("cannot scan %T into JobResult", src)
+}
+
+// JobAttempt records one failed run of a processing job
+type JobAttempt struct {
+	WorkerID     *string
+	StartedAt    *time.Time
+	FinishedAt   time.Time
+	ErrorMessage string
+}
+
+// UploadSession tracks a resumable upload whose bytes are still being received
+type UploadSession struct {
+	UploadID    string
+	UserID      string
+	Filename    string
+	ContentType string
+	Size        int64
+	StoragePath string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	ExpiresAt   time.Time
+
+	// ClaimID is the stream writing the session; CreateUploadSession sets
+	// the claim for ClaimTTL
+	ClaimID  string
+	ClaimTTL time.Duration
+}
+
+// APIKey is an issued key; only its SHA-256 hash is stored
+type APIKey struct {
+	ID         string
+	OwnerID    string
+	Name       string
+	Prefix     string
+	KeyHash    []byte
+	Scopes     []string
+	TenantID   string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
 }
 
 func DeriveFileType(contentType string) FileType {
@@ -57,5 +411,10 @@ func DeriveFileType(contentType string) FileType {
 	if strings.Contains(contentType, "pdf") {
 		return FileTypeDocument
 	}
+	switch contentType {
+	case "application/zip", "application/gzip", "application/x-tar", "application/x-7z-compressed",
+		"application/vnd.rar", "application/x-rar-compressed", "application/x-bzip2", "application/x-xz":
+		return FileTypeArchive
+	}
 	return FileTypeOther
 }
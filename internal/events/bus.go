@@ -0,0 +1,105 @@
+package events
+
+import (
+	"sync"
+)
+
+// JobEvent says a processing job changed state. It carries only identifiers;
+// subscribers re-read the job to get its current state and results.
+type JobEvent struct {
+	JobID  int64  `json:"job_id"`
+	FileID string `json:"file_id"`
+	Status string `json:"status"`
+}
+
+// Bus fans job events out to subscribers watching individual files, and to
+// subscribers that want every event (the processing workers)
+type Bus struct {
+	mu   sync.Mutex
+	subs map[string]map[chan JobEvent]struct{} // file ID -> subscribers
+	all  map[chan JobEvent]struct{}
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subs: make(map[string]map[chan JobEvent]struct{}),
+		all:  make(map[chan JobEvent]struct{}),
+	}
+}
+
+// Subscribe returns a channel of events for fileID and a func to unsubscribe.
+//
+// The channel holds one pending event. Publishing to a full channel drops the
+// event, so a slow subscriber sees the latest change rather than every step;
+// subscribers should treat an event as "re-read the job".
+func (b *Bus) Subscribe(fileID string) (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, 1)
+
+	b.mu.Lock()
+	if b.subs[fileID] == nil {
+		b.subs[fileID] = make(map[chan JobEvent]struct{})
+	}
+	b.subs[fileID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs[fileID], ch)
+		if len(b.subs[fileID]) == 0 {
+			delete(b.subs, fileID)
+		}
+	}
+	return ch, unsubscribe
+}
+
+// SubscribeAll is like Subscribe but receives events for every file
+func (b *Bus) SubscribeAll() (<-chan JobEvent, func()) {
+	ch := make(chan JobEvent, 1)
+
+	b.mu.Lock()
+	b.all[ch] = struct{}{}
+	b.mu.Unlock()
+
+	unsubscribe := func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.all, ch)
+	}
+	return ch, unsubscribe
+}
+
+// Publish delivers ev to everyone watching ev.FileID without blocking
+func (b *Bus) Publish(ev JobEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs[ev.FileID] {
+		notify(ch, ev)
+	}
+	for ch := range b.all {
+		notify(ch, ev)
+	}
+}
+
+// Resync wakes every subscriber, e.g. after events may have been missed
+// while the database connection was down
+func (b *Bus) Resync() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for fileID, subs := range b.subs {
+		for ch := range subs {
+			notify(ch, JobEvent{FileID: fileID})
+		}
+	}
+	for ch := range b.all {
+		notify(ch, JobEvent{})
+	}
+}
+
+func notify(ch chan JobEvent, ev JobEvent) {
+	select {
+	case ch <- ev:
+	default:
+		// A wake-up is already pending; the subscriber will re-read the job
+	}
+}
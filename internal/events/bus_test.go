@@ -0,0 +1,59 @@
+package events_test
+
+import (
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBusDeliversToFileSubscribers(t *testing.T) {
+	bus := events.NewBus()
+
+	a, unsubscribeA := bus.Subscribe("file-a")
+	defer unsubscribeA()
+	b, unsubscribeB := bus.Subscribe("file-b")
+	defer unsubscribeB()
+
+	bus.Publish(events.JobEvent{JobID: 1, FileID: "file-a", Status: "processing"})
+
+	select {
+	case ev := <-a:
+		assert.Equal(t, "processing", ev.Status)
+	default:
+		t.Fatal("subscriber for file-a got no event")
+	}
+	select {
+	case ev := <-b:
+		t.Fatalf("subscriber for file-b got %+v", ev)
+	default:
+	}
+}
+
+func TestBusCoalescesAndResyncs(t *testing.T) {
+	bus := events.NewBus()
+	ch, unsubscribe := bus.Subscribe("file")
+
+	// A slow subscriber keeps one pending wake-up instead of blocking publishers
+	bus.Publish(events.JobEvent{FileID: "file", Status: "processing"})
+	bus.Publish(events.JobEvent{FileID: "file", Status: "completed"})
+	<-ch
+	select {
+	case <-ch:
+		t.Fatal("expected events to coalesce")
+	default:
+	}
+
+	bus.Resync()
+	ev := <-ch
+	assert.Equal(t, "file", ev.FileID)
+
+	// Nothing is delivered after unsubscribing
+	unsubscribe()
+	bus.Publish(events.JobEvent{FileID: "file", Status: "failed"})
+	select {
+	case <-ch:
+		t.Fatal("got event after unsubscribe")
+	default:
+	}
+}
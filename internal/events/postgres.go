@@ -0,0 +1,56 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// JobEventsChannel is the NOTIFY channel written by the processing_jobs trigger
+const JobEventsChannel = "processing_job_events"
+
+// ListenPostgres feeds bus from the processing_jobs NOTIFY trigger until ctx
+// is canceled. The listener reconnects on its own; after a reconnect every
+// subscriber is woken because notifications sent meanwhile are lost.
+func ListenPostgres(ctx context.Context, connectionString string, bus *Bus) error {
+	listener := pq.NewListener(connectionString, time.Second, time.Minute,
+		func(ev pq.ListenerEventType, err error) {
+			if err != nil {
+				log.Printf("Job event listener: %v", err)
+			}
+		})
+	if err := listener.Listen(JobEventsChannel); err != nil {
+		listener.Close()
+		return err
+	}
+
+	go func() {
+		defer listener.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case n := <-listener.Notify:
+				// nil means the connection was re-established
+				if n == nil {
+					bus.Resync()
+					continue
+				}
+				var ev JobEvent
+				if err := json.Unmarshal([]byte(n.Extra), &ev); err != nil {
+					log.Printf("Job event listener: bad payload %q: %v", n.Extra, err)
+					continue
+				}
+				bus.Publish(ev)
+			case <-time.After(90 * time.Second):
+				// Detect dead connections that haven't errored yet
+				go listener.Ping()
+			}
+		}
+	}()
+
+	return nil
+}
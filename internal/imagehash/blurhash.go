@@ -0,0 +1,137 @@
+package imagehash
+
+import (
+	"image"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// blurHashSample is the longest side of the image BlurHash is computed from.
+// The hash only keeps a few low frequencies, so more pixels add nothing.
+const blurHashSample = 64
+
+// base83 is BlurHash's digit alphabet
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// BlurHash encodes img as a BlurHash (https://blurha.sh), a short string
+// clients decode into a blurred placeholder. It uses 4x3 components, or 3x4
+// for portrait images.
+func BlurHash(img image.Image) string {
+	bounds := img.Bounds()
+	xComponents, yComponents := 4, 3
+	if bounds.Dy() > bounds.Dx() {
+		xComponents, yComponents = 3, 4
+	}
+
+	var small *image.NRGBA
+	if bounds.Dx() >= bounds.Dy() {
+		small = imaging.Resize(img, min(blurHashSample, bounds.Dx()), 0, imaging.Box)
+	} else {
+		small = imaging.Resize(img, 0, min(blurHashSample, bounds.Dy()), imaging.Box)
+	}
+	small = flatten(small)
+	return encodeBlurHash(small, xComponents, yComponents)
+}
+
+func encodeBlurHash(img *image.NRGBA, xComponents, yComponents int) string {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// Linear RGB, so averaging behaves like light does
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			for c := 0; c < 3; c++ {
+				linear[y*width+x][c] = srgbToLinear(row[4*x+c])
+			}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				basisY := math.Cos(math.Pi * float64(j) * float64(y) / float64(height))
+				for x := 0; x < width; x++ {
+					basis := normalisation * basisY * math.Cos(math.Pi*float64(i)*float64(x)/float64(width))
+					for c := 0; c < 3; c++ {
+						factor[c] += basis * linear[y*width+x][c]
+					}
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+	dc, ac := factors[0], factors[1:]
+
+	hash := make([]byte, 0, 6+2*len(ac))
+	hash = appendBase83(hash, (xComponents-1)+(yComponents-1)*9, 1)
+
+	// AC components are scaled by the largest one, sent quantised
+	maximum := 1.0
+	if len(ac) > 0 {
+		var actualMax float64
+		for _, f := range ac {
+			actualMax = max(actualMax, math.Abs(f[0]), math.Abs(f[1]), math.Abs(f[2]))
+		}
+		quantisedMax := clamp(int(math.Floor(actualMax*166-0.5)), 0, 82)
+		maximum = float64(quantisedMax+1) / 166
+		hash = appendBase83(hash, quantisedMax, 1)
+	} else {
+		hash = appendBase83(hash, 0, 1)
+	}
+
+	hash = appendBase83(hash, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		var value int
+		for c := 0; c < 3; c++ {
+			q := clamp(int(math.Floor(signPow(f[c]/maximum, 0.5)*9+9.5)), 0, 18)
+			value = value*19 + q
+		}
+		hash = appendBase83(hash, value, 2)
+	}
+	return string(hash)
+}
+
+// appendBase83 appends value as length base-83 digits, most significant first
+func appendBase83(dst []byte, value, length int) []byte {
+	divisor := 1
+	for i := 1; i < length; i++ {
+		divisor *= 83
+	}
+	for ; divisor > 0; divisor /= 83 {
+		dst = append(dst, base83[value/divisor%83])
+	}
+	return dst
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(hi, v))
+}
@@ -0,0 +1,140 @@
+// Package imagehash computes perceptual hashes, which barely change when an
+// image is resized or recompressed, and BlurHash placeholders.
+package imagehash
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Hash is a 64-bit perceptual hash. Similar images have hashes that differ
+// in few bits; see Distance.
+type Hash uint64
+
+// String formats h as 16 lowercase hex digits
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// ParseHash reads a hash formatted by String
+func ParseHash(s string) (Hash, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("hash must be 16 hex digits, got %q", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse hash: %w", err)
+	}
+	return Hash(v), nil
+}
+
+// Distance is the Hamming distance between two hashes: 0 for (near)
+// identical images, around 32 for unrelated ones
+func Distance(a, b Hash) int {
+	return bits.OnesCount64(uint64(a ^ b))
+}
+
+// DHash is the difference hash: whether brightness increases between
+// horizontally adjacent pixels of a 9x8 thumbnail
+func DHash(img image.Image) Hash {
+	luma := grayscale(img, 9, 8)
+	var h Hash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if luma[y*9+x] < luma[y*9+x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// pHash parameters: the image is scaled to dctSize pixels square and the
+// lowest hashSize x hashSize frequencies are kept
+const (
+	dctSize  = 32
+	hashSize = 8
+)
+
+// dctCos[u][x] is the DCT-II basis function of frequency u at pixel x
+var dctCos = func() (table [hashSize][dctSize]float64) {
+	for u := range table {
+		for x := range table[u] {
+			table[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * dctSize))
+		}
+	}
+	return table
+}()
+
+// PHash is the DCT hash: whether each of the 64 lowest frequencies of a
+// 32x32 grayscale thumbnail is above their median. It survives resizing,
+// compression and small color changes better than DHash.
+func PHash(img image.Image) Hash {
+	luma := grayscale(img, dctSize, dctSize)
+
+	// Separable DCT: rows first, then columns of the partial result
+	var rows [dctSize][hashSize]float64
+	for y := 0; y < dctSize; y++ {
+		for u := 0; u < hashSize; u++ {
+			var sum float64
+			for x := 0; x < dctSize; x++ {
+				sum += luma[y*dctSize+x] * dctCos[u][x]
+			}
+			rows[y][u] = sum
+		}
+	}
+	coeffs := make([]float64, 0, hashSize*hashSize)
+	for v := 0; v < hashSize; v++ {
+		for u := 0; u < hashSize; u++ {
+			var sum float64
+			for y := 0; y < dctSize; y++ {
+				sum += rows[y][u] * dctCos[v][y]
+			}
+			coeffs = append(coeffs, sum)
+		}
+	}
+
+	// The DC term is the average brightness; it would skew the median
+	sorted := slices.Clone(coeffs[1:])
+	slices.Sort(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var h Hash
+	for _, c := range coeffs {
+		h <<= 1
+		if c > median {
+			h |= 1
+		}
+	}
+	return h
+}
+
+// grayscale scales img to w x h, flattened onto white, and returns its luma
+func grayscale(img image.Image, w, h int) []float64 {
+	small := flatten(imaging.Resize(img, w, h, imaging.Lanczos))
+	luma := make([]float64, 0, w*h)
+	for y := 0; y < h; y++ {
+		row := small.Pix[y*small.Stride:]
+		for x := 0; x < w; x++ {
+			r, g, b := float64(row[4*x]), float64(row[4*x+1]), float64(row[4*x+2])
+			luma = append(luma, 0.299*r+0.587*g+0.114*b)
+		}
+	}
+	return luma
+}
+
+// flatten composites img onto white, so transparent pixels hash the same
+// whatever color they happen to carry
+func flatten(img *image.NRGBA) *image.NRGBA {
+	bounds := img.Bounds()
+	background := imaging.New(bounds.Dx(), bounds.Dy(), color.White)
+	return imaging.Overlay(background, img, image.Point{}, 1)
+}
@@ -0,0 +1,90 @@
+package imagehash_test
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/imagehash"
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// scene draws a few shapes; variant moves them around
+func scene(width, height, variant int) *image.NRGBA {
+	img := imaging.New(width, height, color.NRGBA{200, 220, 255, 255})
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			fx, fy := float64(x)/float64(width), float64(y)/float64(height)
+			switch {
+			case math.Hypot(fx-0.3-0.4*float64(variant), fy-0.4) < 0.2:
+				img.Set(x, y, color.NRGBA{220, 40, 20, 255})
+			case fy > 0.7-0.4*float64(variant):
+				img.Set(x, y, color.NRGBA{30, 120, 40, 255})
+			}
+		}
+	}
+	return img
+}
+
+func TestPerceptualHashes(t *testing.T) {
+	original := scene(640, 480, 0)
+	resized := imaging.Resize(original, 200, 150, imaging.Lanczos)
+	blurred := imaging.Blur(original, 2)
+	other := scene(640, 480, 1)
+
+	for name, hash := range map[string]func(image.Image) imagehash.Hash{
+		"dhash": imagehash.DHash,
+		"phash": imagehash.PHash,
+	} {
+		h := hash(original)
+		assert.LessOrEqual(t, imagehash.Distance(h, hash(resized)), 4, name)
+		assert.LessOrEqual(t, imagehash.Distance(h, hash(blurred)), 6, name)
+		assert.Greater(t, imagehash.Distance(h, hash(other)), 12, name)
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	h := imagehash.Hash(0x00ff00ff12345678)
+	assert.Equal(t, "00ff00ff12345678", h.String())
+	parsed, err := imagehash.ParseHash(h.String())
+	require.NoError(t, err)
+	assert.Equal(t, h, parsed)
+
+	for _, bad := range []string{"", "ff", "00ff00ff1234567g", "00ff00ff123456789"} {
+		_, err := imagehash.ParseHash(bad)
+		assert.Error(t, err, bad)
+	}
+	assert.Equal(t, 64, imagehash.Distance(0, ^imagehash.Hash(0)))
+}
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func decode83(s string) int {
+	value := 0
+	for _, c := range s {
+		value = value*83 + strings.IndexRune(base83, c)
+	}
+	return value
+}
+
+func TestBlurHash(t *testing.T) {
+	// The DC component is the average color
+	flat := imaging.New(120, 80, color.NRGBA{255, 128, 0, 255})
+	hash := imagehash.BlurHash(flat)
+	require.Len(t, hash, 6+2*11)
+	assert.Equal(t, 3+2*9, decode83(hash[:1]), "4x3 components")
+	assert.Equal(t, 0xff8000, decode83(hash[2:6]))
+
+	// Portrait images get more vertical components
+	portrait := imagehash.BlurHash(scene(300, 600, 0))
+	require.Len(t, portrait, 6+2*11)
+	assert.Equal(t, 2+3*9, decode83(portrait[:1]))
+
+	// Transparent pixels count as white
+	transparent := imagehash.BlurHash(image.NewNRGBA(image.Rect(0, 0, 10, 10)))
+	assert.Equal(t, 0xffffff, decode83(transparent[2:6]))
+}
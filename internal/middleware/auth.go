@@ -2,6 +2,13 @@ package middleware
 
 import (
 	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
@@ -9,74 +16,183 @@ import (
 	"google.golang.org/grpc/status"
 )
 
-// i will replace this later with my jwt api key
-var validAPIKeys = map[string]bool{
-	"dev-key-123":  true,
-	"test-key-456": true,
+// Identity is the verified caller, set by the auth interceptors
+type Identity struct {
+	UserID   string // JWT "sub" or the API key's owner
+	TenantID string // JWT "tenant_id" or the API key's tenant; may be empty
+	KeyID    string // Set when authenticated with an API key
+	Scopes   []Scope
 }
 
-// AuthInterceptor validates API keys from metadata
-func UnaryAuthInterceptor(
-	ctx context.Context,
-	req interface{},
-	info *grpc.UnaryServerInfo,
-	handler grpc.UnaryHandler,
-) (interface{}, error) {
-	// Extract API key from metadata
+// HasScope reports whether the caller holds scope (admin holds every scope)
+func (id *Identity) HasScope(scope Scope) bool {
+	return slices.Contains(id.Scopes, scope) || slices.Contains(id.Scopes, ScopeAdmin)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a context carrying the verified caller
+func WithIdentity(ctx context.Context, id *Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the caller verified by the auth interceptors
+func IdentityFromContext(ctx context.Context) (*Identity, bool) {
+	id, ok := ctx.Value(identityKey{}).(*Identity)
+	return id, ok
+}
+
+// Authenticator verifies "api-key" metadata against the api_keys table, or
+// "authorization: Bearer <jwt>" metadata. Either source may be nil.
+type Authenticator struct {
+	verifier *JWTVerifier
+	keys     APIKeyStore
+}
+
+func NewAuthenticator(verifier *JWTVerifier, keys APIKeyStore) *Authenticator {
+	return &Authenticator{verifier: verifier, keys: keys}
+}
+
+// authenticate verifies the caller's credentials and returns a context with their identity
+func (a *Authenticator) authenticate(ctx context.Context) (context.Context, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
 		return nil, status.Error(codes.Unauthenticated, "missing metadata")
 	}
 
-	apiKeys := md.Get("api-key")
-	if len(apiKeys) == 0 {
-		return nil, status.Error(codes.Unauthenticated, "missing api-key")
+	var (
+		id  *Identity
+		err error
+	)
+	if apiKeys := md.Get("api-key"); len(apiKeys) > 0 && a.keys != nil {
+		id, err = a.authenticateAPIKey(ctx, apiKeys[0])
+	} else if authHeaders := md.Get("authorization"); len(authHeaders) > 0 && a.verifier != nil {
+		id, err = a.authenticateBearer(authHeaders[0])
+	} else {
+		return nil, status.Error(codes.Unauthenticated, "missing credentials")
 	}
-
-	apiKey := apiKeys[0]
-	if !validAPIKeys[apiKey] {
-		return nil, status.Error(codes.Unauthenticated, "invalid api-key")
+	if err != nil {
+		return nil, err
 	}
 
-	return handler(ctx, req)
+	return WithIdentity(ctx, id), nil
 }
 
-// StreamAuthInterceptor for streaming RPCs
-func StreamAuthInterceptor(
-	srv interface{},
-	ss grpc.ServerStream,
-	info *grpc.StreamServerInfo,
-	handler grpc.StreamHandler,
-) error {
-	md, ok := metadata.FromIncomingContext(ss.Context())
-	if !ok {
-		return status.Error(codes.Unauthenticated, "missing metadata")
+func (a *Authenticator) authenticateBearer(header string) (*Identity, error) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "bearer") || token == "" {
+		return nil, status.Error(codes.Unauthenticated, "authorization must be a bearer token")
 	}
 
-	apiKeys := md.Get("api-key")
-	if len(apiKeys) == 0 {
-		return status.Error(codes.Unauthenticated, "missing api-key")
+	claims, err := a.verifier.Verify(token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
 	}
 
-	apiKey := apiKeys[0]
-	if !validAPIKeys[apiKey] {
-		return status.Error(codes.Unauthenticated, "invalid api-key")
+	scopes := DefaultUserScopes
+	if claims.Scope != "" {
+		scopes = keyScopes(strings.Fields(claims.Scope))
 	}
+	return &Identity{UserID: claims.Subject, TenantID: claims.TenantID, Scopes: scopes}, nil
+}
 
-	return handler(srv, ss)
+func (a *Authenticator) authenticateAPIKey(ctx context.Context, secret string) (*Identity, error) {
+	hash := HashAPIKey(secret)
+	key, err := a.keys.GetAPIKeyByHash(ctx, hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, status.Error(codes.Unauthenticated, "invalid api-key")
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to look up api-key: %v", err)
+	}
+	// The lookup is by hash already; compare anyway so the check doesn't
+	// rely on how the database matches bytea values
+	if subtle.ConstantTimeCompare(hash, key.KeyHash) != 1 {
+		return nil, status.Error(codes.Unauthenticated, "invalid api-key")
+	}
+	if key.RevokedAt != nil {
+		return nil, status.Error(codes.Unauthenticated, "api-key revoked")
+	}
+	if key.ExpiresAt != nil && time.Now().After(*key.ExpiresAt) {
+		return nil, status.Error(codes.Unauthenticated, "api-key expired")
+	}
+
+	if err := a.keys.TouchAPIKey(ctx, key.ID); err != nil {
+		log.Printf("Warning: failed to record use of api-key %s: %v", key.ID, err)
+	}
+
+	return &Identity{
+		UserID:   key.OwnerID,
+		TenantID: key.TenantID,
+		KeyID:    key.ID,
+		Scopes:   keyScopes(key.Scopes),
+	}, nil
 }
 
-// ExtractUserID gets user_id from context (set by auth interceptor)
-func ExtractUserID(ctx context.Context) (string, error) {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return "", status.Error(codes.Unauthenticated, "missing metadata")
+// UnaryAuthInterceptor authenticates unary RPCs and enforces per-method scopes
+func UnaryAuthInterceptor(auth *Authenticator) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		ctx, err := auth.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		id, _ := IdentityFromContext(ctx)
+		if err := authorize(id, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
 	}
+}
 
-	userIDs := md.Get("user-id")
-	if len(userIDs) == 0 {
-		return "", status.Error(codes.Unauthenticated, "missing user-id")
+// StreamAuthInterceptor authenticates streaming RPCs and enforces per-method scopes
+func StreamAuthInterceptor(auth *Authenticator) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		ctx, err := auth.authenticate(ss.Context())
+		if err != nil {
+			return err
+		}
+		id, _ := IdentityFromContext(ctx)
+		if err := authorize(id, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
 	}
+}
 
-	return userIDs[0], nil
+// authenticatedStream swaps in the context that carries the caller's identity
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// ExtractIdentity gets the verified caller from context (set by auth interceptor)
+func ExtractIdentity(ctx context.Context) (*Identity, error) {
+	id, ok := IdentityFromContext(ctx)
+	if !ok || id.UserID == "" {
+		return nil, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	return id, nil
+}
+
+// ExtractUserID gets the verified user ID from context (set by auth interceptor)
+func ExtractUserID(ctx context.Context) (string, error) {
+	id, err := ExtractIdentity(ctx)
+	if err != nil {
+		return "", err
+	}
+	return id.UserID, nil
 }
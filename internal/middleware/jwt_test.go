@@ -0,0 +1,111 @@
+package middleware_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var secret = []byte("test-secret")
+
+func sign(t *testing.T, method jwt.SigningMethod, key any, claims jwt.RegisteredClaims, kid string) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func TestJWTVerifierHS256(t *testing.T) {
+	verifier, err := middleware.NewJWTVerifier(middleware.JWTConfig{
+		HS256Secret: secret,
+		Issuer:      "https://issuer.example",
+		Audience:    "uploadstream",
+	})
+	require.NoError(t, err)
+
+	valid := jwt.RegisteredClaims{
+		Subject:   "user-1",
+		Issuer:    "https://issuer.example",
+		Audience:  jwt.ClaimStrings{"uploadstream"},
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	claims, err := verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, valid, ""))
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", claims.Subject)
+
+	// Wrong secret
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, []byte("other"), valid, ""))
+	assert.Error(t, err)
+
+	// Expired
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, expired, ""))
+	assert.Error(t, err)
+
+	// Wrong audience
+	otherAud := valid
+	otherAud.Audience = jwt.ClaimStrings{"someone-else"}
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, otherAud, ""))
+	assert.Error(t, err)
+
+	// Missing subject
+	noSub := valid
+	noSub.Subject = ""
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, noSub, ""))
+	assert.Error(t, err)
+}
+
+func TestJWTVerifierJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	jwks, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, jwks, 0o600))
+
+	verifier, err := middleware.NewJWTVerifier(middleware.JWTConfig{JWKSFile: path})
+	require.NoError(t, err)
+
+	claims := jwt.RegisteredClaims{
+		Subject:   "user-2",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+
+	got, err := verifier.Verify(sign(t, jwt.SigningMethodRS256, key, claims, "key-1"))
+	require.NoError(t, err)
+	assert.Equal(t, "user-2", got.Subject)
+
+	// Unknown kid
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodRS256, key, claims, "key-2"))
+	assert.Error(t, err)
+
+	// HS256 is not accepted when only a JWKS is configured
+	_, err = verifier.Verify(sign(t, jwt.SigningMethodHS256, secret, claims, ""))
+	assert.Error(t, err)
+}
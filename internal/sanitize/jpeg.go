@@ -0,0 +1,189 @@
+package sanitize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+const (
+	markerSOI   = 0xD8
+	markerEOI   = 0xD9
+	markerSOS   = 0xDA
+	markerCOM   = 0xFE
+	markerAPP1  = 0xE1
+	markerAPP2  = 0xE2
+	markerAPP13 = 0xED
+)
+
+var (
+	jpegExifHeader   = []byte("Exif\x00\x00")
+	jpegXMPHeader    = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegXMPExtHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	jpegMPFHeader    = []byte("MPF\x00")
+)
+
+// rewriteJPEG copies a JPEG segment by segment, filtering the metadata
+// segments. Entropy-coded data is copied as is; anything after EOI is dropped.
+func rewriteJPEG(src io.Reader, dst io.Writer, policy Policy, removed *removals) error {
+	r := bufio.NewReaderSize(src, 64<<10)
+	w := bufio.NewWriterSize(dst, 64<<10)
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil {
+		return truncated(err)
+	}
+	if soi != [2]byte{0xFF, markerSOI} {
+		return fmt.Errorf("%w: missing JPEG SOI marker", ErrMalformed)
+	}
+	w.Write(soi[:])
+
+	// Extended XMP follows the fate of the main packet, which comes first
+	dropXMP := true
+
+	marker, err := readMarker(r)
+	for {
+		if err != nil {
+			return err
+		}
+		if marker == markerEOI {
+			w.Write([]byte{0xFF, markerEOI})
+			break
+		}
+		if marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			// Standalone markers have no length
+			w.Write([]byte{0xFF, marker})
+			marker, err = readMarker(r)
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return truncated(err)
+		}
+		n := binary.BigEndian.Uint16(length[:])
+		if n < 2 {
+			return fmt.Errorf("%w: bad JPEG segment length", ErrMalformed)
+		}
+		var payload []byte
+		if payload, err = readBlock(r, int64(n)-2); err != nil {
+			return err
+		}
+
+		if marker == markerSOS {
+			writeJPEGSegment(w, marker, payload)
+			marker, err = copyEntropyData(r, w)
+			continue
+		}
+
+		payload, keep := filterJPEGSegment(marker, payload, policy, removed, &dropXMP)
+		if keep {
+			writeJPEGSegment(w, marker, payload)
+		}
+		marker, err = readMarker(r)
+	}
+
+	trailer, err := hasTrailer(r)
+	if err != nil {
+		return err
+	}
+	if trailer {
+		removed.add(RemovedTrailer)
+	}
+	return w.Flush()
+}
+
+// filterJPEGSegment returns the segment to write in place of payload, and
+// false if the segment should be dropped
+func filterJPEGSegment(marker byte, payload []byte, policy Policy, removed *removals, dropXMP *bool) ([]byte, bool) {
+	switch {
+	case marker == markerAPP1 && bytes.HasPrefix(payload, jpegExifHeader):
+		block := stripTIFF(payload[len(jpegExifHeader):], policy, removed)
+		if block == nil {
+			return nil, false
+		}
+		return append(append([]byte{}, jpegExifHeader...), block...), true
+
+	case marker == markerAPP1 && bytes.HasPrefix(payload, jpegXMPHeader):
+		keep := keepXMP(payload[len(jpegXMPHeader):], policy, removed)
+		*dropXMP = !keep
+		return payload, keep
+
+	case marker == markerAPP1 && bytes.HasPrefix(payload, jpegXMPExtHeader):
+		if *dropXMP {
+			removed.add(RemovedXMP)
+			return nil, false
+		}
+		return payload, true
+
+	case marker == markerAPP2 && bytes.HasPrefix(payload, jpegMPFHeader):
+		// Points at the secondary images after EOI, which are dropped
+		return payload, false
+
+	case marker == markerAPP13 && policy == StripAll:
+		removed.add(RemovedIPTC)
+		return payload, false
+
+	case marker == markerCOM && policy == StripAll:
+		removed.add(RemovedComment)
+		return payload, false
+	}
+	return payload, true
+}
+
+func writeJPEGSegment(w *bufio.Writer, marker byte, payload []byte) {
+	var header [4]byte
+	header[0], header[1] = 0xFF, marker
+	binary.BigEndian.PutUint16(header[2:], uint16(len(payload)+2))
+	w.Write(header[:])
+	w.Write(payload)
+}
+
+// readMarker reads a marker, skipping fill bytes
+func readMarker(r *bufio.Reader) (byte, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, truncated(err)
+	}
+	if b != 0xFF {
+		return 0, fmt.Errorf("%w: expected JPEG marker, got %#02x", ErrMalformed, b)
+	}
+	for b == 0xFF {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, truncated(err)
+		}
+	}
+	return b, nil
+}
+
+// copyEntropyData copies scan data up to the next marker (other than byte
+// stuffing and restart markers) and returns that marker, unwritten
+func copyEntropyData(r *bufio.Reader, w *bufio.Writer) (byte, error) {
+	for {
+		chunk, err := r.ReadSlice(0xFF)
+		if err == nil {
+			chunk = chunk[:len(chunk)-1] // Hold the 0xFF until we know what it starts
+		}
+		w.Write(chunk)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return 0, truncated(err)
+		}
+
+		next, err := r.ReadByte()
+		for err == nil && next == 0xFF {
+			next, err = r.ReadByte()
+		}
+		if err != nil {
+			return 0, truncated(err)
+		}
+		if next != 0x00 && (next < 0xD0 || next > 0xD7) {
+			return next, nil
+		}
+		w.Write([]byte{0xFF, next})
+	}
+}
@@ -0,0 +1,126 @@
+package sanitize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngXMPKeyword is the iTXt keyword XMP packets are stored under
+const pngXMPKeyword = "XML:com.adobe.xmp"
+
+// rewritePNG copies a PNG chunk by chunk, filtering the metadata chunks.
+// Image data is streamed; anything after IEND is dropped.
+func rewritePNG(src io.Reader, dst io.Writer, policy Policy, removed *removals) error {
+	r := bufio.NewReaderSize(src, 64<<10)
+	w := bufio.NewWriterSize(dst, 64<<10)
+
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil {
+		return truncated(err)
+	}
+	if !bytes.Equal(sig, pngSignature) {
+		return fmt.Errorf("%w: missing PNG signature", ErrMalformed)
+	}
+	w.Write(sig)
+
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return truncated(err)
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		chunkType := string(header[4:])
+		if length > 1<<31-1 {
+			return fmt.Errorf("%w: bad PNG chunk length", ErrMalformed)
+		}
+
+		switch chunkType {
+		case "eXIf", "tEXt", "zTXt", "iTXt":
+			data, err := readBlock(r, length)
+			if err != nil {
+				return err
+			}
+			if _, err := io.CopyN(io.Discard, r, 4); err != nil { // Old CRC
+				return truncated(err)
+			}
+			if data, keep := filterPNGChunk(chunkType, data, policy, removed); keep {
+				writePNGChunk(w, chunkType, data)
+			}
+		default:
+			w.Write(header[:])
+			if _, err := io.CopyN(w, r, length+4); err != nil {
+				return truncated(err)
+			}
+		}
+
+		if chunkType == "IEND" {
+			break
+		}
+	}
+
+	trailer, err := hasTrailer(r)
+	if err != nil {
+		return err
+	}
+	if trailer {
+		removed.add(RemovedTrailer)
+	}
+	return w.Flush()
+}
+
+// filterPNGChunk returns the chunk data to write, and false to drop the chunk
+func filterPNGChunk(chunkType string, data []byte, policy Policy, removed *removals) ([]byte, bool) {
+	if chunkType == "eXIf" {
+		data = stripTIFF(data, policy, removed)
+		return data, data != nil
+	}
+
+	// Text chunks start with a NUL-terminated keyword
+	keyword, rest, _ := bytes.Cut(data, []byte{0})
+	switch {
+	case chunkType == "iTXt" && string(keyword) == pngXMPKeyword:
+		// Compressed packets can't be inspected, so only plain ones survive
+		if policy == StripLocation && len(rest) > 0 && rest[0] != 0 {
+			removed.add(RemovedXMP)
+			return nil, false
+		}
+		return data, keepXMP(rest, policy, removed)
+
+	case bytes.HasPrefix(keyword, []byte("Raw profile type ")):
+		// Hex-encoded EXIF/IPTC/XMP written by ImageMagick and others
+		switch string(bytes.TrimPrefix(keyword, []byte("Raw profile type "))) {
+		case "iptc", "8bim":
+			removed.add(RemovedIPTC)
+		case "xmp":
+			removed.add(RemovedXMP)
+		default:
+			removed.add(RemovedEXIF)
+		}
+		return nil, false
+
+	case policy == StripAll:
+		removed.add(RemovedComment)
+		return nil, false
+	}
+	return data, true
+}
+
+func writePNGChunk(w *bufio.Writer, chunkType string, data []byte) {
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	copy(header[4:], chunkType)
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(data)
+
+	w.Write(header[:])
+	w.Write(data)
+	binary.Write(w, binary.BigEndian, crc.Sum32())
+}
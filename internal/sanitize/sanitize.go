@@ -0,0 +1,174 @@
+// Package sanitize rewrites JPEG, PNG and WebP files to remove embedded
+// metadata (EXIF, GPS, XMP, ...) without re-encoding the image data.
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Policy says how much metadata to remove. Policies are ordered from least
+// to most strict.
+type Policy int
+
+const (
+	// Keep leaves files untouched
+	Keep Policy = iota
+	// StripLocation removes GPS coordinates from EXIF and any XMP packet
+	// that carries them, plus data appended after the image (e.g. the
+	// secondary images of multi-picture JPEGs, which have their own EXIF)
+	StripLocation
+	// StripAll removes EXIF (except the orientation, so the image still
+	// displays upright), XMP, IPTC, comments and text chunks. Color profiles
+	// are kept.
+	StripAll
+)
+
+var policyNames = map[Policy]string{
+	Keep:          "keep",
+	StripLocation: "strip_location",
+	StripAll:      "strip_all",
+}
+
+func (p Policy) String() string {
+	if name, ok := policyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// ParsePolicy is the inverse of Policy.String
+func ParsePolicy(name string) (Policy, error) {
+	for p, n := range policyNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return Keep, fmt.Errorf("unknown metadata policy %q", name)
+}
+
+// Kinds of metadata reported as removed
+const (
+	RemovedGPS     = "gps"
+	RemovedEXIF    = "exif"
+	RemovedXMP     = "xmp"
+	RemovedIPTC    = "iptc"
+	RemovedComment = "comment" // JPEG comments and PNG text chunks
+	RemovedTrailer = "trailer" // Data after the end of the image
+)
+
+// ErrMalformed is returned for files whose structure can't be followed
+var ErrMalformed = errors.New("malformed image")
+
+// maxMetadataSize bounds how much of a single metadata block is buffered
+const maxMetadataSize = 16 << 20
+
+// Opener returns a fresh reader over the original file. WebP is read twice
+// because its header records the size of everything that follows.
+type Opener func() (io.ReadCloser, error)
+
+// Supported reports whether files of contentType can be sanitized
+func Supported(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Rewrite copies the file from open to dst with metadata removed per
+// policy, and returns the kinds of metadata it removed (sorted, without
+// duplicates). Image data is copied verbatim.
+func Rewrite(open Opener, dst io.Writer, contentType string, policy Policy) ([]string, error) {
+	if !Supported(contentType) {
+		return nil, fmt.Errorf("can't sanitize %s", contentType)
+	}
+	if policy == Keep {
+		return nil, copyAll(open, dst)
+	}
+
+	removed := &removals{}
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		err = rewriteStream(open, dst, policy, removed, rewriteJPEG)
+	case "image/png":
+		err = rewriteStream(open, dst, policy, removed, rewritePNG)
+	case "image/webp":
+		err = rewriteWebP(open, dst, policy, removed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return removed.list(), nil
+}
+
+// rewriteStream runs a single-pass rewrite over a freshly opened reader
+func rewriteStream(open Opener, dst io.Writer, policy Policy, removed *removals,
+	rewrite func(io.Reader, io.Writer, Policy, *removals) error) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return rewrite(r, dst, policy, removed)
+}
+
+func copyAll(open Opener, dst io.Writer) error {
+	r, err := open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	_, err = io.Copy(dst, r)
+	return err
+}
+
+// removals collects what was removed, in a stable order
+type removals struct {
+	seen map[string]bool
+}
+
+func (r *removals) add(kind string) {
+	if r.seen == nil {
+		r.seen = make(map[string]bool)
+	}
+	r.seen[kind] = true
+}
+
+func (r *removals) list() []string {
+	var kinds []string
+	for _, kind := range []string{RemovedComment, RemovedEXIF, RemovedGPS, RemovedIPTC, RemovedTrailer, RemovedXMP} {
+		if r.seen[kind] {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
+// readBlock reads an n-byte metadata block, refusing absurd sizes
+func readBlock(r io.Reader, n int64) ([]byte, error) {
+	if n > maxMetadataSize {
+		return nil, fmt.Errorf("%w: %d byte metadata block", ErrMalformed, n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, truncated(err)
+	}
+	return buf, nil
+}
+
+// truncated turns a short read into ErrMalformed
+func truncated(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return fmt.Errorf("%w: unexpected end of file", ErrMalformed)
+	}
+	return err
+}
+
+// hasTrailer reports whether anything follows the image in r
+func hasTrailer(r io.Reader) (bool, error) {
+	n, err := io.Copy(io.Discard, r)
+	return n > 0, err
+}
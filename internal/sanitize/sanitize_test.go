@@ -0,0 +1,254 @@
+package sanitize_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/sanitize"
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exifBlock builds a big-endian TIFF block with a Model, an Orientation and
+// a GPS IFD holding a latitude
+func exifBlock() []byte {
+	var b bytes.Buffer
+	be := binary.BigEndian
+	b.WriteString("MM\x00\x2a")
+	binary.Write(&b, be, uint32(8))
+
+	// IFD0 at 8: three entries, then the next-IFD pointer; GPS IFD at 50
+	binary.Write(&b, be, uint16(3))
+	binary.Write(&b, be, []uint16{0x0110, 2}) // Model
+	binary.Write(&b, be, uint32(3))
+	b.WriteString("X1\x00\x00")
+	binary.Write(&b, be, []uint16{0x0112, 3}) // Orientation
+	binary.Write(&b, be, uint32(1))
+	binary.Write(&b, be, []uint16{6, 0})
+	binary.Write(&b, be, []uint16{0x8825, 4}) // GPS IFD pointer
+	binary.Write(&b, be, uint32(1))
+	binary.Write(&b, be, uint32(50))
+	binary.Write(&b, be, uint32(0))
+
+	// GPS IFD at 50: GPSLatitude as three RATIONALs at 68
+	binary.Write(&b, be, uint16(1))
+	binary.Write(&b, be, []uint16{0x0002, 5})
+	binary.Write(&b, be, uint32(3))
+	binary.Write(&b, be, uint32(68))
+	binary.Write(&b, be, uint32(0))
+	binary.Write(&b, be, []uint32{52, 1, 31, 1, 12, 1})
+	return b.Bytes()
+}
+
+const xmpWithLocation = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+	`<rdf:Description xmlns:exif="http://ns.adobe.com/exif/1.0/" exif:GPSLatitude="52,31.2N"/></rdf:RDF></x:xmpmeta>`
+
+func testImage() image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, 32, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 32; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 8), uint8(y * 16), 100, 255})
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	seg := []byte{0xFF, marker, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testJPEG is a JPEG with EXIF+GPS, XMP+GPS, IPTC, a comment and a trailer
+func testJPEG(t *testing.T) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, testImage(), nil))
+	encoded := buf.Bytes()
+
+	out := append([]byte{}, encoded[:2]...)
+	out = append(out, jpegSegment(0xE1, append([]byte("Exif\x00\x00"), exifBlock()...))...)
+	out = append(out, jpegSegment(0xE1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmpWithLocation...))...)
+	out = append(out, jpegSegment(0xED, []byte("Photoshop 3.0\x00"))...)
+	out = append(out, jpegSegment(0xFE, []byte("hello"))...)
+	out = append(out, encoded[2:]...)
+	return append(out, "secondary image"...)
+}
+
+func rewrite(t *testing.T, data []byte, contentType string, policy sanitize.Policy) ([]byte, []string) {
+	var out bytes.Buffer
+	removed, err := sanitize.Rewrite(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}, &out, contentType, policy)
+	require.NoError(t, err)
+	return out.Bytes(), removed
+}
+
+// assertSamePixels checks the image data survived untouched
+func assertSamePixels(t *testing.T, want, got []byte) {
+	wantImg, _, err := image.Decode(bytes.NewReader(want))
+	require.NoError(t, err)
+	gotImg, _, err := image.Decode(bytes.NewReader(got))
+	require.NoError(t, err)
+	assert.Equal(t, wantImg, gotImg)
+}
+
+func TestJPEGStripLocation(t *testing.T) {
+	original := testJPEG(t)
+	out, removed := rewrite(t, original, "image/jpeg", sanitize.StripLocation)
+
+	assert.Equal(t, []string{"gps", "trailer", "xmp"}, removed)
+	assertSamePixels(t, original, out)
+	assert.NotContains(t, string(out), "GPSLatitude")
+	assert.NotContains(t, string(out), "secondary image")
+	assert.Contains(t, string(out), "hello")
+
+	// The rest of the EXIF survives
+	x, err := exif.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	model, err := x.Get(exif.Model)
+	require.NoError(t, err)
+	assert.Equal(t, `"X1"`, model.String())
+	_, _, err = x.LatLong()
+	assert.Error(t, err)
+}
+
+func TestJPEGStripAll(t *testing.T) {
+	original := testJPEG(t)
+	out, removed := rewrite(t, original, "image/jpeg", sanitize.StripAll)
+
+	assert.Equal(t, []string{"comment", "exif", "iptc", "trailer", "xmp"}, removed)
+	assertSamePixels(t, original, out)
+	assert.NotContains(t, string(out), "hello")
+	assert.NotContains(t, string(out), "Photoshop")
+
+	// Only the orientation is left
+	x, err := exif.Decode(bytes.NewReader(out))
+	require.NoError(t, err)
+	orientation, err := x.Get(exif.Orientation)
+	require.NoError(t, err)
+	v, err := orientation.Int(0)
+	require.NoError(t, err)
+	assert.Equal(t, 6, v)
+	_, err = x.Get(exif.Model)
+	assert.Error(t, err)
+}
+
+func TestKeepCopiesVerbatim(t *testing.T) {
+	original := testJPEG(t)
+	out, removed := rewrite(t, original, "image/jpeg", sanitize.Keep)
+	assert.Empty(t, removed)
+	assert.Equal(t, original, out)
+}
+
+func TestMalformedJPEG(t *testing.T) {
+	_, err := sanitize.Rewrite(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00})), nil
+	}, io.Discard, "image/jpeg", sanitize.StripAll)
+	assert.ErrorIs(t, err, sanitize.ErrMalformed)
+}
+
+func pngChunk(chunkType string, data []byte) []byte {
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(len(data)))
+	b.WriteString(chunkType)
+	b.Write(data)
+	binary.Write(&b, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(chunkType), data...)))
+	return b.Bytes()
+}
+
+func TestPNGStrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, testImage()))
+	encoded := buf.Bytes()
+
+	// Insert the metadata after IHDR (signature + 25 byte chunk)
+	original := append([]byte{}, encoded[:33]...)
+	original = append(original, pngChunk("eXIf", exifBlock())...)
+	original = append(original, pngChunk("tEXt", []byte("Comment\x00hello"))...)
+	original = append(original, pngChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"+xmpWithLocation))...)
+	original = append(original, encoded[33:]...)
+
+	out, removed := rewrite(t, original, "image/png", sanitize.StripLocation)
+	assert.Equal(t, []string{"gps", "xmp"}, removed)
+	assertSamePixels(t, original, out)
+	assert.Contains(t, string(out), "hello")
+	assert.NotContains(t, string(out), "GPSLatitude")
+
+	out, removed = rewrite(t, original, "image/png", sanitize.StripAll)
+	assert.Equal(t, []string{"comment", "exif", "xmp"}, removed)
+	assertSamePixels(t, original, out)
+	assert.NotContains(t, string(out), "hello")
+	assert.NotContains(t, string(out), "X1")
+}
+
+func webpChunk(fourCC string, data []byte) []byte {
+	var b bytes.Buffer
+	b.WriteString(fourCC)
+	binary.Write(&b, binary.LittleEndian, uint32(len(data)))
+	b.Write(data)
+	if len(data)%2 == 1 {
+		b.WriteByte(0)
+	}
+	return b.Bytes()
+}
+
+// webpChunks parses a RIFF WebP into fourCC -> data, checking the sizes add up
+func webpChunks(t *testing.T, data []byte) map[string][]byte {
+	require.Equal(t, "RIFF", string(data[:4]))
+	require.Equal(t, len(data)-8, int(binary.LittleEndian.Uint32(data[4:])))
+	chunks := map[string][]byte{}
+	for rest := data[12:]; len(rest) > 0; {
+		size := int(binary.LittleEndian.Uint32(rest[4:]))
+		chunks[string(rest[:4])] = rest[8 : 8+size]
+		rest = rest[8+size+size%2:]
+	}
+	return chunks
+}
+
+func TestWebPStrip(t *testing.T) {
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x08 | 0x04 // EXIF and XMP
+	var body []byte
+	body = append(body, webpChunk("VP8X", vp8x)...)
+	body = append(body, webpChunk("VP8L", []byte("pixels!"))...)
+	body = append(body, webpChunk("EXIF", exifBlock())...)
+	body = append(body, webpChunk("XMP ", []byte(xmpWithLocation))...)
+
+	original := []byte("RIFF\x00\x00\x00\x00WEBP")
+	binary.LittleEndian.PutUint32(original[4:], uint32(len(body)+4))
+	original = append(original, body...)
+	original = append(original, "trailer"...)
+
+	out, removed := rewrite(t, original, "image/webp", sanitize.StripLocation)
+	assert.Equal(t, []string{"gps", "trailer", "xmp"}, removed)
+	chunks := webpChunks(t, out)
+	assert.Equal(t, []byte("pixels!"), chunks["VP8L"])
+	assert.Equal(t, byte(0x08), chunks["VP8X"][0])
+	assert.Contains(t, chunks, "EXIF")
+	assert.NotContains(t, chunks, "XMP ")
+	assert.NotContains(t, string(out), "GPSLatitude")
+
+	out, removed = rewrite(t, original, "image/webp", sanitize.StripAll)
+	assert.Equal(t, []string{"exif", "trailer", "xmp"}, removed)
+	chunks = webpChunks(t, out)
+	assert.Equal(t, byte(0x08), chunks["VP8X"][0]) // Orientation-only EXIF
+	assert.NotContains(t, string(chunks["EXIF"]), "X1")
+}
+
+func TestParsePolicy(t *testing.T) {
+	for _, p := range []sanitize.Policy{sanitize.Keep, sanitize.StripLocation, sanitize.StripAll} {
+		got, err := sanitize.ParsePolicy(p.String())
+		require.NoError(t, err)
+		assert.Equal(t, p, got)
+	}
+	_, err := sanitize.ParsePolicy("bogus")
+	assert.Error(t, err)
+}
@@ -0,0 +1,183 @@
+package sanitize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// EXIF blocks are TIFF structures: a header, then IFDs (directories of
+// 12-byte entries) whose larger values live elsewhere in the block at
+// absolute offsets. Edits are done in place so those offsets stay valid.
+
+const (
+	tagOrientation = 0x0112
+	tagGPSIFD      = 0x8825
+)
+
+// tiffTypeSizes is the size in bytes of one value of each TIFF field type
+var tiffTypeSizes = map[uint16]int{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+type tiff struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+func parseTIFF(b []byte) (*tiff, error) {
+	if len(b) < 8 {
+		return nil, fmt.Errorf("%w: short EXIF block", ErrMalformed)
+	}
+	t := &tiff{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("%w: bad EXIF byte order", ErrMalformed)
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return nil, fmt.Errorf("%w: bad EXIF header", ErrMalformed)
+	}
+	if _, _, ok := t.ifd(t.order.Uint32(b[4:])); !ok {
+		return nil, fmt.Errorf("%w: bad EXIF directory", ErrMalformed)
+	}
+	return t, nil
+}
+
+// ifd returns where the entries of the IFD at off start and how many there are
+func (t *tiff) ifd(off uint32) (start, n int, ok bool) {
+	if int64(off)+2 > int64(len(t.b)) {
+		return 0, 0, false
+	}
+	n = int(t.order.Uint16(t.b[off:]))
+	start = int(off) + 2
+	if start+12*n > len(t.b) {
+		return 0, 0, false
+	}
+	return start, n, true
+}
+
+// ifd0 returns the first IFD's entries, which parseTIFF checked are in bounds
+func (t *tiff) ifd0() (start, n int) {
+	start, n, _ = t.ifd(t.order.Uint32(t.b[4:]))
+	return start, n
+}
+
+// orientation returns the EXIF orientation, or 1 (upright) if unset
+func (t *tiff) orientation() uint16 {
+	start, n := t.ifd0()
+	for i := 0; i < n; i++ {
+		e := t.b[start+12*i:]
+		if t.order.Uint16(e) == tagOrientation && t.order.Uint16(e[2:]) == 3 {
+			if v := t.order.Uint16(e[8:]); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 1
+}
+
+// stripGPS removes the GPS IFD and its pointer from IFD0, and reports
+// whether there was one. The GPS entries and their values are zeroed
+// rather than cut out so nothing else moves.
+func (t *tiff) stripGPS() bool {
+	start, n := t.ifd0()
+	for i := 0; i < n; i++ {
+		e := start + 12*i
+		if t.order.Uint16(t.b[e:]) != tagGPSIFD {
+			continue
+		}
+		t.zeroIFD(t.order.Uint32(t.b[e+8:]))
+
+		// Shift the later entries and the next-IFD pointer over the GPS entry
+		end := min(start+12*n+4, len(t.b))
+		copy(t.b[e:], t.b[e+12:end])
+		clear(t.b[end-12 : end])
+		t.order.PutUint16(t.b[start-2:], uint16(n-1))
+		return true
+	}
+	return false
+}
+
+// zeroIFD overwrites the IFD at off and every value it points to
+func (t *tiff) zeroIFD(off uint32) {
+	start, n, ok := t.ifd(off)
+	if !ok {
+		return
+	}
+	for i := 0; i < n; i++ {
+		e := t.b[start+12*i:]
+		size := int64(tiffTypeSizes[t.order.Uint16(e[2:])]) * int64(t.order.Uint32(e[4:]))
+		if size <= 4 {
+			continue // Stored inline in the entry
+		}
+		if valueOff := int64(t.order.Uint32(e[8:])); valueOff+size <= int64(len(t.b)) {
+			clear(t.b[valueOff : valueOff+size])
+		}
+	}
+	clear(t.b[off:min(start+12*n+4, len(t.b))])
+}
+
+// minimalTIFF builds an EXIF block holding only an orientation
+func minimalTIFF(orientation uint16) []byte {
+	var b bytes.Buffer
+	le := binary.LittleEndian
+	b.WriteString("II")
+	binary.Write(&b, le, uint16(42))
+	binary.Write(&b, le, uint32(8)) // IFD0 follows the header
+	binary.Write(&b, le, uint16(1))
+	binary.Write(&b, le, []uint16{tagOrientation, 3}) // SHORT
+	binary.Write(&b, le, uint32(1))
+	binary.Write(&b, le, []uint16{orientation, 0})
+	binary.Write(&b, le, uint32(0)) // No next IFD
+	return b.Bytes()
+}
+
+// stripTIFF applies policy to an EXIF block and returns the block to keep,
+// or nil to drop it. Blocks that can't be parsed are dropped, since they
+// can't be shown to be free of location data.
+func stripTIFF(b []byte, policy Policy, removed *removals) []byte {
+	t, err := parseTIFF(b)
+	if err != nil {
+		removed.add(RemovedEXIF)
+		return nil
+	}
+	if policy == StripAll {
+		removed.add(RemovedEXIF)
+		if o := t.orientation(); o != 1 {
+			return minimalTIFF(o)
+		}
+		return nil
+	}
+	if t.stripGPS() {
+		removed.add(RemovedGPS)
+	}
+	return t.b
+}
+
+// xmpHasLocation reports whether an XMP packet carries GPS coordinates
+func xmpHasLocation(packet []byte) bool {
+	for _, name := range []string{"GPSLatitude", "GPSLongitude", "GPSAltitude"} {
+		if bytes.Contains(packet, []byte(name)) {
+			return true
+		}
+	}
+	return false
+}
+
+// keepXMP applies policy to an XMP packet and reports whether to keep it
+func keepXMP(packet []byte, policy Policy, removed *removals) bool {
+	if policy == StripAll {
+		removed.add(RemovedXMP)
+		return false
+	}
+	if xmpHasLocation(packet) {
+		removed.add(RemovedXMP)
+		removed.add(RemovedGPS)
+		return false
+	}
+	return true
+}
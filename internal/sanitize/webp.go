@@ -0,0 +1,197 @@
+package sanitize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// VP8X feature flags for metadata chunks
+const (
+	webpFlagEXIF = 0x08
+	webpFlagXMP  = 0x04
+)
+
+// webpChunk is one top-level chunk of a WebP file and what to do with it
+type webpChunk struct {
+	fourCC  string
+	size    uint32
+	drop    bool
+	replace []byte // New data, or nil to copy the original
+}
+
+func (c *webpChunk) padded() int64 {
+	return int64(c.size) + int64(c.size&1)
+}
+
+// rewriteWebP reads the file once to decide which chunks to keep, since the
+// RIFF header records the size of everything after it, then again to copy.
+func rewriteWebP(open Opener, dst io.Writer, policy Policy, removed *removals) error {
+	chunks, err := planWebP(open, policy, removed)
+	if err != nil {
+		return err
+	}
+
+	src, err := open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	r := bufio.NewReaderSize(src, 64<<10)
+	w := bufio.NewWriterSize(dst, 64<<10)
+
+	if _, err := io.CopyN(io.Discard, r, 12); err != nil {
+		return truncated(err)
+	}
+	riffSize := uint32(4) // "WEBP"
+	for _, c := range chunks {
+		switch {
+		case c.drop:
+		case c.replace != nil:
+			riffSize += 8 + uint32(len(c.replace)+len(c.replace)&1)
+		default:
+			riffSize += 8 + uint32(c.padded())
+		}
+	}
+	w.WriteString("RIFF")
+	binary.Write(w, binary.LittleEndian, riffSize)
+	w.WriteString("WEBP")
+
+	for _, c := range chunks {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return truncated(err)
+		}
+		if string(header[:4]) != c.fourCC || binary.LittleEndian.Uint32(header[4:]) != c.size {
+			return fmt.Errorf("WebP file changed while it was being sanitized")
+		}
+
+		switch {
+		case c.drop:
+			_, err = io.CopyN(io.Discard, r, c.padded())
+		case c.replace != nil:
+			_, err = io.CopyN(io.Discard, r, c.padded())
+			writeWebPChunk(w, c.fourCC, c.replace)
+		default:
+			w.Write(header[:])
+			_, err = io.CopyN(w, r, c.padded())
+		}
+		if err != nil {
+			return truncated(err)
+		}
+	}
+	return w.Flush()
+}
+
+// planWebP lists the file's chunks with the action for each, and records
+// what will be removed
+func planWebP(open Opener, policy Policy, removed *removals) ([]*webpChunk, error) {
+	src, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	r := bufio.NewReaderSize(src, 64<<10)
+
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, truncated(err)
+	}
+	if string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return nil, fmt.Errorf("%w: missing WebP header", ErrMalformed)
+	}
+	remaining := int64(binary.LittleEndian.Uint32(header[4:])) - 4
+
+	var (
+		chunks []*webpChunk
+		vp8x   *webpChunk
+		flags  byte
+	)
+	for remaining > 0 {
+		var chunkHeader [8]byte
+		if _, err := io.ReadFull(r, chunkHeader[:]); err != nil {
+			return nil, truncated(err)
+		}
+		c := &webpChunk{
+			fourCC: string(chunkHeader[:4]),
+			size:   binary.LittleEndian.Uint32(chunkHeader[4:]),
+		}
+		remaining -= 8 + c.padded()
+		if remaining < 0 {
+			return nil, fmt.Errorf("%w: WebP chunk overruns the file", ErrMalformed)
+		}
+		chunks = append(chunks, c)
+
+		switch c.fourCC {
+		case "VP8X", "EXIF", "XMP ":
+		default:
+			if _, err := io.CopyN(io.Discard, r, c.padded()); err != nil {
+				return nil, truncated(err)
+			}
+			continue
+		}
+
+		data, err := readBlock(r, c.padded())
+		if err != nil {
+			return nil, err
+		}
+		data = data[:c.size]
+
+		switch c.fourCC {
+		case "VP8X":
+			if len(data) < 1 {
+				return nil, fmt.Errorf("%w: short VP8X chunk", ErrMalformed)
+			}
+			vp8x, flags = c, data[0]
+			c.replace = data
+		case "EXIF":
+			// Some writers keep the JPEG-style "Exif\0\0" prefix
+			prefix := []byte{}
+			if bytes.HasPrefix(data, jpegExifHeader) {
+				prefix, data = jpegExifHeader, data[len(jpegExifHeader):]
+			}
+			if block := stripTIFF(data, policy, removed); block != nil {
+				c.replace = append(append([]byte{}, prefix...), block...)
+			} else {
+				c.drop = true
+			}
+		case "XMP ":
+			c.drop = !keepXMP(data, policy, removed)
+		}
+	}
+
+	trailer, err := hasTrailer(r)
+	if err != nil {
+		return nil, err
+	}
+	if trailer {
+		removed.add(RemovedTrailer)
+	}
+
+	// Keep the feature flags in step with the chunks that remain
+	if vp8x != nil {
+		flags &^= webpFlagEXIF | webpFlagXMP
+		for _, c := range chunks {
+			switch {
+			case c.drop:
+			case c.fourCC == "EXIF":
+				flags |= webpFlagEXIF
+			case c.fourCC == "XMP ":
+				flags |= webpFlagXMP
+			}
+		}
+		vp8x.replace[0] = flags
+	}
+	return chunks, nil
+}
+
+func writeWebPChunk(w *bufio.Writer, fourCC string, data []byte) {
+	w.WriteString(fourCC)
+	binary.Write(w, binary.LittleEndian, uint32(len(data)))
+	w.Write(data)
+	if len(data)&1 == 1 {
+		w.WriteByte(0)
+	}
+}
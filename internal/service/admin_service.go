@@ -0,0 +1,373 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// adminServer implements AdminService. Scope checks happen in the auth
+// interceptors, so handlers here can assume an admin caller.
+type adminServer struct {
+	pbv1.UnimplementedAdminServiceServer
+
+	database AdminDatabaseInterface
+}
+
+type AdminDatabaseInterface interface {
+	CreateAPIKey(ctx context.Context, key *database.APIKey) error
+	GetAPIKey(ctx context.Context, keyID string) (*database.APIKey, error)
+	ListAPIKeys(ctx context.Context, ownerID string, includeRevoked bool) ([]*database.APIKey, error)
+	RotateAPIKey(ctx context.Context, oldKeyID string, replacement *database.APIKey, graceUntil *time.Time) error
+	RevokeAPIKey(ctx context.Context, keyID string) error
+	SetQuota(ctx context.Context, scope database.QuotaScope, ownerID string, maxBytes, maxFiles *int64) error
+	SetTenantMetadataPolicy(ctx context.Context, tenantID, policy string) error
+	SetTenantVariantLimits(ctx context.Context, tenantID string, maxWidth, maxHeight int) error
+	ListDeadLetterJobs(ctx context.Context, beforeID int64, limit int) ([]*database.ProcessingJob, error)
+	GetJob(ctx context.Context, jobID int64) (*database.ProcessingJob, error)
+	ListJobAttempts(ctx context.Context, jobID int64) ([]*database.JobAttempt, error)
+	RequeueJob(ctx context.Context, jobID int64) error
+}
+
+func NewAdminServer(db AdminDatabaseInterface) pbv1.AdminServiceServer {
+	return &adminServer{database: db}
+}
+
+// Scope enum <-> middleware scope names
+var scopeNames = map[pbv1.ApiKeyScope]middleware.Scope{
+	pbv1.ApiKeyScope_API_KEY_SCOPE_READ:   middleware.ScopeRead,
+	pbv1.ApiKeyScope_API_KEY_SCOPE_WRITE:  middleware.ScopeWrite,
+	pbv1.ApiKeyScope_API_KEY_SCOPE_DELETE: middleware.ScopeDelete,
+	pbv1.ApiKeyScope_API_KEY_SCOPE_ADMIN:  middleware.ScopeAdmin,
+}
+
+func (s *adminServer) CreateAPIKey(ctx context.Context, req *pbv1.CreateAPIKeyRequest) (*pbv1.CreateAPIKeyResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	scopes := make([]string, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		name, ok := scopeNames[scope]
+		if !ok {
+			return nil, status.Errorf(codes.InvalidArgument, "unknown scope %v", scope)
+		}
+		scopes = append(scopes, string(name))
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresAt != nil {
+		t := req.ExpiresAt.AsTime()
+		if !t.After(time.Now()) {
+			return nil, status.Error(codes.InvalidArgument, "expires_at must be in the future")
+		}
+		expiresAt = &t
+	}
+
+	key, secret, err := newAPIKey(req.OwnerId, req.Name, scopes, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+	key.TenantID = req.TenantId
+	if err := s.database.CreateAPIKey(ctx, key); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to create api key: %v", err)
+	}
+
+	return &pbv1.CreateAPIKeyResponse{Key: apiKeyToProto(key), Secret: secret}, nil
+}
+
+func (s *adminServer) ListAPIKeys(ctx context.Context, req *pbv1.ListAPIKeysRequest) (*pbv1.ListAPIKeysResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	keys, err := s.database.ListAPIKeys(ctx, req.OwnerId, req.IncludeRevoked)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list api keys: %v", err)
+	}
+
+	resp := &pbv1.ListAPIKeysResponse{Keys: make([]*pbv1.ApiKey, 0, len(keys))}
+	for _, key := range keys {
+		resp.Keys = append(resp.Keys, apiKeyToProto(key))
+	}
+	return resp, nil
+}
+
+func (s *adminServer) RotateAPIKey(ctx context.Context, req *pbv1.RotateAPIKeyRequest) (*pbv1.RotateAPIKeyResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	old, err := s.database.GetAPIKey(ctx, req.KeyId)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "api key not found: %s", req.KeyId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+	if old.RevokedAt != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "api key %s is revoked", req.KeyId)
+	}
+
+	// The replacement inherits the old key's owner, tenant, name, scopes and expiry
+	key, secret, err := newAPIKey(old.OwnerID, old.Name, old.Scopes, old.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	key.TenantID = old.TenantID
+
+	var graceUntil *time.Time
+	if req.GracePeriodSeconds > 0 {
+		t := time.Now().Add(time.Duration(req.GracePeriodSeconds) * time.Second)
+		graceUntil = &t
+	}
+
+	err = s.database.RotateAPIKey(ctx, old.ID, key, graceUntil)
+	if err == sql.ErrNoRows {
+		// Revoked between the read above and the rotation
+		return nil, status.Errorf(codes.FailedPrecondition, "api key %s is revoked", req.KeyId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to rotate api key: %v", err)
+	}
+
+	return &pbv1.RotateAPIKeyResponse{Key: apiKeyToProto(key), Secret: secret}, nil
+}
+
+func (s *adminServer) RevokeAPIKey(ctx context.Context, req *pbv1.RevokeAPIKeyRequest) (*pbv1.RevokeAPIKeyResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	err := s.database.RevokeAPIKey(ctx, req.KeyId)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "api key not found or already revoked: %s", req.KeyId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to revoke api key: %v", err)
+	}
+
+	return &pbv1.RevokeAPIKeyResponse{Success: true}, nil
+}
+
+func (s *adminServer) SetQuota(ctx context.Context, req *pbv1.SetQuotaRequest) (*pbv1.SetQuotaResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	var scope database.QuotaScope
+	switch req.Scope {
+	case pbv1.QuotaScope_QUOTA_SCOPE_USER:
+		scope = database.QuotaScopeUser
+	case pbv1.QuotaScope_QUOTA_SCOPE_TENANT:
+		scope = database.QuotaScopeTenant
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown quota scope %v", req.Scope)
+	}
+
+	if err := s.database.SetQuota(ctx, scope, req.OwnerId, req.MaxBytes, req.MaxFiles); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set quota: %v", err)
+	}
+
+	return &pbv1.SetQuotaResponse{Success: true}, nil
+}
+
+func (s *adminServer) SetTenantMetadataPolicy(ctx context.Context, req *pbv1.SetTenantMetadataPolicyRequest) (*pbv1.SetTenantMetadataPolicyResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	// Unspecified clears the policy
+	var policy string
+	if req.Policy != pbv1.MetadataPolicy_METADATA_POLICY_UNSPECIFIED {
+		policy = metadataPolicies[req.Policy].String()
+	}
+
+	if err := s.database.SetTenantMetadataPolicy(ctx, req.TenantId, policy); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set metadata policy: %v", err)
+	}
+
+	return &pbv1.SetTenantMetadataPolicyResponse{Success: true}, nil
+}
+
+func (s *adminServer) SetTenantVariantLimits(ctx context.Context, req *pbv1.SetTenantVariantLimitsRequest) (*pbv1.SetTenantVariantLimitsResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	if err := s.database.SetTenantVariantLimits(ctx, req.TenantId, int(req.MaxWidth), int(req.MaxHeight)); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set variant limits: %v", err)
+	}
+
+	return &pbv1.SetTenantVariantLimitsResponse{Success: true}, nil
+}
+
+func (s *adminServer) ListDeadLetterJobs(ctx context.Context, req *pbv1.ListDeadLetterJobsRequest) (*pbv1.ListDeadLetterJobsResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	// The page token is the ID of the last job on the previous page
+	var beforeID int64
+	if req.PageToken != "" {
+		id, err := strconv.ParseInt(req.PageToken, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+		}
+		beforeID = id
+	}
+
+	jobs, err := s.database.ListDeadLetterJobs(ctx, beforeID, int(req.PageSize))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list jobs: %v", err)
+	}
+
+	resp := &pbv1.ListDeadLetterJobsResponse{Jobs: make([]*pbv1.ProcessingJob, 0, len(jobs))}
+	for _, job := range jobs {
+		resp.Jobs = append(resp.Jobs, jobToProto(job, nil))
+	}
+	if len(jobs) == int(req.PageSize) {
+		resp.NextPageToken = strconv.FormatInt(jobs[len(jobs)-1].ID, 10)
+	}
+	return resp, nil
+}
+
+func (s *adminServer) GetProcessingJob(ctx context.Context, req *pbv1.GetProcessingJobRequest) (*pbv1.GetProcessingJobResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	job, err := s.database.GetJob(ctx, req.JobId)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "job not found: %d", req.JobId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+
+	attempts, err := s.database.ListJobAttempts(ctx, req.JobId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load attempts: %v", err)
+	}
+
+	return &pbv1.GetProcessingJobResponse{Job: jobToProto(job, attempts)}, nil
+}
+
+func (s *adminServer) RequeueJob(ctx context.Context, req *pbv1.RequeueJobRequest) (*pbv1.RequeueJobResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	err := s.database.RequeueJob(ctx, req.JobId)
+	if err == sql.ErrNoRows {
+		// Tell "doesn't exist" apart from "isn't dead-lettered"
+		if _, getErr := s.database.GetJob(ctx, req.JobId); getErr == sql.ErrNoRows {
+			return nil, status.Errorf(codes.NotFound, "job not found: %d", req.JobId)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "job %d is not dead-lettered", req.JobId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to requeue job: %v", err)
+	}
+
+	return &pbv1.RequeueJobResponse{Success: true}, nil
+}
+
+// newAPIKey generates key material for a new database row
+func newAPIKey(ownerID, name string, scopes []string, expiresAt *time.Time) (*database.APIKey, string, error) {
+	secret, prefix, hash, err := middleware.GenerateAPIKey()
+	if err != nil {
+		return nil, "", status.Errorf(codes.Internal, "failed to generate api key: %v", err)
+	}
+	return &database.APIKey{
+		ID:        uuid.New().String(),
+		OwnerID:   ownerID,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}, secret, nil
+}
+
+func apiKeyToProto(key *database.APIKey) *pbv1.ApiKey {
+	pb := &pbv1.ApiKey{
+		KeyId:     key.ID,
+		OwnerId:   key.OwnerID,
+		Name:      key.Name,
+		Prefix:    key.Prefix,
+		TenantId:  key.TenantID,
+		CreatedAt: timestamppb.New(key.CreatedAt),
+	}
+	for _, name := range key.Scopes {
+		for scope, n := range scopeNames {
+			if string(n) == name {
+				pb.Scopes = append(pb.Scopes, scope)
+			}
+		}
+	}
+	if key.ExpiresAt != nil {
+		pb.ExpiresAt = timestamppb.New(*key.ExpiresAt)
+	}
+	if key.LastUsedAt != nil {
+		pb.LastUsedAt = timestamppb.New(*key.LastUsedAt)
+	}
+	if key.RevokedAt != nil {
+		pb.RevokedAt = timestamppb.New(*key.RevokedAt)
+	}
+	return pb
+}
+
+func jobToProto(job *database.ProcessingJob, attempts []*database.JobAttempt) *pbv1.ProcessingJob {
+	pb := &pbv1.ProcessingJob{
+		JobId:          job.ID,
+		FileId:         job.FileID,
+		JobType:        job.JobType,
+		Status:         job.Status,
+		FailedAttempts: int32(job.RetryCount),
+		CreatedAt:      timestamppb.New(job.CreatedAt),
+		UpdatedAt:      timestamppb.New(job.UpdatedAt),
+	}
+	if job.ErrorMessage != nil {
+		pb.LastError = *job.ErrorMessage
+	}
+	if job.Status == "pending" {
+		pb.NextAttemptAt = timestamppb.New(job.RunAfter)
+	}
+	if job.WorkerID != nil {
+		pb.WorkerId = *job.WorkerID
+	}
+	for _, attempt := range attempts {
+		a := &pbv1.JobAttempt{
+			FinishedAt:   timestamppb.New(attempt.FinishedAt),
+			ErrorMessage: attempt.ErrorMessage,
+		}
+		if attempt.WorkerID != nil {
+			a.WorkerId = *attempt.WorkerID
+		}
+		if attempt.StartedAt != nil {
+			a.StartedAt = timestamppb.New(*attempt.StartedAt)
+		}
+		pb.Attempts = append(pb.Attempts, a)
+	}
+	return pb
+}
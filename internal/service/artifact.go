@@ -0,0 +1,114 @@
+package service
+
+import (
+	"database/sql"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func (s *fileServer) DownloadArtifact(req *pbv1.DownloadArtifactRequest, stream pbv1.FileService_DownloadArtifactServer) error {
+	ctx := stream.Context()
+
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := s.database.GetFile(ctx, req.FileId)
+	if err == sql.ErrNoRows {
+		return status.Errorf(codes.NotFound, "file not found: %s", req.FileId)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to load file: %v", err)
+	}
+
+	// Artifacts are as private as the file they came from
+	if file.UserID != userID {
+		return status.Error(codes.NotFound, "file not found")
+	}
+
+	jobs, err := s.database.ListJobsByFileID(ctx, file.ID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to load processing jobs: %v", err)
+	}
+	job, artifact, unfinished := findArtifact(jobs, req.Name)
+	if artifact == nil {
+		if unfinished {
+			return status.Errorf(codes.FailedPrecondition,
+				"file %s has no artifact %q yet; processing is still running", file.ID, req.Name)
+		}
+		return status.Errorf(codes.NotFound, "file %s has no artifact %q", file.ID, req.Name)
+	}
+
+	size, err := s.storage.FileSize(artifact.Path)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "artifact %q is missing from storage", req.Name)
+	}
+
+	// Resolve the requested byte range
+	if req.Offset > size {
+		return status.Errorf(codes.OutOfRange,
+			"offset %d beyond end of artifact (%d bytes)", req.Offset, size)
+	}
+	rangeLength := size - req.Offset
+	if req.Length > 0 && req.Length < rangeLength {
+		rangeLength = req.Length
+	}
+
+	err = stream.Send(&pbv1.DownloadArtifactResponse{
+		Data: &pbv1.DownloadArtifactResponse_Info{
+			Info: &pbv1.ArtifactInfo{
+				FileId:      file.ID,
+				JobType:     job.JobType,
+				Artifact:    artifactToProto(artifact),
+				Size:        size,
+				RangeStart:  req.Offset,
+				RangeLength: rangeLength,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if rangeLength == 0 {
+		return nil
+	}
+
+	reader, err := s.storage.ReadFileRange(artifact.Path, req.Offset, rangeLength)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to open artifact: %v", err)
+	}
+	defer reader.Close()
+
+	return sendChunks(ctx, reader, func(chunk []byte) error {
+		return stream.Send(&pbv1.DownloadArtifactResponse{
+			Data: &pbv1.DownloadArtifactResponse_Chunk{Chunk: chunk},
+		})
+	})
+}
+
+// findArtifact looks for the artifact called name in the results of
+// completed jobs. unfinished reports whether a job that might still produce
+// it hasn't finished.
+func findArtifact(jobs []*database.ProcessingJob, name string) (job *database.ProcessingJob, artifact *database.Artifact, unfinished bool) {
+	for _, job := range jobs {
+		state, _ := processingState(job)
+		if state == pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED {
+			if artifact := job.Result.Artifact(name); artifact != nil {
+				return job, artifact, false
+			}
+		} else if !finished(state) {
+			unfinished = true
+		}
+	}
+	return nil, nil, unfinished
+}
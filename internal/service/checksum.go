@@ -0,0 +1,57 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksummer computes SHA-256 and CRC32C over everything written to it
+type checksummer struct {
+	sha256 hash.Hash
+	crc32c hash.Hash32
+}
+
+func newChecksummer() *checksummer {
+	return &checksummer{
+		sha256: sha256.New(),
+		crc32c: crc32.New(crc32cTable),
+	}
+}
+
+func (c *checksummer) Write(p []byte) (int, error) {
+	c.sha256.Write(p)
+	c.crc32c.Write(p)
+	return len(p), nil
+}
+
+// ReadFrom feeds already stored bytes (e.g. a resumed upload's part) into the digests
+func (c *checksummer) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{c}, r)
+}
+
+func (c *checksummer) Sum() database.Checksum {
+	return database.Checksum{
+		SHA256: hex.EncodeToString(c.sha256.Sum(nil)),
+		CRC32C: c.crc32c.Sum32(),
+	}
+}
+
+// verifyChecksum compares computed digests against the client's expectations
+func verifyChecksum(metadata *pbv1.FileMetadata, got database.Checksum) error {
+	if metadata.Sha256 != "" && metadata.Sha256 != got.SHA256 {
+		return fmt.Errorf("sha256 expected %s, computed %s", metadata.Sha256, got.SHA256)
+	}
+	if metadata.Crc32C != nil && *metadata.Crc32C != got.CRC32C {
+		return fmt.Errorf("crc32c expected %08x, computed %08x", *metadata.Crc32C, got.CRC32C)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+var fileTypes = map[pbv1.FileType]database.FileType{
+	pbv1.FileType_FILE_TYPE_UNSPECIFIED: "",
+	pbv1.FileType_FILE_TYPE_IMAGE:       database.FileTypeImage,
+	pbv1.FileType_FILE_TYPE_VIDEO:       database.FileTypeVideo,
+	pbv1.FileType_FILE_TYPE_AUDIO:       database.FileTypeAudio,
+	pbv1.FileType_FILE_TYPE_DOCUMENT:    database.FileTypeDocument,
+	pbv1.FileType_FILE_TYPE_ARCHIVE:     database.FileTypeArchive,
+	pbv1.FileType_FILE_TYPE_OTHER:       database.FileTypeOther,
+}
+
+// fileStatuses are the overall statuses database.FileFilter filters on
+var fileStatuses = map[pbv1.ProcessingStatus]string{
+	pbv1.ProcessingStatus_PROCESSING_STATUS_UNSPECIFIED: "",
+	pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING:     "pending",
+	pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING:  "processing",
+	pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED:   "completed",
+	pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED:      "failed",
+}
+
+// fileStatusToProto converts a database.FileRecord's overall status
+func fileStatusToProto(status string) pbv1.ProcessingStatus {
+	for pb, s := range fileStatuses {
+		if s == status && s != "" {
+			return pb
+		}
+	}
+	return pbv1.ProcessingStatus_PROCESSING_STATUS_UNSPECIFIED
+}
+
+var fileSortFields = map[pbv1.FileSortField]database.FileSortField{
+	pbv1.FileSortField_FILE_SORT_FIELD_UNSPECIFIED: database.SortByUploadedAt,
+	pbv1.FileSortField_FILE_SORT_FIELD_UPLOADED_AT: database.SortByUploadedAt,
+	pbv1.FileSortField_FILE_SORT_FIELD_NAME:        database.SortByName,
+	pbv1.FileSortField_FILE_SORT_FIELD_SIZE:        database.SortBySize,
+}
+
+// listFilesQuery reads the filters and sort order of a ListFiles request
+func listFilesQuery(req *pbv1.ListFilesRequest) (database.FileFilter, database.FileSort, error) {
+	var filter database.FileFilter
+	var sort database.FileSort
+
+	fileType, ok := fileTypes[req.FileType]
+	if !ok {
+		return filter, sort, fmt.Errorf("unknown file_type %d", req.FileType)
+	}
+	fileStatus, ok := fileStatuses[req.ProcessingStatus]
+	if !ok {
+		return filter, sort, fmt.Errorf("unknown processing_status %d", req.ProcessingStatus)
+	}
+	if len(req.ContentTypePrefix) > 255 || len(req.FilenamePrefix) > 255 {
+		return filter, sort, fmt.Errorf("prefixes must be at most 255 characters")
+	}
+	if req.MinSize < 0 || req.MaxSize < 0 {
+		return filter, sort, fmt.Errorf("sizes must not be negative")
+	}
+	if req.MaxSize > 0 && req.MinSize > req.MaxSize {
+		return filter, sort, fmt.Errorf("min_size %d is greater than max_size %d", req.MinSize, req.MaxSize)
+	}
+	filter = database.FileFilter{
+		FileType:          fileType,
+		ContentTypePrefix: req.ContentTypePrefix,
+		MinSize:           req.MinSize,
+		MaxSize:           req.MaxSize,
+		Status:            fileStatus,
+		NamePrefix:        req.FilenamePrefix,
+	}
+	if ts := req.UploadedAfter; ts != nil {
+		if err := ts.CheckValid(); err != nil {
+			return filter, sort, fmt.Errorf("invalid uploaded_after: %w", err)
+		}
+		filter.UploadedAfter = ts.AsTime()
+	}
+	if ts := req.UploadedBefore; ts != nil {
+		if err := ts.CheckValid(); err != nil {
+			return filter, sort, fmt.Errorf("invalid uploaded_before: %w", err)
+		}
+		filter.UploadedBefore = ts.AsTime()
+	}
+	if !filter.UploadedAfter.IsZero() && !filter.UploadedBefore.IsZero() && !filter.UploadedAfter.Before(filter.UploadedBefore) {
+		return filter, sort, fmt.Errorf("uploaded_after must be before uploaded_before")
+	}
+
+	field, ok := fileSortFields[req.SortBy]
+	if !ok {
+		return filter, sort, fmt.Errorf("unknown sort_by %d", req.SortBy)
+	}
+	sort.Field = field
+	switch req.SortDirection {
+	case pbv1.SortDirection_SORT_DIRECTION_UNSPECIFIED:
+		sort.Descending = field != database.SortByName
+	case pbv1.SortDirection_SORT_DIRECTION_ASCENDING:
+	case pbv1.SortDirection_SORT_DIRECTION_DESCENDING:
+		sort.Descending = true
+	default:
+		return filter, sort, fmt.Errorf("unknown sort_direction %d", req.SortDirection)
+	}
+	return filter, sort, nil
+}
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+// pageTokens makes ListFiles and SearchFiles page tokens: a position with a
+// hash of the caller and query it belongs to, signed so clients can neither
+// forge positions nor reuse a token with other filters
+type pageTokens struct {
+	key []byte
+}
+
+// newPageTokens signs with key, or with a random key if it is empty. Tokens
+// signed with a random key only work on this server until it restarts.
+func newPageTokens(key []byte) pageTokens {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			panic(fmt.Sprintf("generate page token key: %v", err))
+		}
+	}
+	return pageTokens{key: key}
+}
+
+// listPageToken is the signed payload of a page token
+type listPageToken struct {
+	Sort       string    `json:"s"` // e.g. "size desc"
+	Filter     string    `json:"f"` // filterHash
+	UploadedAt time.Time `json:"t"`
+	Name       string    `json:"n,omitempty"`
+	Size       int64     `json:"z,omitempty"`
+	ID         string    `json:"i"`
+}
+
+func sortKey(sort database.FileSort) string {
+	if sort.Descending {
+		return string(sort.Field) + " desc"
+	}
+	return string(sort.Field) + " asc"
+}
+
+// filterHash identifies the files a listing covers
+func filterHash(userID string, filter database.FileFilter) string {
+	data, _ := json.Marshal(struct {
+		UserID string
+		Filter database.FileFilter
+	}{userID, filter})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// encode returns the token for the page after cursor
+func (pt pageTokens) encode(userID string, filter database.FileFilter, sort database.FileSort, cursor database.FileCursor) string {
+	return pt.seal(listPageToken{
+		Sort:       sortKey(sort),
+		Filter:     filterHash(userID, filter),
+		UploadedAt: cursor.UploadedAt,
+		Name:       cursor.Name,
+		Size:       cursor.Size,
+		ID:         cursor.ID,
+	})
+}
+
+// decode checks a token's signature and that it belongs to this listing,
+// and returns its cursor
+func (pt pageTokens) decode(token, userID string, filter database.FileFilter, sort database.FileSort) (*database.FileCursor, error) {
+	var t listPageToken
+	if err := pt.open(token, &t); err != nil {
+		return nil, err
+	}
+	if t.Sort != sortKey(sort) || t.Filter != filterHash(userID, filter) {
+		return nil, errors.New("page_token is for a different filter or sort")
+	}
+	return &database.FileCursor{UploadedAt: t.UploadedAt, Name: t.Name, Size: t.Size, ID: t.ID}, nil
+}
+
+// searchPageToken is the signed payload of a SearchFiles page token. Results
+// are ranked rather than sorted by a column, so it holds an offset.
+type searchPageToken struct {
+	Search string `json:"q"` // searchHash
+	Offset int    `json:"o"`
+}
+
+// searchHash identifies the results a search covers
+func searchHash(userID, query string, tags []string) string {
+	data, _ := json.Marshal(struct {
+		UserID string
+		Query  string
+		Tags   []string
+	}{userID, query, tags})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// encodeSearch returns the token for the search results from offset on
+func (pt pageTokens) encodeSearch(userID, query string, tags []string, offset int) string {
+	return pt.seal(searchPageToken{Search: searchHash(userID, query, tags), Offset: offset})
+}
+
+// decodeSearch checks a token's signature and that it belongs to this
+// search, and returns its offset
+func (pt pageTokens) decodeSearch(token, userID, query string, tags []string) (int, error) {
+	var t searchPageToken
+	if err := pt.open(token, &t); err != nil {
+		return 0, err
+	}
+	if t.Search != searchHash(userID, query, tags) || t.Offset < 0 {
+		return 0, errors.New("page_token is for a different search")
+	}
+	return t.Offset, nil
+}
+
+// seal signs payload and encodes it as a token
+func (pt pageTokens) seal(payload any) string {
+	data, _ := json.Marshal(payload)
+	return base64.RawURLEncoding.EncodeToString(append(data, pt.sign(data)...))
+}
+
+// open checks a token's signature and decodes its payload into v
+func (pt pageTokens) open(token string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) <= sha256.Size {
+		return errors.New("malformed page_token")
+	}
+	payload, mac := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	if !hmac.Equal(mac, pt.sign(payload)) {
+		return errors.New("page_token signature mismatch")
+	}
+	if err := json.Unmarshal(payload, v); err != nil {
+		return errors.New("malformed page_token")
+	}
+	return nil
+}
+
+func (pt pageTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, pt.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"context"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// QuotaConfig holds the default limits; per-owner overrides live in the
+// storage_quotas table. Zero values mean unlimited.
+type QuotaConfig struct {
+	User   database.Limit
+	Tenant database.Limit
+}
+
+// ownerFromIdentity returns who uploads by id are charged to
+func ownerFromIdentity(id *middleware.Identity) database.Owner {
+	return database.Owner{UserID: id.UserID, TenantID: id.TenantID}
+}
+
+// quotaState is an owner's usage and effective limit for one scope
+type quotaState struct {
+	ownerID string
+	usage   *database.QuotaUsage
+	limit   database.Limit
+}
+
+// loadQuota fetches usage for one scope and applies overrides to defaultLimit
+func (s *fileServer) loadQuota(ctx context.Context, scope database.QuotaScope, ownerID string, defaultLimit database.Limit) (*quotaState, error) {
+	usage, err := s.database.GetQuotaUsage(ctx, scope, ownerID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load %s usage: %v", scope, err)
+	}
+
+	limit := defaultLimit
+	if usage.MaxBytes != nil {
+		limit.MaxBytes = *usage.MaxBytes
+	}
+	if usage.MaxFiles != nil {
+		limit.MaxFiles = *usage.MaxFiles
+	}
+	return &quotaState{ownerID: ownerID, usage: usage, limit: limit}, nil
+}
+
+// loadQuotas returns the user's quota state and, if the owner has a tenant, the tenant's
+func (s *fileServer) loadQuotas(ctx context.Context, owner database.Owner) (user, tenant *quotaState, err error) {
+	user, err = s.loadQuota(ctx, database.QuotaScopeUser, owner.UserID, s.quotas.User)
+	if err != nil {
+		return nil, nil, err
+	}
+	if owner.TenantID != "" {
+		tenant, err = s.loadQuota(ctx, database.QuotaScopeTenant, owner.TenantID, s.quotas.Tenant)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return user, tenant, nil
+}
+
+// fits reports whether one more file of size bytes stays within the limit
+func (q *quotaState) fits(size int64) bool {
+	if q.limit.MaxBytes > 0 && q.usage.BytesUsed+size > q.limit.MaxBytes {
+		return false
+	}
+	if q.limit.MaxFiles > 0 && q.usage.FileCount+1 > q.limit.MaxFiles {
+		return false
+	}
+	return true
+}
+
+// checkQuota rejects an upload up front if its declared size can't fit, and
+// returns the limits SaveFile should enforce atomically once it's stored
+func (s *fileServer) checkQuota(ctx context.Context, owner database.Owner, size int64) (database.QuotaLimits, error) {
+	user, tenant, err := s.loadQuotas(ctx, owner)
+	if err != nil {
+		return database.QuotaLimits{}, err
+	}
+
+	limits := database.QuotaLimits{User: user.limit}
+	if !user.fits(size) {
+		return limits, quotaExceeded("user", user, size)
+	}
+	if tenant != nil {
+		limits.Tenant = tenant.limit
+		if !tenant.fits(size) {
+			return limits, quotaExceeded("tenant", tenant, size)
+		}
+	}
+	return limits, nil
+}
+
+// quotaLimits returns the limits to charge data derived from owner's files,
+// such as cached variants, against
+func (s *fileServer) quotaLimits(ctx context.Context, owner database.Owner) (database.QuotaLimits, error) {
+	user, tenant, err := s.loadQuotas(ctx, owner)
+	if err != nil {
+		return database.QuotaLimits{}, err
+	}
+	limits := database.QuotaLimits{User: user.limit}
+	if tenant != nil {
+		limits.Tenant = tenant.limit
+	}
+	return limits, nil
+}
+
+func quotaExceeded(scope string, q *quotaState, size int64) error {
+	return status.Errorf(codes.ResourceExhausted,
+		"%s quota exceeded: %d bytes in %d files used, upload of %d bytes would exceed limit of %d bytes / %d files",
+		scope, q.usage.BytesUsed, q.usage.FileCount, size, q.limit.MaxBytes, q.limit.MaxFiles)
+}
+
+func (q *quotaState) toProto() *pbv1.QuotaUsage {
+	return &pbv1.QuotaUsage{
+		OwnerId:   q.ownerID,
+		BytesUsed: q.usage.BytesUsed,
+		FileCount: q.usage.FileCount,
+		MaxBytes:  q.limit.MaxBytes,
+		MaxFiles:  q.limit.MaxFiles,
+	}
+}
+
+func (s *fileServer) GetUsage(ctx context.Context, req *pbv1.GetUsageRequest) (*pbv1.GetUsageResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	id, err := middleware.ExtractIdentity(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	user, tenant, err := s.loadQuotas(ctx, ownerFromIdentity(id))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pbv1.GetUsageResponse{User: user.toProto()}
+	if tenant != nil {
+		resp.Tenant = tenant.toProto()
+	}
+	return resp, nil
+}
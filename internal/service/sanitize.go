@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"io"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/sanitize"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata policy enum <-> sanitize policies
+var metadataPolicies = map[pbv1.MetadataPolicy]sanitize.Policy{
+	pbv1.MetadataPolicy_METADATA_POLICY_KEEP:           sanitize.Keep,
+	pbv1.MetadataPolicy_METADATA_POLICY_STRIP_LOCATION: sanitize.StripLocation,
+	pbv1.MetadataPolicy_METADATA_POLICY_STRIP_ALL:      sanitize.StripAll,
+}
+
+// rawUploadKey is where an upload waits to be sanitized
+func rawUploadKey(fileID string) string {
+	return "upload-" + fileID + ".raw"
+}
+
+// metadataPolicy returns the stricter of the upload's and the tenant's policy
+func (s *fileServer) metadataPolicy(ctx context.Context, owner database.Owner, metadata *pbv1.FileMetadata) (sanitize.Policy, error) {
+	policy := metadataPolicies[metadata.MetadataPolicy]
+	if owner.TenantID == "" {
+		return policy, nil
+	}
+
+	name, err := s.database.GetTenantMetadataPolicy(ctx, owner.TenantID)
+	if err != nil {
+		return policy, status.Errorf(codes.Internal, "failed to load tenant metadata policy: %v", err)
+	}
+	if name == "" {
+		return policy, nil
+	}
+	tenantPolicy, err := sanitize.ParsePolicy(name)
+	if err != nil {
+		return policy, status.Errorf(codes.Internal, "tenant %s: %v", owner.TenantID, err)
+	}
+	return max(policy, tenantPolicy), nil
+}
+
+// detectFormat sniffs the content type of a stored file. The declared type
+// only has to match in its top level ("image/..."), so it can't be trusted
+// to pick the right sanitizer.
+func (s *fileServer) detectFormat(path string) (string, error) {
+	head, err := s.readHead(path)
+	if err != nil {
+		return "", err
+	}
+	return sniffContentType(head), nil
+}
+
+// readHead returns the first 512 bytes stored at path, all that content
+// sniffing looks at
+func (s *fileServer) readHead(path string) ([]byte, error) {
+	r, err := s.storage.ReadFileRange(path, 0, 512)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// sanitizeUpload writes the staged upload at src to fileID with metadata
+// removed per policy, and returns what it removed and the stored size and
+// checksum
+func (s *fileServer) sanitizeUpload(src, fileID, format string, policy sanitize.Policy) ([]string, int64, database.Checksum, error) {
+	w, err := s.storage.CreateFile(fileID)
+	if err != nil {
+		return nil, 0, database.Checksum{}, err
+	}
+
+	digest := newChecksummer()
+	counter := &countingWriter{}
+	removed, err := sanitize.Rewrite(func() (io.ReadCloser, error) {
+		return s.storage.ReadFile(src)
+	}, io.MultiWriter(w, digest, counter), format, policy)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.storage.DeleteFile(fileID)
+		return nil, 0, database.Checksum{}, err
+	}
+	return removed, counter.n, digest.Sum(), nil
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+func sanitizationToProto(sanitization *database.Sanitization) *pbv1.MetadataSanitization {
+	if sanitization == nil {
+		return nil
+	}
+	pb := &pbv1.MetadataSanitization{
+		Removed:        sanitization.Removed,
+		OriginalSize:   sanitization.OriginalSize,
+		OriginalSha256: sanitization.OriginalSHA256,
+	}
+	if policy, err := sanitize.ParsePolicy(sanitization.Policy); err == nil {
+		for enum, p := range metadataPolicies {
+			if p == policy {
+				pb.Policy = enum
+			}
+		}
+	}
+	return pb
+}
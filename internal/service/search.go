@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"unicode/utf8"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// SearchFiles limits
+const (
+	maxSearchQueryLen     = 256
+	defaultSearchPageSize = 20
+)
+
+func (s *fileServer) SearchFiles(ctx context.Context, req *pbv1.SearchFilesRequest) (*pbv1.SearchFilesResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+	query := strings.TrimSpace(req.Query)
+	if query == "" {
+		return nil, status.Error(codes.InvalidArgument, "query is required")
+	}
+	if utf8.RuneCountInString(query) > maxSearchQueryLen {
+		return nil, status.Errorf(codes.InvalidArgument, "query must be at most %d characters", maxSearchQueryLen)
+	}
+	tags, err := normalizeTags(req.Tags)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	limit := int(req.PageSize)
+	if limit < 0 || limit > 100 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must be between 0 and 100")
+	}
+	if limit == 0 {
+		limit = defaultSearchPageSize
+	}
+
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Paged with a signed offset token, like ListFiles' cursors, and one
+	// extra row to tell whether there is another page
+	offset := 0
+	if req.PageToken != "" {
+		offset, err = s.pageTokens.decodeSearch(req.PageToken, userID, query, tags)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %v", err)
+		}
+	}
+
+	matches, err := s.database.SearchFiles(ctx, userID, query, tags, limit+1, offset)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to search files: %v", err)
+	}
+
+	resp := &pbv1.SearchFilesResponse{}
+	for i, match := range matches {
+		if i == limit {
+			resp.NextPageToken = s.pageTokens.encodeSearch(userID, query, tags, offset+limit)
+			break
+		}
+		resp.Results = append(resp.Results, &pbv1.SearchResult{
+			File:    fileEntry(match.File),
+			Rank:    float32(match.Rank),
+			Snippet: snippetParts(match.Snippet),
+		})
+	}
+	return resp, nil
+}
+
+// snippetParts splits a snippet at its match markers
+func snippetParts(snippet string) []*pbv1.SnippetPart {
+	var parts []*pbv1.SnippetPart
+	match := false
+	for snippet != "" {
+		marker := database.SnippetStart
+		if match {
+			marker = database.SnippetStop
+		}
+		text, rest, found := strings.Cut(snippet, marker)
+		if text != "" {
+			parts = append(parts, &pbv1.SnippetPart{Text: text, Match: match})
+		}
+		if !found {
+			break
+		}
+		snippet, match = rest, !match
+	}
+	return parts
+}
@@ -3,9 +3,11 @@ package service
 import (
 	"context"
 	"io"
+	"time"
 
 	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -15,22 +17,50 @@ type fileServer struct {
 	storage   StorageInterface
 	database  DatabaseInterface
 	uploadSem *semaphore.Weighted
+	quotas    QuotaConfig
+	events    *events.Bus
+	jobs      JobPlanner
+
+	variants   VariantConfig
+	variantSem *semaphore.Weighted
+
+	pageTokens pageTokens
+}
+
+// JobPlanner decides which processing jobs to queue for an upload.
+// Satisfied by worker.Registry.
+type JobPlanner interface {
+	JobTypes(contentType string) []string
 }
 
 type StorageInterface interface {
 	CreateFile(fileID string) (io.WriteCloser, error)
 	ReadFile(fileID string) (io.ReadCloser, error)
+	ReadFileRange(fileID string, offset, length int64) (io.ReadCloser, error)
 	DeleteFile(fileID string) error
+	AppendFile(fileID string) (io.WriteCloser, error)
+	FileSize(fileID string) (int64, error)
+	RenameFile(oldID, newID string) error
 }
 
 type DatabaseInterface interface {
-	SaveFile(ctx context.Context, fileID string, metadata *pbv1.FileMetadata, size int64) error
+	SaveFile(ctx context.Context, fileID string, owner database.Owner, metadata *pbv1.FileMetadata, size int64, checksum database.Checksum, sanitization *database.Sanitization, limits database.QuotaLimits) error
 	GetFile(ctx context.Context, fileID string) (*database.FileRecord, error)
-	ListFiles(ctx context.Context, userID string, limit int, offset int) ([]*database.FileRecord, error)
-	DeleteFile(ctx context.Context, fileID, userID string) error
-	CreateProcessingJob(ctx context.Context, fileID string) (int64, error)
-	GetNextPendingJob(ctx context.Context) (*database.ProcessingJob, error)
-	UpdateJobStatus(ctx context.Context, jobID int64, status, errorMsg string) error
-	CompleteJob(ctx context.Context, jobID int64, thumbSmall, thumbMed, thumbLarge string, width, height int) error
-	GetJobByFileID(ctx context.Context, fileID string) (*database.ProcessingJob, error)
+	ListFiles(ctx context.Context, userID string, filter database.FileFilter, sort database.FileSort, after *database.FileCursor, limit int) ([]*database.FileRecord, error)
+	FindSimilarImages(ctx context.Context, userID, fileID, hashKey, hash string, maxDistance, limit int) ([]*database.SimilarFile, error)
+	SearchFiles(ctx context.Context, userID, query string, tags []string, limit, offset int) ([]*database.SearchResult, error)
+	DeleteFile(ctx context.Context, fileID, userID string) ([]string, error)
+	RecordImageVariant(ctx context.Context, fileID, key string, size int64, limits database.QuotaLimits) error
+	CreateProcessingJob(ctx context.Context, fileID, jobType string) (int64, error)
+	ListJobsByFileID(ctx context.Context, fileID string) ([]*database.ProcessingJob, error)
+	CreateUploadSession(ctx context.Context, session *database.UploadSession) error
+	GetUploadSession(ctx context.Context, uploadID string) (*database.UploadSession, error)
+	ExtendUploadSession(ctx context.Context, uploadID string, expiresAt time.Time) error
+	ClaimUploadSession(ctx context.Context, uploadID, claimID string, ttl time.Duration) error
+	RenewUploadSessionClaim(ctx context.Context, uploadID, claimID string, ttl time.Duration) error
+	ReleaseUploadSession(ctx context.Context, uploadID, claimID string) error
+	DeleteUploadSession(ctx context.Context, uploadID string) error
+	GetQuotaUsage(ctx context.Context, scope database.QuotaScope, ownerID string) (*database.QuotaUsage, error)
+	GetTenantMetadataPolicy(ctx context.Context, tenantID string) (string, error)
+	GetTenantVariantLimits(ctx context.Context, tenantID string) (maxWidth, maxHeight int, err error)
 }
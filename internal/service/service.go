@@ -4,11 +4,18 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"io"
 	"log"
-	"strconv"
+	"runtime"
+	"sync"
 
 	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/sanitize"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
 	"github.com/google/uuid"
 	"golang.org/x/sync/semaphore"
 	"google.golang.org/grpc/codes"
@@ -21,15 +28,61 @@ const (
 	maxChunkSize = 4 * 1024 * 1024   // 4MB per gRPC message limit
 )
 
-func NewFileServer(storage StorageInterface, db DatabaseInterface) *fileServer {
+// FileServerOptions configures NewFileServer. The zero value is a working
+// server without quotas, processing jobs or job events.
+type FileServerOptions struct {
+	// Quotas are the default storage limits (default: unlimited)
+	Quotas QuotaConfig
+	// Events delivers processing job events to WatchFile; if nil, WatchFile
+	// polls the database instead
+	Events *events.Bus
+	// Jobs picks the processing jobs queued for each upload; if nil, no
+	// jobs are queued
+	Jobs JobPlanner
+	// Variants bounds GetImageVariant
+	Variants VariantConfig
+	// PageTokenKey signs page tokens; every replica needs the same key. If
+	// empty, a random key is used and tokens don't survive a restart.
+	PageTokenKey []byte
+}
+
+// NewFileServer creates the FileService on top of storage and db
+func NewFileServer(storage StorageInterface, db DatabaseInterface, opts FileServerOptions) *fileServer {
+	variants := opts.Variants
+	if variants.MaxWidth <= 0 {
+		variants.MaxWidth = 2048
+	}
+	if variants.MaxHeight <= 0 {
+		variants.MaxHeight = 2048
+	}
+	if variants.Concurrency <= 0 {
+		variants.Concurrency = runtime.NumCPU()
+	}
+	if variants.MaxSourcePixels <= 0 {
+		variants.MaxSourcePixels = worker.DefaultMaxImagePixels
+	}
+
 	return &fileServer{
-		storage:   storage,
-		database:  db,
-		uploadSem: semaphore.NewWeighted(100),
+		storage:    storage,
+		database:   db,
+		uploadSem:  semaphore.NewWeighted(100),
+		quotas:     opts.Quotas,
+		events:     opts.Events,
+		jobs:       opts.Jobs,
+		variants:   variants,
+		variantSem: semaphore.NewWeighted(int64(variants.Concurrency)),
+		pageTokens: newPageTokens(opts.PageTokenKey),
 	}
 }
 
 func (s *fileServer) UploadFile(stream pbv1.FileService_UploadFileServer) error {
+	// The owner is the authenticated caller, never a field in the request
+	id, err := middleware.ExtractIdentity(stream.Context())
+	if err != nil {
+		return err
+	}
+	owner := ownerFromIdentity(id)
+
 	//  Receive metadata
 	firstMsg, err := stream.Recv()
 	if err != nil {
@@ -45,6 +98,9 @@ func (s *fileServer) UploadFile(stream pbv1.FileService_UploadFileServer) error
 	if err := metadata.Validate(); err != nil {
 		return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
 	}
+	if err := normalizeLabels(metadata); err != nil {
+		return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
 
 	//  Enforce size limit
 	if metadata.Size > maxFileSize {
@@ -52,21 +108,81 @@ func (s *fileServer) UploadFile(stream pbv1.FileService_UploadFileServer) error
 			"file too large: %d bytes (max %d)", metadata.Size, maxFileSize)
 	}
 
-	// Create file in storage
+	ctx := stream.Context()
+
+	//  Reject early if the declared size can't fit the caller's quota
+	limits, err := s.checkQuota(ctx, owner, metadata.Size)
+	if err != nil {
+		return err
+	}
+
+	policy, err := s.metadataPolicy(ctx, owner, metadata)
+	if err != nil {
+		return err
+	}
+
+	// Create file in storage (or reopen the staged part of a resumable upload).
+	// Uploads that may have metadata removed are staged too, so the original
+	// never lands under the file's ID.
 	fileID := uuid.New().String()
-	writer, err := s.storage.CreateFile(fileID)
+	stagedPath := fileID
+	if policy != sanitize.Keep {
+		stagedPath = rawUploadKey(fileID)
+	}
+	var session *database.UploadSession
+	var claim *uploadClaim
+	var writer io.WriteCloser
+	totalSize := int64(0)
+	if metadata.UploadId != "" {
+		session, claim, err = s.openUploadSession(ctx, owner.UserID, metadata)
+		if err != nil {
+			return err
+		}
+		// Deferred before the writer's Close, so it runs after it
+		defer claim.release(ctx)
+		stagedPath = session.StoragePath
+		totalSize = metadata.ResumeOffset
+		if totalSize == 0 {
+			writer, err = s.storage.CreateFile(stagedPath)
+		} else {
+			writer, err = s.storage.AppendFile(stagedPath)
+		}
+	} else {
+		writer, err = s.storage.CreateFile(stagedPath)
+	}
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to create file: %v", err)
 	}
+	// The upload is flushed before it is read back, and closed again on return
+	writer = &closeOnceWriter{WriteCloser: writer}
 	defer writer.Close()
 
-	// Buffer for magic byte validation
-	var firstChunk []byte
-	validateMagicBytes := true
+	// Hash bytes as they are stored. A resumed upload first replays the part
+	// that is already committed so the digest covers the whole file.
+	digest := newChecksummer()
+	if totalSize > 0 {
+		if err := s.replayCommitted(session, digest); err != nil {
+			return status.Errorf(codes.Internal, "failed to read committed bytes: %v", err)
+		}
+	}
+
+	// discard drops everything received so far. Resumable sessions survive
+	// transport failures, but not data that fails validation.
+	discard := func() {
+		if session != nil {
+			s.discardUploadSession(ctx, session)
+			return
+		}
+		s.storage.DeleteFile(stagedPath)
+	}
+
+	// Magic bytes are checked on the first chunk if it has enough of them;
+	// otherwise (a short first chunk, or a resumed upload) on the stored
+	// bytes once they are all in
+	validateMagicBytes := totalSize == 0
+	contentChecked := false
 
-	ctx := stream.Context()
 	//  Stream chunks with enforced limits
-	totalSize := int64(0)
 	for {
 		// Check if context is canceled before receiving
 		select {
@@ -80,21 +196,22 @@ func (s *fileServer) UploadFile(stream pbv1.FileService_UploadFileServer) error
 			break
 		}
 		if err != nil {
-			// Clean up on failure
-			s.storage.DeleteFile(fileID)
+			// Clean up on failure; resumable uploads keep what was written
+			if session == nil {
+				s.storage.DeleteFile(stagedPath)
+			}
 			return status.Errorf(codes.Internal, "failed to receive chunk: %v", err)
 		}
 
 		chunk := msg.GetChunk()
 		// Validate magic bytes on first chunk
 		if validateMagicBytes && len(chunk) > 0 {
-			firstChunk = chunk
-			if len(firstChunk) >= 512 || totalSize+int64(len(chunk)) == metadata.Size {
-				reader := bytes.NewReader(firstChunk)
-				if err := ValidateContentType(reader, metadata.ContentType); err != nil {
-					s.storage.DeleteFile(fileID)
+			if len(chunk) >= 512 || totalSize+int64(len(chunk)) == metadata.Size {
+				if err := ValidateContentType(bytes.NewReader(chunk), metadata.ContentType); err != nil {
+					discard()
 					return status.Errorf(codes.InvalidArgument, "invalid file: %v", err)
 				}
+				contentChecked = true
 			}
 			validateMagicBytes = false
 		}
@@ -103,52 +220,164 @@ func (s *fileServer) UploadFile(stream pbv1.FileService_UploadFileServer) error
 
 		// Check chunk size
 		if chunkLen > maxChunkSize {
-			s.storage.DeleteFile(fileID)
+			discard()
 			return status.Errorf(codes.InvalidArgument,
 				"chunk too large: %d bytes (max %d)", chunkLen, maxChunkSize)
 		}
 
 		// Check total size doesn't exceed declared size
 		if totalSize+chunkLen > metadata.Size {
-			s.storage.DeleteFile(fileID)
+			discard()
 			return status.Errorf(codes.InvalidArgument,
 				"received %d bytes, expected %d", totalSize+chunkLen, metadata.Size)
 		}
 
+		// Make sure no other stream took the session over while this one waited
+		if claim != nil {
+			if err := claim.renew(ctx); err != nil {
+				return err
+			}
+		}
+
 		// Write chunk
 		n, err := writer.Write(chunk)
 		if err != nil {
-			s.storage.DeleteFile(fileID)
+			if session == nil {
+				s.storage.DeleteFile(stagedPath)
+			}
 			return status.Errorf(codes.Internal, "failed to write chunk: %v", err)
 		}
+		digest.Write(chunk[:n])
 		totalSize += int64(n)
 	}
 
 	// Verify final size matches declared size
 	if totalSize != metadata.Size {
-		s.storage.DeleteFile(fileID)
+		if session != nil {
+			// Keep the partial data so the client can resume
+			return status.Errorf(codes.FailedPrecondition,
+				"incomplete upload: received %d bytes, expected %d; resume from offset %d",
+				totalSize, metadata.Size, totalSize)
+		}
+		s.storage.DeleteFile(stagedPath)
 		return status.Errorf(codes.InvalidArgument,
 			"size mismatch: received %d bytes, expected %d", totalSize, metadata.Size)
 	}
 
+	// The part is complete; hold on to it until it is committed
+	if claim != nil {
+		if err := claim.hold(ctx); err != nil {
+			return err
+		}
+	}
+
+	if !contentChecked {
+		if err := writer.Close(); err != nil {
+			return status.Errorf(codes.Internal, "failed to flush upload: %v", err)
+		}
+		head, err := s.readHead(stagedPath)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to read upload: %v", err)
+		}
+		if err := ValidateContentType(bytes.NewReader(head), metadata.ContentType); err != nil {
+			discard()
+			return status.Errorf(codes.InvalidArgument, "invalid file: %v", err)
+		}
+	}
+
+	// Verify integrity before the file becomes visible
+	checksum := digest.Sum()
+	if err := verifyChecksum(metadata, checksum); err != nil {
+		discard()
+		return status.Errorf(codes.DataLoss, "checksum mismatch: %v", err)
+	}
+
+	// Promote a staged upload to its permanent file ID, removing metadata
+	// on the way if the policy asks for it
+	storedSize, storedChecksum := totalSize, checksum
+	var sanitization *database.Sanitization
+	if stagedPath != fileID {
+		if err := writer.Close(); err != nil {
+			return status.Errorf(codes.Internal, "failed to flush upload: %v", err)
+		}
+
+		format := ""
+		if policy != sanitize.Keep {
+			if format, err = s.detectFormat(stagedPath); err != nil {
+				return status.Errorf(codes.Internal, "failed to read upload: %v", err)
+			}
+		}
+
+		if sanitize.Supported(format) {
+			removed, size, sum, err := s.sanitizeUpload(stagedPath, fileID, format, policy)
+			if errors.Is(err, sanitize.ErrMalformed) {
+				discard()
+				return status.Errorf(codes.InvalidArgument, "invalid file: %v", err)
+			}
+			if err != nil {
+				// A resumable upload keeps its bytes; resuming at the end retries
+				if session == nil {
+					s.storage.DeleteFile(stagedPath)
+				}
+				return status.Errorf(codes.Internal, "failed to remove metadata: %v", err)
+			}
+			s.storage.DeleteFile(stagedPath)
+
+			storedSize, storedChecksum = size, sum
+			sanitization = &database.Sanitization{
+				Policy:         policy.String(),
+				Removed:        removed,
+				OriginalSize:   totalSize,
+				OriginalSHA256: checksum.SHA256,
+			}
+		} else if err := s.storage.RenameFile(stagedPath, fileID); err != nil {
+			return status.Errorf(codes.Internal, "failed to commit upload: %v", err)
+		}
+	}
+
 	//  Save metadata to database
-	if err := s.database.SaveFile(stream.Context(), fileID, metadata, totalSize); err != nil {
+	// (usage is charged in the same transaction, so concurrent uploads can't
+	// both squeeze under the quota)
+	if err := s.database.SaveFile(ctx, fileID, owner, metadata, storedSize, storedChecksum, sanitization, limits); err != nil {
 		s.storage.DeleteFile(fileID)
+		if session != nil {
+			s.database.DeleteUploadSession(context.WithoutCancel(ctx), session.UploadID)
+		}
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			return status.Errorf(codes.ResourceExhausted, "upload rejected: %v", err)
+		}
 		return status.Errorf(codes.Internal, "failed to save metadata: %v", err)
 	}
 
-	// Create processing job
-	if _, err := s.database.CreateProcessingJob(stream.Context(), fileID); err != nil {
-		// Non-fatal: log warning
-		log.Printf("Warning: failed to create processing job: %v\n", err)
+	if session != nil {
+		if err := s.database.DeleteUploadSession(ctx, session.UploadID); err != nil {
+			// Non-fatal: the reaper removes it once it expires
+			log.Printf("Warning: failed to delete upload session %s: %v\n", session.UploadID, err)
+		}
+	}
+
+	// Queue processing jobs
+	processingStatus := pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED
+	if s.jobs != nil {
+		for _, jobType := range s.jobs.JobTypes(metadata.ContentType) {
+			if _, err := s.database.CreateProcessingJob(ctx, fileID, jobType); err != nil {
+				// Non-fatal: log warning
+				log.Printf("Warning: failed to create %s processing job: %v\n", jobType, err)
+				continue
+			}
+			processingStatus = pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING
+		}
 	}
 
 	//  Send response
 	return stream.SendAndClose(&pbv1.UploadFileResponse{
 		FileId:           fileID,
 		Filename:         metadata.Filename,
-		Size:             totalSize,
-		ProcessingStatus: pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING,
+		Size:             storedSize,
+		ProcessingStatus: processingStatus,
+		Sha256:           storedChecksum.SHA256,
+		Crc32C:           storedChecksum.CRC32C,
+		Sanitization:     sanitizationToProto(sanitization),
 	})
 }
 
@@ -160,12 +389,32 @@ func (s *fileServer) DownloadFile(req *pbv1.DownloadFileRequest, stream pbv1.Fil
 		return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
 	}
 
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return err
+	}
+
 	//  Get file metadata
 	file, err := s.database.GetFile(stream.Context(), req.FileId)
 	if err != nil {
 		return status.Errorf(codes.NotFound, "file not found: %v", err)
 	}
 
+	//  Ownership check (other users' files don't exist as far as the caller knows)
+	if file.UserID != userID {
+		return status.Error(codes.NotFound, "file not found")
+	}
+
+	//  . Resolve the requested byte range
+	if req.Offset > file.Size {
+		return status.Errorf(codes.OutOfRange,
+			"offset %d beyond end of file (%d bytes)", req.Offset, file.Size)
+	}
+	rangeLength := file.Size - req.Offset
+	if req.Length > 0 && req.Length < rangeLength {
+		rangeLength = req.Length
+	}
+
 	//  . Send file info first
 	err = stream.Send(&pbv1.DownloadFileResponse{
 		Data: &pbv1.DownloadFileResponse_Info{
@@ -174,22 +423,54 @@ func (s *fileServer) DownloadFile(req *pbv1.DownloadFileRequest, stream pbv1.Fil
 				Filename:    file.Name,
 				ContentType: file.ContentType,
 				Size:        file.Size,
+				UploadedAt:  timestamppb.New(file.UploadedAt),
+				Sha256:      file.Checksum.SHA256,
+				Crc32C:      file.Checksum.CRC32C,
+				RangeStart:  req.Offset,
+				RangeLength: rangeLength,
 			},
 		},
 	})
 	if err != nil {
 		return err
 	}
+	if rangeLength == 0 {
+		return nil
+	}
 
-	//  . Open file from storage
-	reader, err := s.storage.ReadFile(file.ID)
+	//  . Open file from storage, positioned at the range
+	reader, err := s.storage.ReadFileRange(file.ID, req.Offset, rangeLength)
 	if err != nil {
 		return status.Errorf(codes.Internal, "failed to open file: %v", err)
 	}
 	defer reader.Close()
 
 	//  . Stream chunks to client
-	buffer := make([]byte, 64*1024) // 64KB chunks
+	return sendChunks(ctx, reader, func(chunk []byte) error {
+		return stream.Send(&pbv1.DownloadFileResponse{
+			Data: &pbv1.DownloadFileResponse_Chunk{
+				Chunk: chunk,
+			},
+		})
+	})
+}
+
+// closeOnceWriter makes repeated Close calls return the first call's result.
+// A filesystem file reports an error when it is closed twice.
+type closeOnceWriter struct {
+	io.WriteCloser
+	once sync.Once
+	err  error
+}
+
+func (w *closeOnceWriter) Close() error {
+	w.once.Do(func() { w.err = w.WriteCloser.Close() })
+	return w.err
+}
+
+// sendChunks streams reader to send in 64KB chunks
+func sendChunks(ctx context.Context, reader io.Reader, send func(chunk []byte) error) error {
+	buffer := make([]byte, 64*1024)
 	for {
 		select {
 		case <-ctx.Done():
@@ -197,25 +478,20 @@ func (s *fileServer) DownloadFile(req *pbv1.DownloadFileRequest, stream pbv1.Fil
 		default:
 		}
 
+		// Readers may return data together with io.EOF (S3 objects do)
 		n, err := reader.Read(buffer)
+		if n > 0 {
+			if sendErr := send(buffer[:n]); sendErr != nil {
+				return sendErr
+			}
+		}
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
 			return status.Errorf(codes.Internal, "failed to read file: %v", err)
 		}
-
-		err = stream.Send(&pbv1.DownloadFileResponse{
-			Data: &pbv1.DownloadFileResponse_Chunk{
-				Chunk: buffer[:n],
-			},
-		})
-		if err != nil {
-			return err
-		}
 	}
-
-	return nil
 }
 
 func (s *fileServer) GetFileMetadata(ctx context.Context, req *pbv1.GetFileMetadataRequest) (*pbv1.GetFileMetadataResponse, error) {
@@ -225,42 +501,28 @@ func (s *fileServer) GetFileMetadata(ctx context.Context, req *pbv1.GetFileMetad
 		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
 	}
 
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	//  Query database
 	file, err := s.database.GetFile(ctx, req.FileId)
 	if err != nil {
 		return nil, status.Errorf(codes.NotFound, "file not found: %v", err)
 	}
 
-	//  Get processing job
-	job, err := s.database.GetJobByFileID(ctx, req.FileId)
-	processingStatus := pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING
-	var processingResult *pbv1.ProcessingResult
-
-	if err == nil && job != nil {
-		// Map job status to proto enum
-		switch job.Status {
-		case "completed":
-			processingStatus = pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED
-			processingResult = &pbv1.ProcessingResult{
-				ThumbnailSmall:  job.ThumbnailSmall,
-				ThumbnailMedium: job.ThumbnailMedium,
-				ThumbnailLarge:  job.ThumbnailLarge,
-				OriginalWidth:   int32(job.OriginalWidth),
-				OriginalHeight:  int32(job.OriginalHeight),
-			}
-		case "processing":
-			processingStatus = pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING
-		case "failed":
-			processingStatus = pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED
-			errorMsg := ""
-			if job.ErrorMessage != nil {
-				errorMsg = *job.ErrorMessage
-			}
-			processingResult = &pbv1.ProcessingResult{
-				ErrorMessage: errorMsg,
-			}
-		}
+	//  Ownership check
+	if file.UserID != userID {
+		return nil, status.Error(codes.NotFound, "file not found")
+	}
+
+	//  Get processing jobs
+	jobs, err := s.database.ListJobsByFileID(ctx, req.FileId)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load processing jobs: %v", err)
 	}
+	processingStatus, processingResult := aggregateState(jobs)
 
 	return &pbv1.GetFileMetadataResponse{
 		FileId:           file.ID,
@@ -270,6 +532,12 @@ func (s *fileServer) GetFileMetadata(ctx context.Context, req *pbv1.GetFileMetad
 		UploadedAt:       timestamppb.New(file.UploadedAt),
 		ProcessingStatus: processingStatus,
 		ProcessingResult: processingResult,
+		Sha256:           file.Checksum.SHA256,
+		Crc32C:           file.Checksum.CRC32C,
+		Tasks:            tasksToProto(jobs),
+		Sanitization:     sanitizationToProto(file.Sanitization),
+		Tags:             file.Tags,
+		CustomMetadata:   file.CustomMetadata,
 	}, nil
 }
 
@@ -279,20 +547,31 @@ func (fs *fileServer) ListFiles(ctx context.Context, req *pbv1.ListFilesRequest)
 		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
 	}
 
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, sort, err := listFilesQuery(req)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
 	//  . Set reasonable defaults
 	limit := int(req.PageSize)
 	if limit <= 0 || limit > 100 {
 		limit = 20
 	}
-	offset := 0
+	var after *database.FileCursor
 	if req.PageToken != "" {
-		// Simple integer offset encoded as string (i will upgrade to base64 cursor later
-		parsed, _ := strconv.Atoi(req.PageToken)
-		offset = parsed
+		after, err = fs.pageTokens.decode(req.PageToken, userID, filter, sort)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %v", err)
+		}
 	}
 
 	//  . Fetch from DB ( +1 to check if there's more)
-	records, err := fs.database.ListFiles(ctx, req.UserId, limit+1, offset)
+	records, err := fs.database.ListFiles(ctx, userID, filter, sort, after, limit+1)
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to list files: %v", err)
 	}
@@ -304,21 +583,13 @@ func (fs *fileServer) ListFiles(ctx context.Context, req *pbv1.ListFilesRequest)
 			// this is the "has more" marker
 			break
 		}
-		entries = append(entries, &pbv1.FileEntry{
-			FileId:      rec.ID,
-			Filename:    rec.Name,
-			ContentType: rec.ContentType,
-			Size:        rec.Size,
-			UploadedAt:  timestamppb.New(rec.UploadedAt),
-			// placeholder for now
-			ProcessingStatus: pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED,
-		})
+		entries = append(entries, fileEntry(rec))
 	}
 
-	//  . Next page token if needed
+	//  . Next page token if needed: the position after the last entry
 	nextToken := ""
 	if len(records) > limit {
-		nextToken = strconv.Itoa(offset + limit)
+		nextToken = fs.pageTokens.encode(userID, filter, sort, database.CursorAfter(records[limit-1]))
 	}
 
 	return &pbv1.ListFilesResponse{
@@ -327,12 +598,38 @@ func (fs *fileServer) ListFiles(ctx context.Context, req *pbv1.ListFilesRequest)
 	}, nil
 }
 
+// fileEntry is the listing view of a file
+func fileEntry(rec *database.FileRecord) *pbv1.FileEntry {
+	entry := &pbv1.FileEntry{
+		FileId:           rec.ID,
+		Filename:         rec.Name,
+		ContentType:      rec.ContentType,
+		Size:             rec.Size,
+		UploadedAt:       timestamppb.New(rec.UploadedAt),
+		ProcessingStatus: fileStatusToProto(rec.ProcessingStatus),
+		Blurhash:         rec.BlurHash,
+		Tags:             rec.Tags,
+		CustomMetadata:   rec.CustomMetadata,
+		Width:            int32(rec.Width),
+		Height:           int32(rec.Height),
+	}
+	if rec.Thumbnail != nil {
+		entry.Thumbnail = artifactToProto(rec.Thumbnail)
+	}
+	return entry
+}
+
 func (fs *fileServer) DeleteFile(ctx context.Context, req *pbv1.DeleteFileRequest) (*pbv1.DeleteFileResponse, error) {
 	// Validate request
 	if err := req.Validate(); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
 	}
 
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	//  . Delete from storage first (fail-fast if file missing)
 	file, err := fs.database.GetFile(ctx, req.FileId)
 	if err == sql.ErrNoRows {
@@ -342,9 +639,9 @@ func (fs *fileServer) DeleteFile(ctx context.Context, req *pbv1.DeleteFileReques
 		return nil, status.Errorf(codes.Internal, "database error: %v", err)
 	}
 
-	//  . Ownership check
-	if file.UserID != req.UserId {
-		return nil, status.Errorf(codes.PermissionDenied, "not owner: %v", err)
+	//  . Ownership check (another user's file looks like a missing one)
+	if file.UserID != userID {
+		return nil, status.Error(codes.NotFound, "file not found")
 	}
 
 	//  . Delete from storage
@@ -354,10 +651,18 @@ func (fs *fileServer) DeleteFile(ctx context.Context, req *pbv1.DeleteFileReques
 	}
 
 	//  . Soft-delete in DB
-	if err := fs.database.DeleteFile(ctx, req.FileId, req.UserId); err != nil {
+	derived, err := fs.database.DeleteFile(ctx, req.FileId, userID)
+	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to delete file metadata: %v", err)
 	}
 
+	//  . Delete cached variants and job artifacts (thumbnails, previews)
+	for _, key := range derived {
+		if err := fs.storage.DeleteFile(key); err != nil {
+			log.Printf("Warning: failed to delete %s from storage: %v", key, err)
+		}
+	}
+
 	return &pbv1.DeleteFileResponse{
 		Success: true,
 		Message: "file deleted",
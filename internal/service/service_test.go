@@ -1,31 +1,85 @@
 package service_test
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/jpeg"
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"net"
 
 	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/service"
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const bufSize = 1024 * 1024
 
 var (
-	lis        *bufconn.Listener
-	testUserID = "550e8400-e29b-41d4-a716-446655440000"
+	lis           *bufconn.Listener
+	testUserID    = "550e8400-e29b-41d4-a716-446655440000"
+	testJWTSecret = []byte("test-secret")
+	// Every test upload gets these processing jobs; no worker runs them
+	testJobs = testJobPlanner{"alpha", "beta"}
 )
 
+type testJobPlanner []string
+
+func (p testJobPlanner) JobTypes(string) []string { return p }
+
+// bearerToken attaches a signed HS256 token to every RPC
+type bearerToken string
+
+func (b bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(b)}, nil
+}
+
+func (bearerToken) RequireTransportSecurity() bool { return false }
+
+// apiKey attaches an API key to every RPC
+type apiKey string
+
+func (k apiKey) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"api-key": string(k)}, nil
+}
+
+func (apiKey) RequireTransportSecurity() bool { return false }
+
+// signTestToken signs a token for subject; an empty scope gets the default user scopes
+func signTestToken(t *testing.T, subject, scope string) bearerToken {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   subject,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		Scope: scope,
+	})
+	signed, err := token.SignedString(testJWTSecret)
+	require.NoError(t, err)
+	return bearerToken(signed)
+}
+
 func setupTestServer(t *testing.T) (pbv1.FileServiceClient, func()) {
 	// Setup test database
 	dbURL := os.Getenv("UPLOADSTREAM")
@@ -40,10 +94,27 @@ func setupTestServer(t *testing.T) (pbv1.FileServiceClient, func()) {
 	tmpDir := t.TempDir()
 	storageLayer := storage.NewFilesystemStorage(tmpDir)
 
+	// Processing job events, as wired in cmd/server
+	bus := events.NewBus()
+	listenCtx, stopListening := context.WithCancel(context.Background())
+	require.NoError(t, events.ListenPostgres(listenCtx, dbURL, bus))
+
 	// Create gRPC server
 	lis = bufconn.Listen(bufSize)
-	server := grpc.NewServer()
-	pbv1.RegisterFileServiceServer(server, service.NewFileServer(storageLayer, db))
+	verifier, err := middleware.NewJWTVerifier(middleware.JWTConfig{HS256Secret: testJWTSecret})
+	require.NoError(t, err)
+	authenticator := middleware.NewAuthenticator(verifier, db)
+
+	server := grpc.NewServer(
+		grpc.UnaryInterceptor(middleware.UnaryAuthInterceptor(authenticator)),
+		grpc.StreamInterceptor(middleware.StreamAuthInterceptor(authenticator)),
+	)
+	pbv1.RegisterFileServiceServer(server, service.NewFileServer(storageLayer, db, service.FileServerOptions{
+		Events:       bus,
+		Jobs:         testJobs,
+		PageTokenKey: []byte("test-page-token-key"),
+	}))
+	pbv1.RegisterAdminServiceServer(server, service.NewAdminServer(db))
 
 	go func() {
 		if err := server.Serve(lis); err != nil {
@@ -52,6 +123,20 @@ func setupTestServer(t *testing.T) (pbv1.FileServiceClient, func()) {
 	}()
 
 	// Create client
+	conn := dialTestServer(t, signTestToken(t, testUserID, ""))
+	client := pbv1.NewFileServiceClient(conn)
+
+	cleanup := func() {
+		conn.Close()
+		server.Stop()
+		stopListening()
+	}
+
+	return client, cleanup
+}
+
+// dialTestServer connects to the bufconn server with the given credentials
+func dialTestServer(t *testing.T, creds credentials.PerRPCCredentials) *grpc.ClientConn {
 	conn, err := grpc.DialContext(
 		context.Background(),
 		"bufnet",
@@ -59,17 +144,10 @@ func setupTestServer(t *testing.T) (pbv1.FileServiceClient, func()) {
 			return lis.Dial()
 		}),
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithPerRPCCredentials(creds),
 	)
 	require.NoError(t, err)
-
-	client := pbv1.NewFileServiceClient(conn)
-
-	cleanup := func() {
-		conn.Close()
-		server.Stop()
-	}
-
-	return client, cleanup
+	return conn
 }
 
 func TestUploadDownloadFlow(t *testing.T) {
@@ -92,7 +170,6 @@ func TestUploadDownloadFlow(t *testing.T) {
 				Filename:    "test.txt",
 				ContentType: "text/plain",
 				Size:        int64(len(testContent)),
-				UserId:      testUserID,
 			},
 		},
 	})
@@ -150,16 +227,22 @@ func TestUploadDownloadFlow(t *testing.T) {
 
 	// 4. List files
 	listResp, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{
-		UserId:   testUserID,
 		PageSize: 10,
 	})
 	require.NoError(t, err)
 	assert.GreaterOrEqual(t, len(listResp.Files), 1)
 
-	// 5. Delete file
+	// 5. Another user can't tell the file exists, let alone delete it
+	otherConn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer otherConn.Close()
+	_, err = pbv1.NewFileServiceClient(otherConn).DeleteFile(ctx, &pbv1.DeleteFileRequest{
+		FileId: fileID,
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// 6. Delete file
 	deleteResp, err := client.DeleteFile(ctx, &pbv1.DeleteFileRequest{
 		FileId: fileID,
-		UserId: testUserID,
 	})
 	require.NoError(t, err)
 	assert.True(t, deleteResp.Success)
@@ -181,7 +264,6 @@ func TestUploadSizeLimit(t *testing.T) {
 				Filename:    "huge.bin",
 				ContentType: "application/octet-stream",
 				Size:        600 * 1024 * 1024, // 600MB > 512MB limit
-				UserId:      testUserID,
 			},
 		},
 	})
@@ -211,7 +293,6 @@ func TestInvalidContentType(t *testing.T) {
 				Filename:    "fake.txt",
 				ContentType: "text/plain",
 				Size:        int64(len(testContent)),
-				UserId:      testUserID,
 			},
 		},
 	})
@@ -229,32 +310,1493 @@ func TestInvalidContentType(t *testing.T) {
 	assert.Contains(t, err.Error(), "content type mismatch")
 }
 
-// Benchmark upload performance
-func BenchmarkUpload(b *testing.B) {
-	client, cleanup := setupTestServer(&testing.T{})
+func TestResumableUpload(t *testing.T) {
+	client, cleanup := setupTestServer(t)
 	defer cleanup()
 
 	ctx := context.Background()
-	testContent := make([]byte, 1024*1024) // 1MB
+	testContent := []byte("first half|second half")
+	half := int64(11)
+	uploadID := uuid.New().String()
 
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		stream, _ := client.UploadFile(ctx)
-		stream.Send(&pbv1.UploadFileRequest{
+	send := func(offset int64, chunk []byte) (*pbv1.UploadFileResponse, error) {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		err = stream.Send(&pbv1.UploadFileRequest{
 			Data: &pbv1.UploadFileRequest_Metadata{
 				Metadata: &pbv1.FileMetadata{
-					Filename:    "bench.bin",
-					ContentType: "application/octet-stream",
+					Filename:     "resume.txt",
+					ContentType:  "text/plain",
+					Size:         int64(len(testContent)),
+					UploadId:     uploadID,
+					ResumeOffset: offset,
+				},
+			},
+		})
+		require.NoError(t, err)
+		err = stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: chunk},
+		})
+		if err != io.EOF {
+			// io.EOF means the server already answered; CloseAndRecv has the status
+			require.NoError(t, err)
+		}
+		return stream.CloseAndRecv()
+	}
+
+	// 1. Send only the first part; the server keeps it
+	_, err := send(0, testContent[:half])
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// 2. Server reports the committed offset
+	st, err := client.GetUploadStatus(ctx, &pbv1.GetUploadStatusRequest{
+		UploadId: uploadID,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, half, st.CommittedOffset)
+
+	// 3. Resuming from the wrong offset is rejected
+	_, err = send(half-1, testContent[half-1:])
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// 4. Resume from the committed offset
+	resp, err := send(half, testContent[half:])
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(testContent)), resp.Size)
+
+	// Session is gone once the file is committed
+	_, err = client.GetUploadStatus(ctx, &pbv1.GetUploadStatusRequest{
+		UploadId: uploadID,
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestResumableUploadChecksContentType(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	// A PNG declared as text, sent in pieces too short to sniff on their own
+	testContent := []byte("\x89PNG\r\n\x1a\n and then some")
+	half := int64(8)
+	uploadID := uuid.New().String()
+
+	send := func(offset int64, chunk []byte) error {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:     "fake.txt",
+					ContentType:  "text/plain",
+					Size:         int64(len(testContent)),
+					UploadId:     uploadID,
+					ResumeOffset: offset,
+				},
+			},
+		}))
+		err = stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: chunk},
+		})
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		_, err = stream.CloseAndRecv()
+		return err
+	}
+
+	err := send(0, testContent[:half])
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	err = send(half, testContent[half:])
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "content type mismatch")
+}
+
+func TestResumableUploadStripsMetadata(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	// A JPEG with a comment, resumed partway through so it is read back
+	// from the staged part once complete
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	content := append([]byte{0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x08}, "secret"...)
+	content = append(content, buf.Bytes()[2:]...)
+	half := int64(64)
+	uploadID := uuid.New().String()
+
+	send := func(offset int64, chunk []byte) (*pbv1.UploadFileResponse, error) {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:       "photo.jpg",
+					ContentType:    "image/jpeg",
+					Size:           int64(len(content)),
+					UploadId:       uploadID,
+					ResumeOffset:   offset,
+					MetadataPolicy: pbv1.MetadataPolicy_METADATA_POLICY_STRIP_ALL,
+				},
+			},
+		}))
+		err = stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: chunk},
+		})
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		return stream.CloseAndRecv()
+	}
+
+	_, err := send(0, content[:half])
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	resp, err := send(half, content[half:])
+	require.NoError(t, err)
+	require.NotNil(t, resp.Sanitization)
+	assert.Equal(t, []string{"comment"}, resp.Sanitization.Removed)
+	assert.Equal(t, int64(len(content)), resp.Sanitization.OriginalSize)
+
+	download, err := client.DownloadFile(ctx, &pbv1.DownloadFileRequest{FileId: resp.FileId})
+	require.NoError(t, err)
+	var stored []byte
+	for {
+		msg, err := download.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		stored = append(stored, msg.GetChunk()...)
+	}
+	assert.NotContains(t, string(stored), "secret")
+	assert.Equal(t, resp.Size, int64(len(stored)))
+}
+
+func TestResumableUploadIsExclusive(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testContent := []byte("first half|second half")
+	half := int64(11)
+	uploadID := uuid.New().String()
+
+	open := func(offset int64, chunk []byte) pbv1.FileService_UploadFileClient {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:     "exclusive.txt",
+					ContentType:  "text/plain",
+					Size:         int64(len(testContent)),
+					UploadId:     uploadID,
+					ResumeOffset: offset,
+				},
+			},
+		}))
+		err = stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: chunk},
+		})
+		if err != io.EOF {
+			require.NoError(t, err)
+		}
+		return stream
+	}
+
+	// The first stream writes half and stays open
+	first := open(0, testContent[:half])
+	require.Eventually(t, func() bool {
+		st, err := client.GetUploadStatus(ctx, &pbv1.GetUploadStatusRequest{UploadId: uploadID})
+		return err == nil && st.CommittedOffset == half
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// A second stream can't resume the session while the first holds it
+	_, err := open(half, testContent[half:]).CloseAndRecv()
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	// Once the first stream ends, resuming works
+	_, err = first.CloseAndRecv()
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+	resp, err := open(half, testContent[half:]).CloseAndRecv()
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(testContent)), resp.Size)
+}
+
+func TestUploadChecksum(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testContent := []byte("checksummed content")
+	sum := sha256.Sum256(testContent)
+
+	upload := func(expected string) (*pbv1.UploadFileResponse, error) {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		err = stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:    "sum.txt",
+					ContentType: "text/plain",
 					Size:        int64(len(testContent)),
-					UserId:      testUserID,
+					Sha256:      expected,
 				},
 			},
 		})
+		require.NoError(t, err)
+		err = stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: testContent},
+		})
+		require.NoError(t, err)
+		return stream.CloseAndRecv()
+	}
+
+	// Matching digest is accepted and echoed back
+	resp, err := upload(hex.EncodeToString(sum[:]))
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), resp.Sha256)
+
+	metadata, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: resp.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, resp.Sha256, metadata.Sha256)
+
+	// Wrong digest is rejected
+	wrong := sha256.Sum256([]byte("something else"))
+	_, err = upload(hex.EncodeToString(wrong[:]))
+	assert.Equal(t, codes.DataLoss, status.Code(err))
+}
+
+func TestRangeDownload(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	testContent := []byte("0123456789abcdef")
+
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{
+				Filename:    "range.txt",
+				ContentType: "text/plain",
+				Size:        int64(len(testContent)),
+			},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: testContent},
+	}))
+	uploadResp, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	download := func(offset, length int64) (*pbv1.FileInfo, []byte, error) {
+		downloadStream, err := client.DownloadFile(ctx, &pbv1.DownloadFileRequest{
+			FileId: uploadResp.FileId,
+			Offset: offset,
+			Length: length,
+		})
+		require.NoError(t, err)
+		first, err := downloadStream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		var data []byte
+		for {
+			msg, err := downloadStream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			data = append(data, msg.GetChunk()...)
+		}
+		return first.GetInfo(), data, nil
+	}
+
+	// Bounded range
+	info, data, err := download(10, 4)
+	require.NoError(t, err)
+	assert.Equal(t, "abcd", string(data))
+	assert.Equal(t, int64(10), info.RangeStart)
+	assert.Equal(t, int64(4), info.RangeLength)
+	assert.Equal(t, int64(len(testContent)), info.Size)
+
+	// Open-ended range (resume)
+	_, data, err = download(12, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "cdef", string(data))
+
+	// Past the end
+	_, _, err = download(100, 0)
+	assert.Equal(t, codes.OutOfRange, status.Code(err))
+}
+
+// Benchmark upload performance
+func TestAPIKeyLifecycle(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+
+	adminConn := dialTestServer(t, signTestToken(t, "admin-user", "admin"))
+	defer adminConn.Close()
+	admin := pbv1.NewAdminServiceClient(adminConn)
+
+	// Default user tokens can't reach the admin API
+	userConn := dialTestServer(t, signTestToken(t, testUserID, ""))
+	defer userConn.Close()
+	_, err := pbv1.NewAdminServiceClient(userConn).ListAPIKeys(ctx, &pbv1.ListAPIKeysRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	created, err := admin.CreateAPIKey(ctx, &pbv1.CreateAPIKeyRequest{
+		OwnerId: testUserID,
+		Name:    "read-only",
+		Scopes:  []pbv1.ApiKeyScope{pbv1.ApiKeyScope_API_KEY_SCOPE_READ},
+	})
+	require.NoError(t, err)
+	assert.Contains(t, created.Secret, created.Key.Prefix)
+
+	// A read-only key can list but not upload
+	keyConn := dialTestServer(t, apiKey(created.Secret))
+	defer keyConn.Close()
+	files := pbv1.NewFileServiceClient(keyConn)
+
+	_, err = files.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 10})
+	require.NoError(t, err)
+
+	stream, err := files.UploadFile(ctx)
+	require.NoError(t, err)
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// Use is recorded
+	listed, err := admin.ListAPIKeys(ctx, &pbv1.ListAPIKeysRequest{OwnerId: testUserID})
+	require.NoError(t, err)
+	var found *pbv1.ApiKey
+	for _, key := range listed.Keys {
+		if key.KeyId == created.Key.KeyId {
+			found = key
+		}
+	}
+	require.NotNil(t, found)
+	assert.NotNil(t, found.LastUsedAt)
+
+	// Rotation without a grace period retires the old key immediately
+	rotated, err := admin.RotateAPIKey(ctx, &pbv1.RotateAPIKeyRequest{KeyId: created.Key.KeyId})
+	require.NoError(t, err)
+	assert.Equal(t, created.Key.Scopes, rotated.Key.Scopes)
+
+	_, err = files.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 10})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	rotatedConn := dialTestServer(t, apiKey(rotated.Secret))
+	defer rotatedConn.Close()
+	rotatedFiles := pbv1.NewFileServiceClient(rotatedConn)
+	_, err = rotatedFiles.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 10})
+	require.NoError(t, err)
+
+	// Revocation takes effect on the next call
+	_, err = admin.RevokeAPIKey(ctx, &pbv1.RevokeAPIKeyRequest{KeyId: rotated.Key.KeyId})
+	require.NoError(t, err)
+	_, err = rotatedFiles.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 10})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestQuota(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	userID := uuid.New().String()
+	content := []byte("quota content")
+
+	adminConn := dialTestServer(t, signTestToken(t, "admin-user", "admin"))
+	defer adminConn.Close()
+	maxFiles := int64(1)
+	_, err := pbv1.NewAdminServiceClient(adminConn).SetQuota(ctx, &pbv1.SetQuotaRequest{
+		Scope:    pbv1.QuotaScope_QUOTA_SCOPE_USER,
+		OwnerId:  userID,
+		MaxFiles: &maxFiles,
+	})
+	require.NoError(t, err)
+
+	userConn := dialTestServer(t, signTestToken(t, userID, ""))
+	defer userConn.Close()
+	client := pbv1.NewFileServiceClient(userConn)
+
+	upload := func() (*pbv1.UploadFileResponse, error) {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		// The server may reject after the metadata; the error surfaces from CloseAndRecv
 		stream.Send(&pbv1.UploadFileRequest{
-			Data: &pbv1.UploadFileRequest_Chunk{
-				Chunk: testContent,
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:    "quota.txt",
+					ContentType: "text/plain",
+					Size:        int64(len(content)),
+				},
 			},
 		})
-		stream.CloseAndRecv()
+		stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: content},
+		})
+		return stream.CloseAndRecv()
 	}
+
+	first, err := upload()
+	require.NoError(t, err)
+
+	usage, err := client.GetUsage(ctx, &pbv1.GetUsageRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(content)), usage.User.BytesUsed)
+	assert.Equal(t, int64(1), usage.User.FileCount)
+	assert.Equal(t, int64(1), usage.User.MaxFiles)
+	assert.Nil(t, usage.Tenant)
+
+	_, err = upload()
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Deleting frees the slot
+	_, err = client.DeleteFile(ctx, &pbv1.DeleteFileRequest{FileId: first.FileId})
+	require.NoError(t, err)
+
+	usage, err = client.GetUsage(ctx, &pbv1.GetUsageRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), usage.User.BytesUsed)
+	assert.Equal(t, int64(0), usage.User.FileCount)
+
+	_, err = upload()
+	assert.NoError(t, err)
+}
+
+func TestWatchFile(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Stand in for the worker so the test controls job transitions
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	content := []byte("watch me")
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{
+				Filename:    "watch.txt",
+				ContentType: "text/plain",
+				Size:        int64(len(content)),
+			},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: content},
+	}))
+	uploaded, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	watch, err := client.WatchFile(ctx, &pbv1.WatchFileRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+
+	// Current state of every task first, by job type
+	jobIDs := map[string]int64{}
+	for range testJobs {
+		ev, err := watch.Recv()
+		require.NoError(t, err)
+		assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING, ev.ProcessingStatus)
+		jobIDs[ev.JobType] = ev.JobId
+	}
+	require.Len(t, jobIDs, 2)
+
+	require.NoError(t, db.UpdateJobStatus(ctx, jobIDs["alpha"], "", "processing", ""))
+	ev, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "alpha", ev.JobType)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING, ev.ProcessingStatus)
+
+	require.NoError(t, db.CompleteJob(ctx, jobIDs["alpha"], "", &database.JobResult{
+		Width:      10,
+		Height:     20,
+		Attributes: map[string]string{"key": "value"},
+		Artifacts: []database.Artifact{
+			{Name: "square", Kind: database.ArtifactThumbnail, Path: "square.webp", ContentType: "image/webp"},
+			{Name: "small", Path: "small.jpg", ContentType: "image/jpeg"}, // Stored before presets
+		},
+	}))
+	ev, err = watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED, ev.ProcessingStatus)
+	assert.Equal(t, int32(20), ev.ProcessingResult.OriginalHeight)
+	assert.Equal(t, "value", ev.ProcessingResult.Attributes["key"])
+	require.Len(t, ev.ProcessingResult.Artifacts, 2)
+	assert.Equal(t, "small.jpg", ev.ProcessingResult.Artifacts[1].Path)
+	assert.Equal(t, "square.webp", ev.ProcessingResult.Thumbnails["square"].GetPath())
+	assert.Equal(t, "small.jpg", ev.ProcessingResult.Thumbnails["small"].GetPath())
+	assert.Equal(t, "small.jpg", ev.ProcessingResult.ThumbnailSmall, "deprecated field still filled")
+
+	// One task done, one waiting
+	meta, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING, meta.ProcessingStatus)
+	require.Len(t, meta.Tasks, 2)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED, meta.Tasks[0].Status)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING, meta.Tasks[1].Status)
+
+	require.NoError(t, db.FailJobAttempt(ctx, jobIDs["beta"], "", "boom", nil))
+	ev, err = watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, "beta", ev.JobType)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED, ev.ProcessingStatus)
+
+	// The stream ends once every task is finished
+	_, err = watch.Recv()
+	assert.Equal(t, io.EOF, err)
+
+	meta, err = client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED, meta.ProcessingStatus)
+	assert.Equal(t, "beta: boom", meta.ProcessingResult.ErrorMessage)
+	assert.Equal(t, "value", meta.ProcessingResult.Attributes["key"], "completed task results are kept")
+}
+
+func TestDeadLetterJobs(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	content := []byte("dead letter")
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{
+				Filename:    "dead.txt",
+				ContentType: "text/plain",
+				Size:        int64(len(content)),
+			},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: content},
+	}))
+	uploaded, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	jobs, err := db.ListJobsByFileID(ctx, uploaded.FileId)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	job := jobs[0]
+	for _, j := range jobs {
+		require.NoError(t, db.FailJobAttempt(ctx, j.ID, "", "corrupt input", nil))
+	}
+
+	adminConn := dialTestServer(t, signTestToken(t, "admin-user", "admin"))
+	defer adminConn.Close()
+	admin := pbv1.NewAdminServiceClient(adminConn)
+
+	// Dead-lettered files report FAILED to their owner
+	meta, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED, meta.ProcessingStatus)
+
+	got, err := admin.GetProcessingJob(ctx, &pbv1.GetProcessingJobRequest{JobId: job.ID})
+	require.NoError(t, err)
+	assert.Equal(t, "dead_letter", got.Job.Status)
+	assert.Equal(t, "corrupt input", got.Job.LastError)
+	require.Len(t, got.Job.Attempts, 1)
+	assert.Equal(t, "corrupt input", got.Job.Attempts[0].ErrorMessage)
+
+	list, err := admin.ListDeadLetterJobs(ctx, &pbv1.ListDeadLetterJobsRequest{PageSize: 100})
+	require.NoError(t, err)
+	found := false
+	for _, j := range list.Jobs {
+		found = found || j.JobId == job.ID
+	}
+	assert.True(t, found, "job missing from dead letter list")
+
+	_, err = admin.ListDeadLetterJobs(ctx, &pbv1.ListDeadLetterJobsRequest{PageSize: 10, PageToken: "bogus"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = admin.RequeueJob(ctx, &pbv1.RequeueJobRequest{JobId: job.ID})
+	require.NoError(t, err)
+	_, err = admin.RequeueJob(ctx, &pbv1.RequeueJobRequest{JobId: job.ID})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	meta, err = client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING, meta.Tasks[0].Status)
+}
+
+func BenchmarkUpload(b *testing.B) {
+	client, cleanup := setupTestServer(&testing.T{})
+	defer cleanup()
+
+	ctx := context.Background()
+	testContent := make([]byte, 1024*1024) // 1MB
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		stream, _ := client.UploadFile(ctx)
+		stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:    "bench.bin",
+					ContentType: "application/octet-stream",
+					Size:        int64(len(testContent)),
+				},
+			},
+		})
+		stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{
+				Chunk: testContent,
+			},
+		})
+		stream.CloseAndRecv()
+	}
+}
+
+func TestUploadStripsMetadata(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	tenantID := uuid.New().String()
+
+	// A JPEG with a comment and data appended after the image
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil))
+	content := append([]byte{0xFF, 0xD8, 0xFF, 0xFE, 0x00, 0x08}, "secret"...)
+	content = append(content, buf.Bytes()[2:]...)
+	content = append(content, "appended"...)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		TenantID: tenantID,
+	})
+	signed, err := token.SignedString(testJWTSecret)
+	require.NoError(t, err)
+	conn := dialTestServer(t, bearerToken(signed))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	upload := func(policy pbv1.MetadataPolicy) *pbv1.UploadFileResponse {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{
+					Filename:       "photo.jpg",
+					ContentType:    "image/jpeg",
+					Size:           int64(len(content)),
+					MetadataPolicy: policy,
+				},
+			},
+		}))
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: content},
+		}))
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		return resp
+	}
+
+	download := func(fileID string) []byte {
+		stream, err := client.DownloadFile(ctx, &pbv1.DownloadFileRequest{FileId: fileID})
+		require.NoError(t, err)
+		var data []byte
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return data
+			}
+			require.NoError(t, err)
+			data = append(data, msg.GetChunk()...)
+		}
+	}
+
+	// Without a policy the file is stored as sent
+	resp := upload(pbv1.MetadataPolicy_METADATA_POLICY_UNSPECIFIED)
+	assert.Nil(t, resp.Sanitization)
+	assert.Equal(t, content, download(resp.FileId))
+
+	// The upload asks for everything to go
+	resp = upload(pbv1.MetadataPolicy_METADATA_POLICY_STRIP_ALL)
+	require.NotNil(t, resp.Sanitization)
+	assert.Equal(t, []string{"comment", "trailer"}, resp.Sanitization.Removed)
+	assert.Equal(t, int64(len(content)), resp.Sanitization.OriginalSize)
+	stored := download(resp.FileId)
+	assert.NotContains(t, string(stored), "secret")
+	assert.Equal(t, int64(len(stored)), resp.Size)
+	sum := sha256.Sum256(stored)
+	assert.Equal(t, hex.EncodeToString(sum[:]), resp.Sha256)
+
+	metadata, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: resp.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, pbv1.MetadataPolicy_METADATA_POLICY_STRIP_ALL, metadata.Sanitization.Policy)
+	assert.Equal(t, resp.Sanitization.Removed, metadata.Sanitization.Removed)
+
+	// The tenant's policy can't be opted out of
+	adminConn := dialTestServer(t, signTestToken(t, "admin-user", "admin"))
+	defer adminConn.Close()
+	_, err = pbv1.NewAdminServiceClient(adminConn).SetTenantMetadataPolicy(ctx, &pbv1.SetTenantMetadataPolicyRequest{
+		TenantId: tenantID,
+		Policy:   pbv1.MetadataPolicy_METADATA_POLICY_STRIP_LOCATION,
+	})
+	require.NoError(t, err)
+
+	resp = upload(pbv1.MetadataPolicy_METADATA_POLICY_KEEP)
+	require.NotNil(t, resp.Sanitization)
+	assert.Equal(t, pbv1.MetadataPolicy_METADATA_POLICY_STRIP_LOCATION, resp.Sanitization.Policy)
+	assert.Equal(t, []string{"trailer"}, resp.Sanitization.Removed)
+	assert.Contains(t, string(download(resp.FileId)), "secret")
+}
+
+func TestGetImageVariant(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	tenantID := uuid.New().String()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, middleware.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   uuid.New().String(),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		TenantID: tenantID,
+	})
+	signed, err := token.SignedString(testJWTSecret)
+	require.NoError(t, err)
+	conn := dialTestServer(t, bearerToken(signed))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 400, 200)), nil))
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{Filename: "wide.jpg", ContentType: "image/jpeg", Size: int64(buf.Len())},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: buf.Bytes()},
+	}))
+	uploaded, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	variant := func(req *pbv1.GetImageVariantRequest) (*pbv1.VariantInfo, image.Image, error) {
+		req.FileId = uploaded.FileId
+		stream, err := client.GetImageVariant(ctx, req)
+		require.NoError(t, err)
+		first, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		var data []byte
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			data = append(data, msg.GetChunk()...)
+		}
+		assert.Equal(t, first.GetInfo().Size, int64(len(data)))
+		img, _, err := image.Decode(bytes.NewReader(data))
+		require.NoError(t, err)
+		return first.GetInfo(), img, nil
+	}
+
+	// Crop the left half, turn it on its side and shrink it
+	req := &pbv1.GetImageVariantRequest{
+		Width:  50,
+		Crop:   &pbv1.CropRect{Width: 200, Height: 200},
+		Rotate: 90,
+		Format: pbv1.ImageFormat_IMAGE_FORMAT_PNG,
+	}
+	info, img, err := variant(req)
+	require.NoError(t, err)
+	assert.False(t, info.Cached)
+	assert.Equal(t, "image/png", info.ContentType)
+	assert.Equal(t, image.Rect(0, 0, 50, 50), img.Bounds())
+	assert.Equal(t, int32(50), info.Width)
+
+	info, _, err = variant(req)
+	require.NoError(t, err)
+	assert.True(t, info.Cached)
+
+	// Crops must lie within the image
+	_, _, err = variant(&pbv1.GetImageVariantRequest{Crop: &pbv1.CropRect{X: 300, Width: 200, Height: 10}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// The tenant's limit caps requests
+	adminConn := dialTestServer(t, signTestToken(t, "admin-user", "admin"))
+	defer adminConn.Close()
+	_, err = pbv1.NewAdminServiceClient(adminConn).SetTenantVariantLimits(ctx, &pbv1.SetTenantVariantLimitsRequest{
+		TenantId:  tenantID,
+		MaxWidth:  100,
+		MaxHeight: 100,
+	})
+	require.NoError(t, err)
+
+	_, _, err = variant(&pbv1.GetImageVariantRequest{Width: 300})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	info, img, err = variant(&pbv1.GetImageVariantRequest{Format: pbv1.ImageFormat_IMAGE_FORMAT_WEBP})
+	require.NoError(t, err)
+	assert.Equal(t, "image/webp", info.ContentType)
+	assert.Equal(t, image.Rect(0, 0, 100, 50), img.Bounds())
+
+	// Cached variants count towards usage until the file is deleted
+	usage, err := client.GetUsage(ctx, &pbv1.GetUsageRequest{})
+	require.NoError(t, err)
+	assert.Greater(t, usage.User.BytesUsed, int64(buf.Len()))
+	assert.Equal(t, int64(1), usage.User.FileCount)
+
+	_, err = client.DeleteFile(ctx, &pbv1.DeleteFileRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	usage, err = client.GetUsage(ctx, &pbv1.GetUsageRequest{})
+	require.NoError(t, err)
+	assert.Zero(t, usage.User.BytesUsed)
+	assert.Zero(t, usage.User.FileCount)
+}
+
+func TestDownloadArtifact(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	content := []byte("pretend this is a thumbnail")
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{
+				Filename:    "artifact.txt",
+				ContentType: "text/plain",
+				Size:        int64(len(content)),
+			},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: content},
+	}))
+	uploaded, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	download := func(c pbv1.FileServiceClient, req *pbv1.DownloadArtifactRequest) (*pbv1.ArtifactInfo, []byte, error) {
+		stream, err := c.DownloadArtifact(ctx, req)
+		require.NoError(t, err)
+		first, err := stream.Recv()
+		if err != nil {
+			return nil, nil, err
+		}
+		var data []byte
+		for {
+			msg, err := stream.Recv()
+			if err == io.EOF {
+				return first.GetInfo(), data, nil
+			}
+			require.NoError(t, err)
+			data = append(data, msg.GetChunk()...)
+		}
+	}
+
+	// Nothing is ready while the jobs are pending
+	_, _, err = download(client, &pbv1.DownloadArtifactRequest{FileId: uploaded.FileId, Name: "small"})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	// The artifact points at the upload itself, which is in storage
+	jobs, err := db.ListJobsByFileID(ctx, uploaded.FileId)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	for i, job := range jobs {
+		result := &database.JobResult{}
+		if i == 0 {
+			result.Artifacts = []database.Artifact{{Name: "small", Path: uploaded.FileId, ContentType: "text/plain"}}
+		}
+		require.NoError(t, db.CompleteJob(ctx, job.ID, "", result))
+	}
+
+	info, data, err := download(client, &pbv1.DownloadArtifactRequest{FileId: uploaded.FileId, Name: "small"})
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+	assert.Equal(t, jobs[0].JobType, info.JobType)
+	assert.Equal(t, "small", info.Artifact.Name)
+	assert.Equal(t, int64(len(content)), info.Size)
+
+	_, data, err = download(client, &pbv1.DownloadArtifactRequest{FileId: uploaded.FileId, Name: "small", Offset: 8, Length: 4})
+	require.NoError(t, err)
+	assert.Equal(t, content[8:12], data)
+
+	_, _, err = download(client, &pbv1.DownloadArtifactRequest{FileId: uploaded.FileId, Name: "large"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// Other users can't tell the file exists
+	otherConn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer otherConn.Close()
+	_, _, err = download(pbv1.NewFileServiceClient(otherConn), &pbv1.DownloadArtifactRequest{FileId: uploaded.FileId, Name: "small"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestFindSimilarFiles(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	// A fresh user, so files from other tests don't match
+	conn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	upload := func(name string) string {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{Filename: name, ContentType: "text/plain", Size: 4},
+			},
+		}))
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: []byte(name[:4])},
+		}))
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		return resp.FileId
+	}
+	// hash completes the file's jobs, the first one with the given hashes
+	hash := func(fileID string, hashes *database.ImageHashes) {
+		jobs, err := db.ListJobsByFileID(ctx, fileID)
+		require.NoError(t, err)
+		for i, job := range jobs {
+			result := &database.JobResult{}
+			if i == 0 {
+				result.Hashes = hashes
+			}
+			require.NoError(t, db.CompleteJob(ctx, job.ID, "", result))
+		}
+	}
+
+	original := upload("original.jpg")
+	_, err = client.FindSimilarFiles(ctx, &pbv1.FindSimilarFilesRequest{FileId: original})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	hash(original, &database.ImageHashes{PHash: "00000000000000ff", DHash: "ffffffffffffffff", BlurHash: "LEHV6nWB2yk8pyo0adR*.7kCMdnj"})
+	resized := upload("resized.jpg")
+	hash(resized, &database.ImageHashes{PHash: "000000000000000f", DHash: "fffffffffffffff0", BlurHash: "LEHV6nWB2yk8pyo0adR*.7kCMdnk"})
+	other := upload("other.jpg")
+	hash(other, &database.ImageHashes{PHash: "ffffffffffff0000", DHash: "0000000000000000", BlurHash: "L00000fQfQfQfQfQfQfQfQfQfQfQ"})
+	upload("pending.jpg")
+
+	resp, err := client.FindSimilarFiles(ctx, &pbv1.FindSimilarFilesRequest{FileId: original})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 1)
+	assert.Equal(t, resized, resp.Files[0].File.FileId)
+	assert.Equal(t, int32(4), resp.Files[0].Distance)
+	assert.Equal(t, "LEHV6nWB2yk8pyo0adR*.7kCMdnk", resp.Files[0].File.Blurhash)
+
+	maxDistance := int32(64)
+	resp, err = client.FindSimilarFiles(ctx, &pbv1.FindSimilarFilesRequest{FileId: original, MaxDistance: &maxDistance})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 2)
+	assert.Equal(t, []string{resized, other}, []string{resp.Files[0].File.FileId, resp.Files[1].File.FileId})
+	assert.Equal(t, int32(56), resp.Files[1].Distance)
+
+	resp, err = client.FindSimilarFiles(ctx, &pbv1.FindSimilarFilesRequest{
+		FileId: original, Hash: pbv1.PerceptualHash_PERCEPTUAL_HASH_DHASH,
+	})
+	require.NoError(t, err)
+	require.Len(t, resp.Files, 1)
+	assert.Equal(t, int32(4), resp.Files[0].Distance)
+
+	// ListFiles carries the placeholders
+	list, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 10})
+	require.NoError(t, err)
+	blurhashes := make(map[string]string)
+	for _, f := range list.Files {
+		blurhashes[f.FileId] = f.Blurhash
+	}
+	assert.Equal(t, "LEHV6nWB2yk8pyo0adR*.7kCMdnj", blurhashes[original])
+	assert.Len(t, blurhashes, 4)
+	assert.Contains(t, blurhashes, other)
+
+	// Other users can't tell the file exists
+	otherConn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer otherConn.Close()
+	_, err = pbv1.NewFileServiceClient(otherConn).FindSimilarFiles(ctx, &pbv1.FindSimilarFilesRequest{FileId: original})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestGetFileMetadataDocument(t *testing.T) {
+	client, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{Filename: "report.txt", ContentType: "text/plain", Size: 4},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: []byte("%PDF")},
+	}))
+	uploaded, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	jobs, err := db.ListJobsByFileID(ctx, uploaded.FileId)
+	require.NoError(t, err)
+	for i, job := range jobs {
+		result := &database.JobResult{}
+		if i == 0 {
+			result.Document = &database.DocumentMetadata{
+				PageCount: 12,
+				Title:     "Quarterly report",
+				CreatedAt: &created,
+				Pages:     []database.DocumentPage{{Number: 1, Width: 612, Height: 792, Text: "Hello"}},
+			}
+			result.Artifacts = []database.Artifact{{Name: "preview", Kind: database.ArtifactPreview, Path: "preview.png"}}
+		}
+		require.NoError(t, db.CompleteJob(ctx, job.ID, "", result))
+	}
+
+	meta, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	doc := meta.ProcessingResult.Document
+	require.NotNil(t, doc)
+	assert.Equal(t, int32(12), doc.PageCount)
+	assert.Equal(t, "Quarterly report", doc.Title)
+	assert.True(t, created.Equal(doc.CreatedAt.AsTime()))
+	require.Len(t, doc.Pages, 1)
+	assert.Equal(t, "Hello", doc.Pages[0].Text)
+	assert.Equal(t, 612.0, doc.Pages[0].WidthPt)
+	assert.Empty(t, meta.ProcessingResult.Thumbnails, "previews aren't thumbnails")
+	require.Len(t, meta.ProcessingResult.Artifacts, 1)
+	assert.Equal(t, "preview", meta.ProcessingResult.Artifacts[0].Name)
+}
+
+func TestSearchFiles(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	// A fresh user, so files from other tests don't match
+	conn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	upload := func(metadata *pbv1.FileMetadata) (string, error) {
+		metadata.ContentType, metadata.Size = "text/plain", 4
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{Metadata: metadata},
+		}))
+		stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: []byte("text")},
+		})
+		resp, err := stream.CloseAndRecv()
+		if err != nil {
+			return "", err
+		}
+		return resp.FileId, nil
+	}
+	// extract completes the file's jobs, the first one with the given text
+	extract := func(fileID string, result *database.JobResult) {
+		jobs, err := db.ListJobsByFileID(ctx, fileID)
+		require.NoError(t, err)
+		for i, job := range jobs {
+			if i > 0 {
+				result = &database.JobResult{}
+			}
+			require.NoError(t, db.CompleteJob(ctx, job.ID, "", result))
+		}
+	}
+	search := func(req *pbv1.SearchFilesRequest) *pbv1.SearchFilesResponse {
+		resp, err := client.SearchFiles(ctx, req)
+		require.NoError(t, err)
+		return resp
+	}
+	ids := func(resp *pbv1.SearchFilesResponse) []string {
+		var ids []string
+		for _, r := range resp.Results {
+			ids = append(ids, r.File.FileId)
+		}
+		return ids
+	}
+
+	report, err := upload(&pbv1.FileMetadata{
+		Filename:       "q3_report.txt",
+		Tags:           []string{"Finance", " finance", "2024"},
+		CustomMetadata: map[string]string{"project": "apollo"},
+	})
+	require.NoError(t, err)
+	notes, err := upload(&pbv1.FileMetadata{Filename: "notes.txt"})
+	require.NoError(t, err)
+	extract(notes, &database.JobResult{Text: "Meeting notes: the quarterly reports are late again."})
+	manual, err := upload(&pbv1.FileMetadata{Filename: "manual.pdf"})
+	require.NoError(t, err)
+	extract(manual, &database.JobResult{Document: &database.DocumentMetadata{
+		PageCount: 2,
+		Pages:     []database.DocumentPage{{Number: 1, Text: "Installing the apollo <b>module</b>"}, {Number: 2, Text: "Troubleshooting"}},
+	}})
+
+	// Labels are normalized on upload
+	meta, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: report})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"finance", "2024"}, meta.Tags)
+	assert.Equal(t, map[string]string{"project": "apollo"}, meta.CustomMetadata)
+	_, err = upload(&pbv1.FileMetadata{Filename: "bad.txt", Tags: []string{" "}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Names rank above content; "report" matches "q3_report" and "reports"
+	resp := search(&pbv1.SearchFilesRequest{Query: "report"})
+	assert.Equal(t, []string{report, notes}, ids(resp))
+	assert.Equal(t, []string{"finance", "2024"}, resp.Results[0].File.Tags)
+	assert.Greater(t, resp.Results[0].Rank, resp.Results[1].Rank)
+	var snippet string
+	var matches []string
+	for _, part := range resp.Results[1].Snippet {
+		snippet += part.Text
+		if part.Match {
+			matches = append(matches, part.Text)
+		}
+	}
+	assert.Contains(t, snippet, "Meeting notes: the quarterly reports are late again.")
+	assert.Equal(t, []string{"reports"}, matches)
+
+	// Custom metadata and PDF text; markup in the text is just text
+	resp = search(&pbv1.SearchFilesRequest{Query: "apollo"})
+	assert.ElementsMatch(t, []string{report, manual}, ids(resp))
+	resp = search(&pbv1.SearchFilesRequest{Query: "troubleshooting -apollo"})
+	assert.Empty(t, resp.Results)
+	resp = search(&pbv1.SearchFilesRequest{Query: `"apollo module"`})
+	require.Equal(t, []string{manual}, ids(resp))
+	snippet = ""
+	for _, part := range resp.Results[0].Snippet {
+		snippet += part.Text
+	}
+	assert.Contains(t, snippet, "<b>module</b>")
+
+	// Tag filter
+	resp = search(&pbv1.SearchFilesRequest{Query: "apollo", Tags: []string{"FINANCE"}})
+	assert.Equal(t, []string{report}, ids(resp))
+
+	// Paging works like ListFiles
+	resp = search(&pbv1.SearchFilesRequest{Query: "report OR apollo", PageSize: 2})
+	require.Len(t, resp.Results, 2)
+	require.NotEmpty(t, resp.NextPageToken)
+	next := search(&pbv1.SearchFilesRequest{Query: "report OR apollo", PageSize: 2, PageToken: resp.NextPageToken})
+	require.Len(t, next.Results, 1)
+	assert.Empty(t, next.NextPageToken)
+	assert.NotContains(t, ids(resp), next.Results[0].File.FileId)
+
+	// Page tokens are signed and tied to the query and tags
+	for _, req := range []*pbv1.SearchFilesRequest{
+		{Query: "report OR apollo", PageSize: 2, PageToken: "2"},
+		{Query: "report", PageSize: 2, PageToken: resp.NextPageToken},
+		{Query: "report OR apollo", Tags: []string{"finance"}, PageSize: 2, PageToken: resp.NextPageToken},
+	} {
+		_, err = client.SearchFiles(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), req.PageToken)
+	}
+
+	_, err = client.SearchFiles(ctx, &pbv1.SearchFilesRequest{Query: " "})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// Other users' files never match
+	otherConn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer otherConn.Close()
+	other, err := pbv1.NewFileServiceClient(otherConn).SearchFiles(ctx, &pbv1.SearchFilesRequest{Query: "apollo"})
+	require.NoError(t, err)
+	assert.Empty(t, other.Results)
+}
+
+func TestListFilesFiltersAndSorting(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	// A fresh user, so files from other tests don't match
+	conn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	upload := func(name, contentType, data string) string {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{Filename: name, ContentType: contentType, Size: int64(len(data))},
+			},
+		}))
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: []byte(data)},
+		}))
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		return resp.FileId
+	}
+	// setStatuses sets the status of each of the file's jobs, in order
+	setStatuses := func(fileID string, statuses ...string) {
+		jobs, err := db.ListJobsByFileID(ctx, fileID)
+		require.NoError(t, err)
+		require.Len(t, jobs, len(statuses))
+		for i, job := range jobs {
+			require.NoError(t, db.UpdateJobStatus(ctx, job.ID, "", statuses[i], ""))
+		}
+	}
+	list := func(req *pbv1.ListFilesRequest) []string {
+		req.PageSize = 100
+		resp, err := client.ListFiles(ctx, req)
+		require.NoError(t, err)
+		var names []string
+		for _, f := range resp.Files {
+			names = append(names, f.Filename)
+		}
+		return names
+	}
+
+	start := time.Now()
+	notes := upload("Notes.txt", "text/plain", "some notes")
+	report := upload("report.pdf", "application/pdf", "%PDF-1.4 report")
+	data := upload("data.json", "application/json", `{"a": 1}`)
+	time.Sleep(10 * time.Millisecond)
+	middle := time.Now()
+	upload("report_2.txt", "text/plain", "report 2!")
+	setStatuses(notes, "completed", "completed")
+	setStatuses(report, "completed", "failed")
+	setStatuses(data, "processing", "pending")
+
+	// Newest first by default
+	assert.Equal(t, []string{"report_2.txt", "data.json", "report.pdf", "Notes.txt"}, list(&pbv1.ListFilesRequest{}))
+
+	assert.Equal(t, []string{"report.pdf"}, list(&pbv1.ListFilesRequest{FileType: pbv1.FileType_FILE_TYPE_DOCUMENT}))
+	assert.Equal(t, []string{"data.json"}, list(&pbv1.ListFilesRequest{ContentTypePrefix: "application/j"}))
+	assert.Equal(t, []string{"report_2.txt", "data.json"}, list(&pbv1.ListFilesRequest{MaxSize: 9}))
+	assert.Equal(t, []string{"report.pdf", "Notes.txt"}, list(&pbv1.ListFilesRequest{MinSize: 10}))
+	assert.Equal(t, []string{"report_2.txt"}, list(&pbv1.ListFilesRequest{UploadedAfter: timestamppb.New(middle)}))
+	assert.Equal(t, []string{"data.json", "report.pdf", "Notes.txt"}, list(&pbv1.ListFilesRequest{
+		UploadedAfter: timestamppb.New(start), UploadedBefore: timestamppb.New(middle),
+	}))
+	// Prefixes are case-insensitive, and "_" is matched literally
+	assert.Equal(t, []string{"Notes.txt"}, list(&pbv1.ListFilesRequest{FilenamePrefix: "notes"}))
+	assert.Equal(t, []string{"report_2.txt"}, list(&pbv1.ListFilesRequest{FilenamePrefix: "REPORT_"}))
+
+	for status, want := range map[pbv1.ProcessingStatus][]string{
+		pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING:    {"report_2.txt"},
+		pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING: {"data.json"},
+		pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED:  {"Notes.txt"},
+		pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED:     {"report.pdf"},
+	} {
+		assert.Equal(t, want, list(&pbv1.ListFilesRequest{ProcessingStatus: status}), status.String())
+	}
+
+	assert.Equal(t, []string{"data.json", "Notes.txt", "report.pdf", "report_2.txt"},
+		list(&pbv1.ListFilesRequest{SortBy: pbv1.FileSortField_FILE_SORT_FIELD_NAME}))
+	assert.Equal(t, []string{"report.pdf", "Notes.txt", "report_2.txt", "data.json"},
+		list(&pbv1.ListFilesRequest{SortBy: pbv1.FileSortField_FILE_SORT_FIELD_SIZE}))
+	assert.Equal(t, []string{"Notes.txt", "report.pdf", "data.json", "report_2.txt"}, list(&pbv1.ListFilesRequest{
+		SortBy: pbv1.FileSortField_FILE_SORT_FIELD_UPLOADED_AT, SortDirection: pbv1.SortDirection_SORT_DIRECTION_ASCENDING,
+	}))
+
+	// Files of equal size page through without repeats or gaps
+	for i := 0; i < 4; i++ {
+		upload(fmt.Sprintf("same-%d.txt", i), "text/plain", "same")
+	}
+	seen := make(map[string]bool)
+	req := &pbv1.ListFilesRequest{PageSize: 3, SortBy: pbv1.FileSortField_FILE_SORT_FIELD_SIZE}
+	for {
+		resp, err := client.ListFiles(ctx, req)
+		require.NoError(t, err)
+		for _, f := range resp.Files {
+			assert.False(t, seen[f.FileId], f.Filename)
+			seen[f.FileId] = true
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	assert.Len(t, seen, 8)
+
+	for name, req := range map[string]*pbv1.ListFilesRequest{
+		"sizes":     {MinSize: 10, MaxSize: 5},
+		"dates":     {UploadedAfter: timestamppb.New(middle), UploadedBefore: timestamppb.New(start)},
+		"file type": {FileType: pbv1.FileType(99)},
+		"sort":      {SortBy: pbv1.FileSortField(99)},
+	} {
+		req.PageSize = 10
+		_, err := client.ListFiles(ctx, req)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err), name)
+	}
+}
+
+func TestListFilesPageTokens(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	conn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	upload := func(name string) string {
+		stream, err := client.UploadFile(ctx)
+		require.NoError(t, err)
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Metadata{
+				Metadata: &pbv1.FileMetadata{Filename: name, ContentType: "text/plain", Size: 4},
+			},
+		}))
+		require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+			Data: &pbv1.UploadFileRequest_Chunk{Chunk: []byte("data")},
+		}))
+		resp, err := stream.CloseAndRecv()
+		require.NoError(t, err)
+		return resp.FileId
+	}
+	oldest := upload("a.txt")
+	upload("b.txt")
+	upload("c.txt")
+
+	first, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 2})
+	require.NoError(t, err)
+	require.Len(t, first.Files, 2)
+	require.NotEmpty(t, first.NextPageToken)
+
+	// Files added since don't shift the next page
+	upload("d.txt")
+	second, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 2, PageToken: first.NextPageToken})
+	require.NoError(t, err)
+	require.Len(t, second.Files, 1)
+	assert.Equal(t, oldest, second.Files[0].FileId)
+	assert.Empty(t, second.NextPageToken)
+
+	tampered := []byte(first.NextPageToken)
+	tampered[5] ^= 1
+	otherConn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer otherConn.Close()
+	for name, call := range map[string]func() error{
+		"offset": func() error {
+			_, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 2, PageToken: "2"})
+			return err
+		},
+		"tampered": func() error {
+			_, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 2, PageToken: string(tampered)})
+			return err
+		},
+		"other filter": func() error {
+			_, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 2, PageToken: first.NextPageToken, FilenamePrefix: "a"})
+			return err
+		},
+		"other sort": func() error {
+			_, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{
+				PageSize: 2, PageToken: first.NextPageToken, SortDirection: pbv1.SortDirection_SORT_DIRECTION_ASCENDING,
+			})
+			return err
+		},
+		"other user": func() error {
+			_, err := pbv1.NewFileServiceClient(otherConn).ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 2, PageToken: first.NextPageToken})
+			return err
+		},
+	} {
+		assert.Equal(t, codes.InvalidArgument, status.Code(call()), name)
+	}
+}
+
+func TestListFilesProcessingState(t *testing.T) {
+	_, cleanup := setupTestServer(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	db, err := database.NewPostgresDB(os.Getenv("UPLOADSTREAM"))
+	require.NoError(t, err)
+
+	conn := dialTestServer(t, signTestToken(t, uuid.New().String(), ""))
+	defer conn.Close()
+	client := pbv1.NewFileServiceClient(conn)
+
+	stream, err := client.UploadFile(ctx)
+	require.NoError(t, err)
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Metadata{
+			Metadata: &pbv1.FileMetadata{Filename: "photo.txt", ContentType: "text/plain", Size: 4},
+		},
+	}))
+	require.NoError(t, stream.Send(&pbv1.UploadFileRequest{
+		Data: &pbv1.UploadFileRequest_Chunk{Chunk: []byte("data")},
+	}))
+	uploaded, err := stream.CloseAndRecv()
+	require.NoError(t, err)
+
+	entry := func() *pbv1.FileEntry {
+		resp, err := client.ListFiles(ctx, &pbv1.ListFilesRequest{PageSize: 10})
+		require.NoError(t, err)
+		require.Len(t, resp.Files, 1)
+		return resp.Files[0]
+	}
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING, entry().ProcessingStatus)
+	assert.Nil(t, entry().Thumbnail)
+
+	jobs, err := db.ListJobsByFileID(ctx, uploaded.FileId)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.NoError(t, db.CompleteJob(ctx, jobs[0].ID, "", &database.JobResult{
+		Width:  1200,
+		Height: 800,
+		Artifacts: []database.Artifact{
+			{Name: "large", Kind: database.ArtifactThumbnail, Path: "large.jpg", Width: 800, Height: 533},
+			{Name: "small", Kind: database.ArtifactThumbnail, Path: "small.jpg", Width: 150, Height: 100},
+			{Name: "animated", Kind: database.ArtifactPreview, Path: "animated.gif", Width: 100, Height: 67},
+		},
+	}))
+
+	e := entry()
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING, e.ProcessingStatus)
+	assert.Equal(t, []int32{1200, 800}, []int32{e.Width, e.Height})
+	require.NotNil(t, e.Thumbnail)
+	assert.Equal(t, "small", e.Thumbnail.Name)
+	assert.Equal(t, "small.jpg", e.Thumbnail.Path)
+	assert.Equal(t, int32(150), e.Thumbnail.Width)
+
+	require.NoError(t, db.UpdateJobStatus(ctx, jobs[1].ID, "", "dead_letter", "boom"))
+	assert.Equal(t, pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED, entry().ProcessingStatus)
+
+	// Listings agree with GetFileMetadata
+	meta, err := client.GetFileMetadata(ctx, &pbv1.GetFileMetadataRequest{FileId: uploaded.FileId})
+	require.NoError(t, err)
+	assert.Equal(t, meta.ProcessingStatus, entry().ProcessingStatus)
 }
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/imagehash"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FindSimilarFiles defaults
+const (
+	defaultSimilarDistance = 10
+	defaultSimilarLimit    = 20
+)
+
+func (s *fileServer) FindSimilarFiles(ctx context.Context, req *pbv1.FindSimilarFilesRequest) (*pbv1.FindSimilarFilesResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+	maxDistance := defaultSimilarDistance
+	if req.MaxDistance != nil {
+		maxDistance = int(*req.MaxDistance)
+	}
+	if maxDistance < 0 || maxDistance > 64 {
+		return nil, status.Error(codes.InvalidArgument, "max_distance must be between 0 and 64")
+	}
+	limit := int(req.Limit)
+	if limit < 0 || limit > 100 {
+		return nil, status.Error(codes.InvalidArgument, "limit must be between 0 and 100")
+	}
+	if limit == 0 {
+		limit = defaultSimilarLimit
+	}
+
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := s.database.GetFile(ctx, req.FileId)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "file not found: %s", req.FileId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load file: %v", err)
+	}
+	if file.UserID != userID {
+		return nil, status.Error(codes.NotFound, "file not found")
+	}
+
+	jobs, err := s.database.ListJobsByFileID(ctx, file.ID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load processing jobs: %v", err)
+	}
+	hashes, unfinished := findHashes(jobs)
+	if hashes == nil {
+		if unfinished {
+			return nil, status.Errorf(codes.FailedPrecondition,
+				"file %s hasn't been hashed yet; processing is still running", file.ID)
+		}
+		return nil, status.Errorf(codes.FailedPrecondition, "file %s has no perceptual hash (%s)", file.ID, file.ContentType)
+	}
+
+	hashKey, hash := "phash", hashes.PHash
+	if req.Hash == pbv1.PerceptualHash_PERCEPTUAL_HASH_DHASH {
+		hashKey, hash = "dhash", hashes.DHash
+	}
+	if _, err := imagehash.ParseHash(hash); err != nil {
+		return nil, status.Errorf(codes.Internal, "stored %s of file %s is invalid: %v", hashKey, file.ID, err)
+	}
+
+	similar, err := s.database.FindSimilarImages(ctx, userID, file.ID, hashKey, hash, maxDistance, limit)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to find similar files: %v", err)
+	}
+
+	resp := &pbv1.FindSimilarFilesResponse{}
+	for _, match := range similar {
+		resp.Files = append(resp.Files, &pbv1.SimilarFile{
+			File:     fileEntry(match.File),
+			Distance: int32(match.Distance),
+		})
+	}
+	return resp, nil
+}
+
+// findHashes returns the image hashes from a completed job. unfinished
+// reports whether a job that might still compute them hasn't finished.
+func findHashes(jobs []*database.ProcessingJob) (hashes *database.ImageHashes, unfinished bool) {
+	for _, job := range jobs {
+		state, _ := processingState(job)
+		if state == pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED {
+			if job.Result != nil && job.Result.Hashes != nil {
+				return job.Result.Hashes, false
+			}
+		} else if !finished(state) {
+			unfinished = true
+		}
+	}
+	return nil, unfinished
+}
@@ -0,0 +1,228 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Resumable sessions are garbage-collected this long after the last attempt
+const uploadSessionTTL = 24 * time.Hour
+
+// uploadClaimTTL is how long a stream's claim on a session lasts without
+// being renewed. Streams renew it as chunks arrive.
+const uploadClaimTTL = time.Minute
+
+// uploadPartKey is where a session's partial bytes live in storage
+func uploadPartKey(uploadID string) string {
+	return "upload-" + uploadID + ".part"
+}
+
+// openUploadSession loads (or starts) userID's session for metadata.UploadId,
+// claims it for this stream and checks that the client resumes exactly at
+// the committed offset. The caller must release the claim.
+func (s *fileServer) openUploadSession(ctx context.Context, userID string, metadata *pbv1.FileMetadata) (*database.UploadSession, *uploadClaim, error) {
+	session, err := s.database.GetUploadSession(ctx, metadata.UploadId)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, nil, status.Errorf(codes.Internal, "failed to load upload session: %v", err)
+	}
+
+	// An expired session is as good as gone, even if the reaper hasn't run yet
+	if session != nil && time.Now().After(session.ExpiresAt) {
+		s.discardUploadSession(ctx, session)
+		session = nil
+	}
+
+	expiresAt := time.Now().Add(uploadSessionTTL)
+	claim := &uploadClaim{s: s, uploadID: metadata.UploadId, id: uuid.New().String(), renewedAt: time.Now()}
+
+	if session == nil {
+		if metadata.ResumeOffset != 0 {
+			return nil, nil, status.Errorf(codes.FailedPrecondition,
+				"no upload session %s; start from offset 0", metadata.UploadId)
+		}
+		session = &database.UploadSession{
+			UploadID:    metadata.UploadId,
+			UserID:      userID,
+			Filename:    metadata.Filename,
+			ContentType: metadata.ContentType,
+			Size:        metadata.Size,
+			StoragePath: uploadPartKey(metadata.UploadId),
+			ExpiresAt:   expiresAt,
+			ClaimID:     claim.id,
+			ClaimTTL:    uploadClaimTTL,
+		}
+		err := s.database.CreateUploadSession(ctx, session)
+		if errors.Is(err, database.ErrUploadSessionClaimed) {
+			return nil, nil, status.Errorf(codes.Aborted, "upload session %s is in use by another stream", metadata.UploadId)
+		}
+		if err != nil {
+			return nil, nil, status.Errorf(codes.Internal, "failed to create upload session: %v", err)
+		}
+		return session, claim, nil
+	}
+
+	//  . Ownership and consistency checks
+	if session.UserID != userID {
+		return nil, nil, status.Error(codes.AlreadyExists, "upload_id already in use")
+	}
+	if session.Size != metadata.Size || session.Filename != metadata.Filename ||
+		session.ContentType != metadata.ContentType {
+		return nil, nil, status.Errorf(codes.FailedPrecondition,
+			"metadata does not match upload session %s", metadata.UploadId)
+	}
+
+	// Only one stream may write the part at a time, or their bytes interleave
+	err = s.database.ClaimUploadSession(ctx, session.UploadID, claim.id, uploadClaimTTL)
+	if errors.Is(err, database.ErrUploadSessionClaimed) {
+		return nil, nil, status.Errorf(codes.Aborted, "upload session %s is in use by another stream", metadata.UploadId)
+	}
+	if err != nil {
+		return nil, nil, status.Errorf(codes.Internal, "failed to claim upload session: %v", err)
+	}
+
+	// Offset 0 restarts the session from scratch; anything else must line up
+	if metadata.ResumeOffset != 0 {
+		committed, err := s.committedOffset(session)
+		if err != nil {
+			claim.release(ctx)
+			return nil, nil, status.Errorf(codes.Internal, "failed to read upload progress: %v", err)
+		}
+		if metadata.ResumeOffset != committed {
+			claim.release(ctx)
+			return nil, nil, status.Errorf(codes.FailedPrecondition,
+				"resume offset %d does not match committed offset %d", metadata.ResumeOffset, committed)
+		}
+	}
+
+	if err := s.database.ExtendUploadSession(ctx, session.UploadID, expiresAt); err != nil {
+		claim.release(ctx)
+		return nil, nil, status.Errorf(codes.Internal, "failed to extend upload session: %v", err)
+	}
+	session.ExpiresAt = expiresAt
+
+	return session, claim, nil
+}
+
+// uploadClaim is a stream's exclusive hold on an upload session
+type uploadClaim struct {
+	s         *fileServer
+	uploadID  string
+	id        string
+	renewedAt time.Time
+}
+
+// renew extends the claim once a third of it has passed
+func (c *uploadClaim) renew(ctx context.Context) error {
+	if time.Since(c.renewedAt) < uploadClaimTTL/3 {
+		return nil
+	}
+	return c.hold(ctx)
+}
+
+// hold extends the claim now. It fails with Aborted if the claim lapsed and
+// another stream took the session over.
+func (c *uploadClaim) hold(ctx context.Context) error {
+	err := c.s.database.RenewUploadSessionClaim(ctx, c.uploadID, c.id, uploadClaimTTL)
+	if errors.Is(err, database.ErrUploadSessionClaimed) {
+		return status.Errorf(codes.Aborted, "upload session %s was taken over by another stream", c.uploadID)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to renew upload session claim: %v", err)
+	}
+	c.renewedAt = time.Now()
+	return nil
+}
+
+// release lets another stream resume the session right away
+func (c *uploadClaim) release(ctx context.Context) {
+	if err := c.s.database.ReleaseUploadSession(context.WithoutCancel(ctx), c.uploadID, c.id); err != nil {
+		log.Printf("Warning: failed to release upload session %s: %v", c.uploadID, err)
+	}
+}
+
+// committedOffset reports how many bytes of the session are in storage
+func (s *fileServer) committedOffset(session *database.UploadSession) (int64, error) {
+	size, err := s.storage.FileSize(session.StoragePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	return size, err
+}
+
+// replayCommitted feeds the session's stored bytes into digest
+func (s *fileServer) replayCommitted(session *database.UploadSession, digest *checksummer) error {
+	reader, err := s.storage.ReadFile(session.StoragePath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = digest.ReadFrom(reader)
+	return err
+}
+
+// discardUploadSession removes a session and its partial data
+func (s *fileServer) discardUploadSession(ctx context.Context, session *database.UploadSession) {
+	// Runs on failure paths, so don't let a canceled stream skip the cleanup
+	ctx = context.WithoutCancel(ctx)
+
+	if err := s.storage.DeleteFile(session.StoragePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		log.Printf("Warning: failed to delete partial upload %s: %v", session.UploadID, err)
+	}
+	if err := s.database.DeleteUploadSession(ctx, session.UploadID); err != nil {
+		log.Printf("Warning: failed to delete upload session %s: %v", session.UploadID, err)
+	}
+}
+
+func (s *fileServer) GetUploadStatus(ctx context.Context, req *pbv1.GetUploadStatusRequest) (*pbv1.GetUploadStatusResponse, error) {
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.database.GetUploadSession(ctx, req.UploadId)
+	if err == sql.ErrNoRows {
+		return nil, status.Errorf(codes.NotFound, "upload session not found: %s", req.UploadId)
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "database error: %v", err)
+	}
+
+	//  . Ownership check (don't reveal other users' sessions)
+	if session.UserID != userID {
+		return nil, status.Errorf(codes.NotFound, "upload session not found: %s", req.UploadId)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, status.Errorf(codes.NotFound, "upload session expired: %s", req.UploadId)
+	}
+
+	committed, err := s.committedOffset(session)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to read upload progress: %v", err)
+	}
+
+	return &pbv1.GetUploadStatusResponse{
+		UploadId:        session.UploadID,
+		Filename:        session.Filename,
+		Size:            session.Size,
+		CommittedOffset: committed,
+		ExpiresAt:       timestamppb.New(session.ExpiresAt),
+	}, nil
+}
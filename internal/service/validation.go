@@ -1,12 +1,70 @@
 package service
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+)
+
+// Limits on an upload's labels (matching the proto)
+const (
+	maxTags             = 32
+	maxTagLen           = 64
+	maxCustomMetadata   = 32
+	maxMetadataKeyLen   = 64
+	maxMetadataValueLen = 1024
 )
 
+// normalizeLabels checks an upload's tags and custom metadata, and
+// lowercases its tags and drops duplicates
+func normalizeLabels(metadata *pbv1.FileMetadata) error {
+	tags, err := normalizeTags(metadata.Tags)
+	if err != nil {
+		return err
+	}
+	metadata.Tags = tags
+
+	if len(metadata.CustomMetadata) > maxCustomMetadata {
+		return fmt.Errorf("at most %d custom_metadata entries are allowed", maxCustomMetadata)
+	}
+	for key, value := range metadata.CustomMetadata {
+		if key == "" || utf8.RuneCountInString(key) > maxMetadataKeyLen || strings.IndexFunc(key, unicode.IsControl) >= 0 {
+			return fmt.Errorf("custom_metadata key %q must be 1-%d printable characters", key, maxMetadataKeyLen)
+		}
+		if utf8.RuneCountInString(value) > maxMetadataValueLen || strings.ContainsRune(value, 0) {
+			return fmt.Errorf("custom_metadata value of %q must be at most %d characters, without NUL", key, maxMetadataValueLen)
+		}
+	}
+	return nil
+}
+
+// normalizeTags lowercases and trims tags and drops duplicates, keeping
+// the first occurrence's position
+func normalizeTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	var normalized []string
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLen || strings.IndexFunc(tag, unicode.IsControl) >= 0 {
+			return nil, fmt.Errorf("tag %q must be 1-%d printable characters", tag, maxTagLen)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
 // ValidateContentType checks if uploaded data matches declared content type
 func ValidateContentType(reader io.Reader, declaredType string) error {
 	buffer := make([]byte, 512)
@@ -15,7 +73,7 @@ func ValidateContentType(reader io.Reader, declaredType string) error {
 		return fmt.Errorf("read magic bytes: %w", err)
 	}
 
-	actualType := http.DetectContentType(buffer[:n])
+	actualType := sniffContentType(buffer[:n])
 
 	// Normalize types (handle aliases)
 	if !isContentTypeMatch(actualType, declaredType) {
@@ -26,6 +84,14 @@ func ValidateContentType(reader io.Reader, declaredType string) error {
 	return nil
 }
 
+// sniffContentType is http.DetectContentType plus formats it doesn't know
+func sniffContentType(data []byte) string {
+	if bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")) {
+		return "image/tiff"
+	}
+	return http.DetectContentType(data)
+}
+
 func isContentTypeMatch(actual, declared string) bool {
 	// Exact match
 	if actual == declared {
@@ -0,0 +1,332 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// VariantConfig bounds GetImageVariant. Per-tenant overrides of the
+// dimensions live in tenant_settings.
+type VariantConfig struct {
+	MaxWidth    int // Widest variant (default 2048)
+	MaxHeight   int // Tallest variant (default 2048)
+	Concurrency int // Variants generated at once (default: one per CPU)
+
+	// MaxSourcePixels is the largest original variants are made of
+	// (default worker.DefaultMaxImagePixels)
+	MaxSourcePixels int
+}
+
+// Variant fit and format enums <-> preset values
+var (
+	variantFits = map[pbv1.VariantFit]string{
+		pbv1.VariantFit_VARIANT_FIT_UNSPECIFIED: worker.FitContain,
+		pbv1.VariantFit_VARIANT_FIT_FIT:         worker.FitContain,
+		pbv1.VariantFit_VARIANT_FIT_FILL:        worker.FitFill,
+		pbv1.VariantFit_VARIANT_FIT_SMART_CROP:  worker.FitSmartCrop,
+	}
+	variantFormats = map[pbv1.ImageFormat]string{
+		pbv1.ImageFormat_IMAGE_FORMAT_UNSPECIFIED: worker.FormatJPEG,
+		pbv1.ImageFormat_IMAGE_FORMAT_JPEG:        worker.FormatJPEG,
+		pbv1.ImageFormat_IMAGE_FORMAT_PNG:         worker.FormatPNG,
+		pbv1.ImageFormat_IMAGE_FORMAT_WEBP:        worker.FormatWebP,
+	}
+)
+
+// variantSpec is a validated GetImageVariant request
+type variantSpec struct {
+	crop   *image.Rectangle // In the upright original; nil keeps everything
+	rotate int              // Clockwise degrees
+	preset worker.ThumbnailPreset
+}
+
+func (s *fileServer) GetImageVariant(req *pbv1.GetImageVariantRequest, stream pbv1.FileService_GetImageVariantServer) error {
+	ctx := stream.Context()
+
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	id, err := middleware.ExtractIdentity(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := s.database.GetFile(ctx, req.FileId)
+	if err == sql.ErrNoRows {
+		return status.Errorf(codes.NotFound, "file not found: %s", req.FileId)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to load file: %v", err)
+	}
+	if file.UserID != id.UserID {
+		return status.Error(codes.NotFound, "file not found")
+	}
+	if database.DeriveFileType(file.ContentType) != database.FileTypeImage {
+		return status.Errorf(codes.FailedPrecondition, "file is not an image (%s)", file.ContentType)
+	}
+
+	maxWidth, maxHeight, err := s.variantLimits(ctx, id.TenantID)
+	if err != nil {
+		return err
+	}
+	spec, err := newVariantSpec(req, maxWidth, maxHeight)
+	if err != nil {
+		return err
+	}
+
+	// Serve the cached variant, or make it
+	key := spec.key(file)
+	cached := true
+	if _, err := s.storage.FileSize(key); err != nil {
+		cached = false
+		limits, err := s.quotaLimits(ctx, ownerFromIdentity(id))
+		if err != nil {
+			return err
+		}
+		if err := s.generateVariant(ctx, file, spec, key, limits); err != nil {
+			return err
+		}
+	}
+
+	size, err := s.storage.FileSize(key)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to stat variant: %v", err)
+	}
+	reader, err := s.storage.ReadFile(key)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to open variant: %v", err)
+	}
+	defer reader.Close()
+
+	// Read the dimensions from the header, then send it along with the rest
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(reader, &header))
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to read variant: %v", err)
+	}
+
+	err = stream.Send(&pbv1.GetImageVariantResponse{
+		Data: &pbv1.GetImageVariantResponse_Info{
+			Info: &pbv1.VariantInfo{
+				FileId:      file.ID,
+				ContentType: spec.preset.ContentType(),
+				Size:        size,
+				Width:       int32(cfg.Width),
+				Height:      int32(cfg.Height),
+				Cached:      cached,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendChunks(ctx, io.MultiReader(&header, reader), func(chunk []byte) error {
+		return stream.Send(&pbv1.GetImageVariantResponse{
+			Data: &pbv1.GetImageVariantResponse_Chunk{Chunk: chunk},
+		})
+	})
+}
+
+// variantLimits returns the largest variant the tenant may request
+func (s *fileServer) variantLimits(ctx context.Context, tenantID string) (int, int, error) {
+	maxWidth, maxHeight := s.variants.MaxWidth, s.variants.MaxHeight
+	if tenantID == "" {
+		return maxWidth, maxHeight, nil
+	}
+
+	tenantWidth, tenantHeight, err := s.database.GetTenantVariantLimits(ctx, tenantID)
+	if err != nil {
+		return 0, 0, status.Errorf(codes.Internal, "failed to load tenant variant limits: %v", err)
+	}
+	if tenantWidth > 0 {
+		maxWidth = tenantWidth
+	}
+	if tenantHeight > 0 {
+		maxHeight = tenantHeight
+	}
+	return maxWidth, maxHeight, nil
+}
+
+// newVariantSpec checks req against the limits. A FIT box side left at 0
+// becomes the limit, so no variant is ever larger than the limits allow.
+func newVariantSpec(req *pbv1.GetImageVariantRequest, maxWidth, maxHeight int) (*variantSpec, error) {
+	width, height := int(req.Width), int(req.Height)
+	if width > maxWidth || height > maxHeight {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"variant can be at most %dx%d, requested %dx%d", maxWidth, maxHeight, width, height)
+	}
+
+	spec := &variantSpec{
+		rotate: int(req.Rotate),
+		preset: worker.ThumbnailPreset{
+			Name:    "variant",
+			Width:   width,
+			Height:  height,
+			Fit:     variantFits[req.Fit],
+			Format:  variantFormats[req.Format],
+			Quality: int(req.Quality),
+			Upscale: req.Upscale,
+		},
+	}
+	// Quality only matters for JPEG; ignoring it elsewhere saves cache entries
+	if spec.preset.Format != worker.FormatJPEG {
+		spec.preset.Quality = 0
+	}
+	if spec.preset.Fit == worker.FitContain {
+		if spec.preset.Width == 0 {
+			spec.preset.Width = maxWidth
+		}
+		if spec.preset.Height == 0 {
+			spec.preset.Height = maxHeight
+		}
+	}
+	if err := spec.preset.Validate(); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid variant: %v", err)
+	}
+
+	switch spec.rotate {
+	case 0, 90, 180, 270:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "rotate must be 0, 90, 180 or 270")
+	}
+
+	if c := req.Crop; c != nil {
+		if c.X < 0 || c.Y < 0 || c.Width <= 0 || c.Height <= 0 {
+			return nil, status.Error(codes.InvalidArgument, "crop must have a positive size")
+		}
+		rect := image.Rect(int(c.X), int(c.Y), int(c.X)+int(c.Width), int(c.Y)+int(c.Height))
+		spec.crop = &rect
+	}
+	return spec, nil
+}
+
+// key is where the variant is cached. It covers everything that affects the
+// output, including the original's content.
+func (spec *variantSpec) key(file *database.FileRecord) string {
+	crop := "-"
+	if spec.crop != nil {
+		crop = spec.crop.String()
+	}
+	p := spec.preset
+	canonical := fmt.Sprintf("v1|%s|%s|%s|%d|%dx%d|%s|%s|%d|%t",
+		file.ID, file.Checksum.SHA256, crop, spec.rotate,
+		p.Width, p.Height, p.Fit, p.Format, p.Quality, p.Upscale)
+	sum := sha256.Sum256([]byte(canonical))
+	return fmt.Sprintf("%s-variant-%x.%s", file.ID, sum[:12], p.Extension())
+}
+
+// apply crops, rotates and scales img
+func (spec *variantSpec) apply(img image.Image) (image.Image, error) {
+	if spec.crop != nil {
+		bounds := img.Bounds()
+		rect := spec.crop.Add(bounds.Min)
+		if !rect.In(bounds) {
+			return nil, status.Errorf(codes.InvalidArgument,
+				"crop %v is outside the %dx%d image", *spec.crop, bounds.Dx(), bounds.Dy())
+		}
+		img = imaging.Crop(img, rect)
+	}
+
+	// imaging rotates counter-clockwise
+	switch spec.rotate {
+	case 90:
+		img = imaging.Rotate270(img)
+	case 180:
+		img = imaging.Rotate180(img)
+	case 270:
+		img = imaging.Rotate90(img)
+	}
+	return spec.preset.Render(img), nil
+}
+
+// generateVariant renders the variant and stores it at key, charging it to
+// the file's owners within limits. It is written under a temporary key
+// first, so concurrent requests never see half a file.
+func (s *fileServer) generateVariant(ctx context.Context, file *database.FileRecord, spec *variantSpec, key string, limits database.QuotaLimits) error {
+	if err := s.variantSem.Acquire(ctx, 1); err != nil {
+		return status.Errorf(codes.Canceled, "variant canceled: %v", err)
+	}
+	defer s.variantSem.Release(1)
+
+	// Another request may have made it while this one waited
+	if _, err := s.storage.FileSize(key); err == nil {
+		return nil
+	}
+
+	r, err := s.storage.ReadFile(file.ID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to open file: %v", err)
+	}
+	decoded, err := worker.DecodeImage(ctx, r, int64(s.variants.MaxSourcePixels))
+	r.Close()
+	if err != nil {
+		if errors.Is(err, worker.ErrTooManyPixels) {
+			return status.Errorf(codes.FailedPrecondition, "image is too large to make variants of: %v", err)
+		}
+		if worker.IsPermanent(err) {
+			return status.Errorf(codes.FailedPrecondition, "file can't be decoded as an image: %v", err)
+		}
+		if ctx.Err() != nil {
+			return status.Errorf(codes.Canceled, "variant canceled: %v", ctx.Err())
+		}
+		return status.Errorf(codes.Internal, "failed to read file: %v", err)
+	}
+
+	img, err := spec.apply(decoded.Image)
+	if err != nil {
+		return err
+	}
+
+	tmpKey := key + "." + uuid.New().String() + ".tmp"
+	w, err := s.storage.CreateFile(tmpKey)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to create variant: %v", err)
+	}
+	counter := &countingWriter{}
+	err = spec.preset.Encode(io.MultiWriter(w, counter), img)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.storage.DeleteFile(tmpKey)
+		return status.Errorf(codes.Internal, "failed to save variant: %v", err)
+	}
+
+	if err := s.storage.RenameFile(tmpKey, key); err != nil {
+		s.storage.DeleteFile(tmpKey)
+		return status.Errorf(codes.Internal, "failed to save variant: %v", err)
+	}
+
+	// Cached variants count towards the owner's usage and go with the file.
+	// One that can't be recorded would never be cleaned up, so it goes now.
+	err = s.database.RecordImageVariant(ctx, file.ID, key, counter.n, limits)
+	if err != nil {
+		s.storage.DeleteFile(key)
+		if errors.Is(err, database.ErrQuotaExceeded) {
+			return status.Errorf(codes.ResourceExhausted, "variant rejected: %v", err)
+		}
+		if err == sql.ErrNoRows {
+			return status.Errorf(codes.NotFound, "file not found: %s", file.ID)
+		}
+		return status.Errorf(codes.Internal, "failed to record variant: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/middleware"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// watchResyncInterval bounds how stale a watcher can get if a notification is lost
+	watchResyncInterval = 30 * time.Second
+	// watchPollInterval is used instead when there is no event bus
+	watchPollInterval = 2 * time.Second
+)
+
+// processingState maps a job row to the proto status and result
+func processingState(job *database.ProcessingJob) (pbv1.ProcessingStatus, *pbv1.ProcessingResult) {
+	switch job.Status {
+	case "completed":
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED, resultToProto(job.Result)
+	case "processing":
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING, nil
+	case "dead_letter", "failed":
+		errorMsg := ""
+		if job.ErrorMessage != nil {
+			errorMsg = *job.ErrorMessage
+		}
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED, &pbv1.ProcessingResult{
+			ErrorMessage: errorMsg,
+		}
+	default:
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING, nil
+	}
+}
+
+// resultToProto converts a stored job result, filling the deprecated
+// thumbnail fields from the artifacts of the same name
+func resultToProto(result *database.JobResult) *pbv1.ProcessingResult {
+	if result == nil {
+		return &pbv1.ProcessingResult{}
+	}
+	pb := &pbv1.ProcessingResult{
+		OriginalWidth:  int32(result.Width),
+		OriginalHeight: int32(result.Height),
+		Attributes:     result.Attributes,
+		Image:          imageMetadataToProto(result.Image),
+		Hashes:         imageHashesToProto(result.Hashes),
+		Document:       documentToProto(result.Document),
+	}
+	for _, artifact := range result.Artifacts {
+		pbArtifact := artifactToProto(&artifact)
+		pb.Artifacts = append(pb.Artifacts, pbArtifact)
+		if !isThumbnail(artifact) {
+			continue
+		}
+
+		if pb.Thumbnails == nil {
+			pb.Thumbnails = make(map[string]*pbv1.Artifact)
+		}
+		pb.Thumbnails[artifact.Name] = pbArtifact
+		switch artifact.Name {
+		case "small":
+			pb.ThumbnailSmall = artifact.Path
+		case "medium":
+			pb.ThumbnailMedium = artifact.Path
+		case "large":
+			pb.ThumbnailLarge = artifact.Path
+		}
+	}
+	return pb
+}
+
+func artifactToProto(artifact *database.Artifact) *pbv1.Artifact {
+	return &pbv1.Artifact{
+		Name:        artifact.Name,
+		Path:        artifact.Path,
+		ContentType: artifact.ContentType,
+		Width:       int32(artifact.Width),
+		Height:      int32(artifact.Height),
+	}
+}
+
+// isThumbnail reports whether an artifact was made for a thumbnail preset.
+// Results stored before presets existed have no kind; their small, medium
+// and large artifacts are the thumbnails.
+func isThumbnail(artifact database.Artifact) bool {
+	if artifact.Kind == "" {
+		return artifact.Name == "small" || artifact.Name == "medium" || artifact.Name == "large"
+	}
+	return artifact.Kind == database.ArtifactThumbnail
+}
+
+// finished reports whether a task status is terminal
+func finished(status pbv1.ProcessingStatus) bool {
+	return status == pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED ||
+		status == pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED
+}
+
+// aggregateState summarizes a file's jobs. Unfinished jobs win (PROCESSING
+// once any job has started), then FAILED if any job failed, else COMPLETED;
+// a file without jobs has nothing left to do. The result merges every
+// finished job's result.
+func aggregateState(jobs []*database.ProcessingJob) (pbv1.ProcessingStatus, *pbv1.ProcessingResult) {
+	var (
+		pending, started, failed bool
+		failures                 []string
+		merged                   = &pbv1.ProcessingResult{}
+	)
+	for _, job := range jobs {
+		status, result := processingState(job)
+		switch status {
+		case pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING:
+			pending = true
+		case pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING:
+			pending, started = true, true
+		case pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED:
+			started, failed = true, true
+			failures = append(failures, job.JobType+": "+result.ErrorMessage)
+		case pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED:
+			started = true
+			mergeResult(merged, result)
+		}
+	}
+	merged.ErrorMessage = strings.Join(failures, "; ")
+
+	switch {
+	case pending && started:
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_PROCESSING, merged
+	case pending:
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_PENDING, nil
+	case failed:
+		return pbv1.ProcessingStatus_PROCESSING_STATUS_FAILED, merged
+	}
+	return pbv1.ProcessingStatus_PROCESSING_STATUS_COMPLETED, merged
+}
+
+// mergeResult adds src's values to dst; dimensions are kept from the first job that has them
+func mergeResult(dst, src *pbv1.ProcessingResult) {
+	if dst.OriginalWidth == 0 && dst.OriginalHeight == 0 {
+		dst.OriginalWidth, dst.OriginalHeight = src.OriginalWidth, src.OriginalHeight
+	}
+	for key, value := range src.Attributes {
+		if dst.Attributes == nil {
+			dst.Attributes = make(map[string]string)
+		}
+		dst.Attributes[key] = value
+	}
+	dst.Artifacts = append(dst.Artifacts, src.Artifacts...)
+	for name, artifact := range src.Thumbnails {
+		if dst.Thumbnails == nil {
+			dst.Thumbnails = make(map[string]*pbv1.Artifact)
+		}
+		dst.Thumbnails[name] = artifact
+	}
+	if src.Image != nil {
+		dst.Image = src.Image
+	}
+	if src.Hashes != nil {
+		dst.Hashes = src.Hashes
+	}
+	if src.Document != nil {
+		dst.Document = src.Document
+	}
+	if src.ThumbnailSmall != "" {
+		dst.ThumbnailSmall, dst.ThumbnailMedium, dst.ThumbnailLarge =
+			src.ThumbnailSmall, src.ThumbnailMedium, src.ThumbnailLarge
+	}
+}
+
+func imageHashesToProto(hashes *database.ImageHashes) *pbv1.ImageHashes {
+	if hashes == nil {
+		return nil
+	}
+	return &pbv1.ImageHashes{
+		Phash:    hashes.PHash,
+		Dhash:    hashes.DHash,
+		Blurhash: hashes.BlurHash,
+	}
+}
+
+func documentToProto(doc *database.DocumentMetadata) *pbv1.DocumentMetadata {
+	if doc == nil {
+		return nil
+	}
+	pb := &pbv1.DocumentMetadata{
+		PageCount: int32(doc.PageCount),
+		Title:     doc.Title,
+		Author:    doc.Author,
+		Subject:   doc.Subject,
+		Keywords:  doc.Keywords,
+		Creator:   doc.Creator,
+		Producer:  doc.Producer,
+	}
+	if doc.CreatedAt != nil {
+		pb.CreatedAt = timestamppb.New(*doc.CreatedAt)
+	}
+	if doc.ModifiedAt != nil {
+		pb.ModifiedAt = timestamppb.New(*doc.ModifiedAt)
+	}
+	for _, page := range doc.Pages {
+		pb.Pages = append(pb.Pages, &pbv1.DocumentPage{
+			Number:        int32(page.Number),
+			WidthPt:       page.Width,
+			HeightPt:      page.Height,
+			Text:          page.Text,
+			TextTruncated: page.TextTruncated,
+		})
+	}
+	return pb
+}
+
+func imageMetadataToProto(meta *database.ImageMetadata) *pbv1.ImageMetadata {
+	if meta == nil {
+		return nil
+	}
+	pb := &pbv1.ImageMetadata{
+		CameraMake:    meta.CameraMake,
+		CameraModel:   meta.CameraModel,
+		LensModel:     meta.LensModel,
+		Software:      meta.Software,
+		Orientation:   int32(meta.Orientation),
+		ExposureTime:  meta.ExposureTime,
+		FNumber:       meta.FNumber,
+		Iso:           int32(meta.ISO),
+		FocalLengthMm: meta.FocalLength,
+		Xmp:           meta.XMP,
+	}
+	if meta.CapturedAt != nil {
+		pb.CapturedAt = timestamppb.New(*meta.CapturedAt)
+	}
+	if meta.GPS != nil {
+		pb.Gps = &pbv1.GpsPosition{
+			Latitude:  meta.GPS.Latitude,
+			Longitude: meta.GPS.Longitude,
+			Altitude:  meta.GPS.Altitude,
+		}
+	}
+	return pb
+}
+
+func tasksToProto(jobs []*database.ProcessingJob) []*pbv1.ProcessingTask {
+	tasks := make([]*pbv1.ProcessingTask, 0, len(jobs))
+	for _, job := range jobs {
+		status, result := processingState(job)
+		tasks = append(tasks, &pbv1.ProcessingTask{
+			JobId:     job.ID,
+			JobType:   job.JobType,
+			Status:    status,
+			Result:    result,
+			UpdatedAt: timestamppb.New(job.UpdatedAt),
+		})
+	}
+	return tasks
+}
+
+func (s *fileServer) WatchFile(req *pbv1.WatchFileRequest, stream pbv1.FileService_WatchFileServer) error {
+	ctx := stream.Context()
+
+	// Validate request
+	if err := req.Validate(); err != nil {
+		return status.Errorf(codes.InvalidArgument, "validation failed: %v", err)
+	}
+
+	userID, err := middleware.ExtractUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	file, err := s.database.GetFile(ctx, req.FileId)
+	if err == sql.ErrNoRows {
+		return status.Errorf(codes.NotFound, "file not found: %s", req.FileId)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "database error: %v", err)
+	}
+
+	//  Ownership check (don't reveal other users' files)
+	if file.UserID != userID {
+		return status.Errorf(codes.NotFound, "file not found: %s", req.FileId)
+	}
+
+	// Subscribe before reading the current state so no change slips in between
+	var changes <-chan events.JobEvent
+	interval := watchPollInterval
+	if s.events != nil {
+		ch, unsubscribe := s.events.Subscribe(req.FileId)
+		defer unsubscribe()
+		changes = ch
+		interval = watchResyncInterval
+	}
+
+	resync := time.NewTicker(interval)
+	defer resync.Stop()
+
+	// Last status sent per job
+	sent := make(map[int64]pbv1.ProcessingStatus)
+	for {
+		jobs, err := s.database.ListJobsByFileID(ctx, req.FileId)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to load processing jobs: %v", err)
+		}
+
+		done := true
+		for _, job := range jobs {
+			processingStatus, result := processingState(job)
+			if last, ok := sent[job.ID]; !ok || last != processingStatus {
+				if err := stream.Send(&pbv1.WatchFileResponse{
+					FileId:           req.FileId,
+					JobId:            job.ID,
+					JobType:          job.JobType,
+					ProcessingStatus: processingStatus,
+					ProcessingResult: result,
+					UpdatedAt:        timestamppb.New(job.UpdatedAt),
+				}); err != nil {
+					return err
+				}
+				sent[job.ID] = processingStatus
+			}
+			done = done && finished(processingStatus)
+		}
+		if done {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-changes:
+		case <-resync.C:
+		}
+	}
+}
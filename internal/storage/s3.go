@@ -0,0 +1,286 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sync"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// Multipart part size for streamed writes (S3 minimum is 5MB)
+const defaultS3PartSize = 8 * 1024 * 1024
+
+var errWriteAborted = errors.New("write aborted")
+
+// S3Config configures an S3-compatible backend (AWS S3, MinIO, R2, ...)
+type S3Config struct {
+	Endpoint  string // "s3.amazonaws.com" or "localhost:9000"
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	Prefix    string // Optional key prefix, e.g. "files/"
+	PartSize  uint64 // Multipart part size in bytes
+}
+
+// S3Storage stores files as objects in an S3-compatible bucket
+type S3Storage struct {
+	client   *minio.Client
+	bucket   string
+	prefix   string
+	partSize uint64
+
+	mu      sync.Mutex
+	writers map[string]*s3Writer // in-flight uploads, so deletes can abort them
+}
+
+func NewS3Storage(ctx context.Context, cfg S3Config) (*S3Storage, error) {
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure: cfg.UseSSL,
+		Region: cfg.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %w", err)
+	}
+
+	// Create the bucket if missing (handy for local MinIO)
+	exists, err := client.BucketExists(ctx, cfg.Bucket)
+	if err != nil {
+		return nil, fmt.Errorf("check bucket: %w", err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, cfg.Bucket, minio.MakeBucketOptions{Region: cfg.Region}); err != nil {
+			return nil, fmt.Errorf("create bucket: %w", err)
+		}
+	}
+
+	partSize := cfg.PartSize
+	if partSize == 0 {
+		partSize = defaultS3PartSize
+	}
+
+	return &S3Storage{
+		client:   client,
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		partSize: partSize,
+		writers:  make(map[string]*s3Writer),
+	}, nil
+}
+
+func (s *S3Storage) key(fileID string) string {
+	return path.Join(s.prefix, fileID)
+}
+
+// CreateFile streams writes into a multipart upload; the object becomes
+// visible once the returned writer is closed.
+func (s *S3Storage) CreateFile(fileID string) (io.WriteCloser, error) {
+	return s.startWrite(fileID, nil), nil
+}
+
+// ReadFile returns the object body. The reader also implements io.Seeker and
+// io.ReaderAt, which turn into ranged GETs.
+func (s *S3Storage) ReadFile(fileID string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.key(fileID), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, translateS3Error(err)
+	}
+	// GetObject is lazy; stat so missing objects fail here, not on first Read
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, translateS3Error(err)
+	}
+	return obj, nil
+}
+
+// ReadFileRange issues a ranged GET for length bytes starting at offset
+// (length <= 0 reads to the end)
+func (s *S3Storage) ReadFileRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	end := int64(0) // 0 = open-ended
+	if length > 0 {
+		end = offset + length - 1
+	}
+	if offset > 0 || length > 0 {
+		if err := opts.SetRange(offset, end); err != nil {
+			return nil, err
+		}
+	}
+
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.key(fileID), opts)
+	if err != nil {
+		return nil, translateS3Error(err)
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, translateS3Error(err)
+	}
+	return obj, nil
+}
+
+func (s *S3Storage) DeleteFile(fileID string) error {
+	s.abortWrite(fileID)
+
+	// RemoveObject succeeds for missing keys, so stat first to keep
+	// the same semantics as the filesystem backend
+	ctx := context.Background()
+	if _, err := s.client.StatObject(ctx, s.bucket, s.key(fileID), minio.StatObjectOptions{}); err != nil {
+		return translateS3Error(err)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.key(fileID), minio.RemoveObjectOptions{})
+}
+
+// AppendFile re-streams the existing object followed by the new bytes. S3
+// objects are immutable, so this is how resumable uploads grow their part.
+func (s *S3Storage) AppendFile(fileID string) (io.WriteCloser, error) {
+	existing, err := s.ReadFile(fileID)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.CreateFile(fileID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.startWrite(fileID, existing), nil
+}
+
+func (s *S3Storage) FileSize(fileID string) (int64, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.key(fileID), minio.StatObjectOptions{})
+	if err != nil {
+		return 0, translateS3Error(err)
+	}
+	return info.Size, nil
+}
+
+// RenameFile copies server-side, then removes the source
+func (s *S3Storage) RenameFile(oldID, newID string) error {
+	ctx := context.Background()
+	_, err := s.client.CopyObject(ctx,
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(newID)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(oldID)},
+	)
+	if err != nil {
+		return translateS3Error(err)
+	}
+	return s.client.RemoveObject(ctx, s.bucket, s.key(oldID), minio.RemoveObjectOptions{})
+}
+
+// s3Writer pipes Write calls into a background PutObject
+type s3Writer struct {
+	storage *S3Storage
+	fileID  string
+	pw      *io.PipeWriter
+	done    chan struct{}
+	err     error
+	aborted bool
+	once    sync.Once
+}
+
+// startWrite uploads prefix (if any) followed by everything written to the
+// returned writer. Uploads go to a temporary key and are copied into place on
+// success, so a failed append never clobbers the bytes already committed.
+func (s *S3Storage) startWrite(fileID string, prefix io.ReadCloser) *s3Writer {
+	pr, pw := io.Pipe()
+	w := &s3Writer{
+		storage: s,
+		fileID:  fileID,
+		pw:      pw,
+		done:    make(chan struct{}),
+	}
+
+	s.mu.Lock()
+	s.writers[fileID] = w
+	s.mu.Unlock()
+
+	var body io.Reader = pr
+	if prefix != nil {
+		body = io.MultiReader(prefix, pr)
+	}
+
+	go func() {
+		defer close(w.done)
+		if prefix != nil {
+			defer prefix.Close()
+		}
+
+		ctx := context.Background()
+		tmpKey := s.key(fileID) + ".uploading"
+		_, err := s.client.PutObject(ctx, s.bucket, tmpKey, body, -1, minio.PutObjectOptions{
+			PartSize: s.partSize,
+		})
+		if err == nil {
+			_, err = s.client.CopyObject(ctx,
+				minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(fileID)},
+				minio.CopySrcOptions{Bucket: s.bucket, Object: tmpKey},
+			)
+		}
+		// Best effort; a failed upload may not have created the object at all
+		s.client.RemoveObject(ctx, s.bucket, tmpKey, minio.RemoveObjectOptions{})
+
+		// Unblock writers if the upload failed early
+		pr.CloseWithError(err)
+		w.err = err
+	}()
+
+	return w
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+// Close finishes the upload and reports its result. Safe to call twice.
+func (w *s3Writer) Close() error {
+	w.finish(nil)
+	if w.aborted {
+		return nil
+	}
+	return w.err
+}
+
+func (w *s3Writer) finish(cause error) {
+	w.once.Do(func() {
+		if cause != nil {
+			w.aborted = true
+			w.pw.CloseWithError(cause)
+		} else {
+			w.pw.Close()
+		}
+		<-w.done
+
+		w.storage.mu.Lock()
+		if w.storage.writers[w.fileID] == w {
+			delete(w.storage.writers, w.fileID)
+		}
+		w.storage.mu.Unlock()
+	})
+}
+
+// abortWrite cancels an in-flight upload for fileID, if any
+func (s *S3Storage) abortWrite(fileID string) {
+	s.mu.Lock()
+	w := s.writers[fileID]
+	s.mu.Unlock()
+	if w != nil {
+		w.finish(errWriteAborted)
+	}
+}
+
+// translateS3Error maps missing keys to fs.ErrNotExist like os.Open does
+func translateS3Error(err error) error {
+	if err == nil {
+		return nil
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket", "NotFound":
+		return fmt.Errorf("%w: %v", fs.ErrNotExist, err)
+	}
+	return err
+}
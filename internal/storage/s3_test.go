@@ -0,0 +1,106 @@
+package storage_test
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Runs against any S3-compatible endpoint, e.g. the MinIO service in
+// docker-compose: S3_TEST_ENDPOINT=localhost:9000
+func setupS3Storage(t *testing.T) *storage.S3Storage {
+	endpoint := os.Getenv("S3_TEST_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("S3_TEST_ENDPOINT env not set")
+	}
+
+	s3, err := storage.NewS3Storage(context.Background(), storage.S3Config{
+		Endpoint:  endpoint,
+		Bucket:    "uploadstream-test",
+		AccessKey: envOr("S3_TEST_ACCESS_KEY", "minioadmin"),
+		SecretKey: envOr("S3_TEST_SECRET_KEY", "minioadmin"),
+		Prefix:    "test-" + uuid.New().String(),
+	})
+	require.NoError(t, err)
+	return s3
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func TestS3WriteReadDelete(t *testing.T) {
+	s3 := setupS3Storage(t)
+
+	w, err := s3.CreateFile("file-1")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("hello, "))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("object storage"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	r, err := s3.ReadFile("file-1")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+	assert.Equal(t, "hello, object storage", string(data))
+
+	// Ranged read through the seeker
+	r, err = s3.ReadFile("file-1")
+	require.NoError(t, err)
+	_, err = r.(io.Seeker).Seek(7, io.SeekStart)
+	require.NoError(t, err)
+	data, err = io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+	assert.Equal(t, "object storage", string(data))
+
+	require.NoError(t, s3.DeleteFile("file-1"))
+	_, err = s3.ReadFile("file-1")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestS3AppendAndRename(t *testing.T) {
+	s3 := setupS3Storage(t)
+
+	w, err := s3.AppendFile("part")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("first,"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	w, err = s3.AppendFile("part")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("second"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	size, err := s3.FileSize("part")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("first,second")), size)
+
+	require.NoError(t, s3.RenameFile("part", "final"))
+	_, err = s3.FileSize("part")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	r, err := s3.ReadFile("final")
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	r.Close()
+	assert.Equal(t, "first,second", string(data))
+
+	require.NoError(t, s3.DeleteFile("final"))
+}
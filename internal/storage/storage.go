@@ -27,7 +27,50 @@ func (fs *FilesystemStorage) ReadFile(fileID string) (io.ReadCloser, error) {
 	return os.Open(filePath)
 }
 
+// ReadFileRange opens fileID positioned at offset and stops after length
+// bytes (length <= 0 reads to the end)
+func (fs *FilesystemStorage) ReadFileRange(fileID string, offset, length int64) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(fs.basePath, fileID))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if length <= 0 {
+		return file, nil
+	}
+	return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// limitedReadCloser closes the underlying file of a limited reader
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 func (fs *FilesystemStorage) DeleteFile(fileID string) error {
 	filePath := filepath.Join(fs.basePath, fileID)
 	return os.Remove(filePath)
 }
+
+// AppendFile opens a file for appending, creating it if needed (resumable uploads)
+func (fs *FilesystemStorage) AppendFile(fileID string) (io.WriteCloser, error) {
+	filePath := filepath.Join(fs.basePath, fileID)
+	return os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+}
+
+// FileSize returns the number of bytes currently stored for fileID
+func (fs *FilesystemStorage) FileSize(fileID string) (int64, error) {
+	info, err := os.Stat(filepath.Join(fs.basePath, fileID))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// RenameFile moves a stored file to a new ID (commits a finished upload)
+func (fs *FilesystemStorage) RenameFile(oldID, newID string) error {
+	return os.Rename(filepath.Join(fs.basePath, oldID), filepath.Join(fs.basePath, newID))
+}
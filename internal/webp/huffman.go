@@ -0,0 +1,214 @@
+package webp
+
+import (
+	"container/heap"
+	"math/bits"
+)
+
+// codeLengthCodeOrder is the order code length code lengths are written in
+var codeLengthCodeOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// Code length symbols for runs of zeros, with their extra bits and offsets
+const (
+	shortZeroRun = 17 // 3-10 zeros, 3 extra bits
+	longZeroRun  = 18 // 11-138 zeros, 7 extra bits
+)
+
+// prefixCode is a canonical Huffman code
+type prefixCode struct {
+	lengths []uint8  // Code length per symbol, 0 if unused
+	codes   []uint16 // Bit-reversed codes, ready to write LSB first
+	// single is set when only one symbol is used; it then takes no bits
+	single bool
+}
+
+// newPrefixCode builds a code for the symbol counts in freq, with codes no
+// longer than maxLength bits
+func newPrefixCode(freq []int, maxLength int) *prefixCode {
+	c := &prefixCode{codes: make([]uint16, len(freq))}
+
+	// When the tree is too deep, flatten the distribution and try again
+	for minCount := 1; ; minCount *= 2 {
+		c.lengths = huffmanLengths(freq, minCount)
+		if int(maxOf(c.lengths)) <= maxLength {
+			break
+		}
+	}
+
+	used := 0
+	for _, l := range c.lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	c.single = used == 1
+
+	// Canonical codes: shorter codes first, then by symbol
+	var count [16]uint16
+	for _, l := range c.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint16
+	code := uint16(0)
+	for l := 1; l < len(next); l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for sym, l := range c.lengths {
+		if l > 0 {
+			c.codes[sym] = bits.Reverse16(next[l]) >> (16 - l)
+			next[l]++
+		}
+	}
+	return c
+}
+
+// write emits the code for sym
+func (c *prefixCode) write(bw *bitWriter, sym int) {
+	if !c.single {
+		bw.writeBits(uint32(c.codes[sym]), uint(c.lengths[sym]))
+	}
+}
+
+// writeHeader describes the code so a decoder can rebuild it
+func (c *prefixCode) writeHeader(bw *bitWriter) {
+	if maxOf(c.lengths) == 0 {
+		// The code is never used, but it must still be valid: a "simple"
+		// code with the single symbol 0
+		bw.writeBits(1, 1) // Simple code
+		bw.writeBits(0, 1) // One symbol
+		bw.writeBits(0, 1) // Written in one bit
+		bw.writeBits(0, 1) // Symbol 0
+		return
+	}
+
+	// Run-length code the code lengths, using 17 and 18 for runs of zeros
+	type token struct {
+		sym       int
+		extra     uint32
+		extraBits uint
+	}
+	var tokens []token
+	freq := make([]int, len(codeLengthCodeOrder))
+	for i := 0; i < len(c.lengths); {
+		run := 1
+		for i+run < len(c.lengths) && c.lengths[i+run] == c.lengths[i] {
+			run++
+		}
+		if c.lengths[i] != 0 || run < 3 {
+			tokens = append(tokens, token{sym: int(c.lengths[i])})
+			freq[c.lengths[i]]++
+			i++
+			continue
+		}
+		run = min(run, 138)
+		if run <= 10 {
+			tokens = append(tokens, token{shortZeroRun, uint32(run - 3), 3})
+			freq[shortZeroRun]++
+		} else {
+			tokens = append(tokens, token{longZeroRun, uint32(run - 11), 7})
+			freq[longZeroRun]++
+		}
+		i += run
+	}
+
+	lengthCode := newPrefixCode(freq, 7)
+	n := len(codeLengthCodeOrder)
+	for n > 4 && lengthCode.lengths[codeLengthCodeOrder[n-1]] == 0 {
+		n--
+	}
+
+	bw.writeBits(0, 1) // Normal code
+	bw.writeBits(uint32(n-4), 4)
+	for _, sym := range codeLengthCodeOrder[:n] {
+		bw.writeBits(uint32(lengthCode.lengths[sym]), 3)
+	}
+	bw.writeBits(0, 1) // Code lengths for the whole alphabet follow
+	for _, t := range tokens {
+		lengthCode.write(bw, t.sym)
+		bw.writeBits(t.extra, t.extraBits)
+	}
+}
+
+func maxOf(lengths []uint8) uint8 {
+	var m uint8
+	for _, l := range lengths {
+		m = max(m, l)
+	}
+	return m
+}
+
+// huffmanLengths returns optimal code lengths for freq, counting every used
+// symbol as at least minCount. A lone symbol gets length 1.
+func huffmanLengths(freq []int, minCount int) []uint8 {
+	lengths := make([]uint8, len(freq))
+
+	h := &nodeHeap{}
+	for sym, f := range freq {
+		if f > 0 {
+			h.nodes = append(h.nodes, node{weight: max(f, minCount), sym: sym, parent: -1})
+			h.order = append(h.order, len(h.nodes)-1)
+		}
+	}
+	switch len(h.order) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[h.nodes[0].sym] = 1
+		return lengths
+	}
+	leaves := len(h.nodes)
+
+	heap.Init(h)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(int)
+		b := heap.Pop(h).(int)
+		h.nodes = append(h.nodes, node{
+			weight: h.nodes[a].weight + h.nodes[b].weight,
+			sym:    -1,
+			parent: -1,
+		})
+		parent := len(h.nodes) - 1
+		h.nodes[a].parent, h.nodes[b].parent = parent, parent
+		heap.Push(h, parent)
+	}
+
+	for i := 0; i < leaves; i++ {
+		depth := uint8(0)
+		for n := i; h.nodes[n].parent >= 0; n = h.nodes[n].parent {
+			depth++
+		}
+		lengths[h.nodes[i].sym] = depth
+	}
+	return lengths
+}
+
+type node struct {
+	weight int
+	sym    int // -1 for internal nodes
+	parent int
+}
+
+// nodeHeap orders node indexes by weight, ties broken by index so the
+// result is deterministic
+type nodeHeap struct {
+	nodes []node
+	order []int
+}
+
+func (h *nodeHeap) Len() int { return len(h.order) }
+func (h *nodeHeap) Less(i, j int) bool {
+	a, b := h.order[i], h.order[j]
+	if h.nodes[a].weight != h.nodes[b].weight {
+		return h.nodes[a].weight < h.nodes[b].weight
+	}
+	return a < b
+}
+func (h *nodeHeap) Swap(i, j int) { h.order[i], h.order[j] = h.order[j], h.order[i] }
+func (h *nodeHeap) Push(x any)    { h.order = append(h.order, x.(int)) }
+func (h *nodeHeap) Pop() any {
+	x := h.order[len(h.order)-1]
+	h.order = h.order[:len(h.order)-1]
+	return x
+}
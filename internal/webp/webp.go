@@ -0,0 +1,141 @@
+// Package webp encodes images as lossless WebP (VP8L). It favours a small,
+// obviously correct encoder over compression ratio: pixels are Huffman-coded
+// as literals after the subtract-green transform, without backward
+// references, a color cache or the predictor transforms.
+package webp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+)
+
+// maxDimension is the largest width or height VP8L can describe (14 bits)
+const maxDimension = 1 << 14
+
+// Alphabet sizes of the five prefix codes, without a color cache
+const (
+	greenAlphabet    = 256 + 24
+	literalAlphabet  = 256
+	distanceAlphabet = 40
+)
+
+// transformSubtractGreen is the VP8L transform type that stores red and blue
+// as differences from green
+const transformSubtractGreen = 2
+
+// Encode writes m to w as a lossless WebP
+func Encode(w io.Writer, m image.Image) error {
+	bounds := m.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width < 1 || height < 1 || width > maxDimension || height > maxDimension {
+		return fmt.Errorf("webp: can't encode a %dx%d image", width, height)
+	}
+
+	nrgba, ok := m.(*image.NRGBA)
+	if !ok || nrgba.Stride != 4*width {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, width, height))
+		draw.Draw(nrgba, nrgba.Bounds(), m, bounds.Min, draw.Src)
+	}
+
+	// Subtract green, then count symbols for each code
+	pixels := make([]byte, 0, 4*width*height)
+	green := make([]int, greenAlphabet)
+	red := make([]int, literalAlphabet)
+	blue := make([]int, literalAlphabet)
+	alpha := make([]int, literalAlphabet)
+	hasAlpha := false
+	for i := 0; i < width*height*4; i += 4 {
+		r, g, b, a := nrgba.Pix[i], nrgba.Pix[i+1], nrgba.Pix[i+2], nrgba.Pix[i+3]
+		r, b = r-g, b-g
+		pixels = append(pixels, g, r, b, a)
+		green[g]++
+		red[r]++
+		blue[b]++
+		alpha[a]++
+		hasAlpha = hasAlpha || a != 0xff
+	}
+	codes := []*prefixCode{
+		newPrefixCode(green, 15),
+		newPrefixCode(red, 15),
+		newPrefixCode(blue, 15),
+		newPrefixCode(alpha, 15),
+		newPrefixCode(make([]int, distanceAlphabet), 15),
+	}
+
+	bw := &bitWriter{}
+	bw.writeBits(0x2f, 8) // Signature
+	bw.writeBits(uint32(width-1), 14)
+	bw.writeBits(uint32(height-1), 14)
+	bw.writeBits(boolBit(hasAlpha), 1)
+	bw.writeBits(0, 3) // Version
+
+	bw.writeBits(1, 1) // A transform follows
+	bw.writeBits(transformSubtractGreen, 2)
+	bw.writeBits(0, 1) // No more transforms
+	bw.writeBits(0, 1) // No color cache
+	bw.writeBits(0, 1) // One prefix code group for the whole image
+	for _, code := range codes {
+		code.writeHeader(bw)
+	}
+	for i := 0; i < len(pixels); i += 4 {
+		for c := 0; c < 4; c++ {
+			codes[c].write(bw, int(pixels[i+c]))
+		}
+	}
+	data := bw.bytes()
+
+	// RIFF container with the single VP8L chunk, padded to an even size
+	pad := len(data) & 1
+	header := make([]byte, 20)
+	copy(header, "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(4+8+len(data)+pad))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if pad == 1 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+func boolBit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// bitWriter packs values least significant bit first, as VP8L reads them
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	nAcc uint
+}
+
+func (bw *bitWriter) writeBits(v uint32, n uint) {
+	bw.acc |= uint64(v) << bw.nAcc
+	bw.nAcc += n
+	for bw.nAcc >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.nAcc -= 8
+	}
+}
+
+// bytes flushes any partial byte and returns everything written
+func (bw *bitWriter) bytes() []byte {
+	if bw.nAcc > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.nAcc = 0, 0
+	}
+	return bw.buf
+}
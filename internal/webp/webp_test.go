@@ -0,0 +1,87 @@
+package webp_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/webp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xwebp "golang.org/x/image/webp"
+)
+
+func roundTrip(t *testing.T, img image.Image) *image.NRGBA {
+	var buf bytes.Buffer
+	require.NoError(t, webp.Encode(&buf, img))
+	assert.Zero(t, buf.Len()%2, "RIFF chunks are padded to an even size")
+
+	decoded, err := xwebp.Decode(&buf)
+	require.NoError(t, err)
+	nrgba, ok := decoded.(*image.NRGBA)
+	require.True(t, ok, "lossless WebP decodes to NRGBA, got %T", decoded)
+	return nrgba
+}
+
+func TestEncodeGradient(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 37, 21))
+	for y := 0; y < 21; y++ {
+		for x := 0; x < 37; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 7), uint8(y * 12), uint8(x * y), 255})
+		}
+	}
+	assert.Equal(t, img.Pix, roundTrip(t, img).Pix)
+}
+
+func TestEncodeNoise(t *testing.T) {
+	// Random data exercises every symbol and long codes
+	rng := rand.New(rand.NewSource(1))
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 48))
+	rng.Read(img.Pix)
+	assert.Equal(t, img.Pix, roundTrip(t, img).Pix)
+}
+
+func TestEncodeSkewed(t *testing.T) {
+	// Fibonacci-like counts force the code length limit
+	img := image.NewNRGBA(image.Rect(0, 0, 1000, 10))
+	i, weight := 0, 1
+	for v := 0; i < len(img.Pix)/4; v++ {
+		for n := 0; n < weight && i < len(img.Pix)/4; n++ {
+			img.Pix[4*i] = uint8(v)
+			img.Pix[4*i+3] = 255
+			i++
+		}
+		weight += weight / 2
+	}
+	assert.Equal(t, img.Pix, roundTrip(t, img).Pix)
+}
+
+func TestEncodeSmallAndSubImage(t *testing.T) {
+	got := roundTrip(t, &image.NRGBA{Pix: make([]byte, 4), Stride: 4, Rect: image.Rect(0, 0, 1, 1)})
+	assert.Equal(t, []byte{0, 0, 0, 0}, got.Pix)
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = uint8(i)
+	}
+	sub := img.SubImage(image.Rect(2, 3, 7, 6))
+	got = roundTrip(t, sub)
+	assert.Equal(t, image.Rect(0, 0, 5, 3), got.Bounds())
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 5; x++ {
+			assert.Equal(t, color.NRGBAModel.Convert(sub.At(x+2, y+3)), got.At(x, y))
+		}
+	}
+
+	uniform := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	for i := 0; i < len(uniform.Pix); i += 4 {
+		copy(uniform.Pix[i:], []byte{10, 20, 30, 128})
+	}
+	assert.Equal(t, uniform.Pix, roundTrip(t, uniform).Pix)
+}
+
+func TestEncodeRejectsEmpty(t *testing.T) {
+	assert.Error(t, webp.Encode(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 0, 5))))
+}
@@ -0,0 +1,117 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"log"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/disintegration/imaging"
+)
+
+// maxPreviewFrames caps how many frames an animated preview keeps
+const maxPreviewFrames = 300
+
+// animatedPreviewName is the artifact name of animated previews
+const animatedPreviewName = "animated"
+
+// previewPalette is Plan 9's palette with one entry given up for transparency
+var previewPalette = append(color.Palette{color.Transparent}, palette.Plan9[:255]...)
+
+// saveAnimatedPreview writes a scaled-down copy of an animated GIF. Frames
+// are composited first, so each preview frame is a full picture. It returns
+// nil for single-frame GIFs and on failure, which only costs the preview.
+func (ip *ImageProcessor) saveAnimatedPreview(ctx context.Context, fileID string) *database.Artifact {
+	r, err := ip.storage.ReadFile(fileID)
+	if err != nil {
+		log.Printf("Failed to read animation: %v", err)
+		return nil
+	}
+	defer r.Close()
+
+	anim, err := gif.DecodeAll(&ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		log.Printf("Failed to decode animation: %v", err)
+		return nil
+	}
+	if len(anim.Image) < 2 {
+		return nil
+	}
+
+	width, height := anim.Config.Width, anim.Config.Height
+	if width == 0 || height == 0 {
+		bounds := anim.Image[0].Bounds()
+		width, height = bounds.Max.X, bounds.Max.Y
+	}
+	outWidth, outHeight := width, height
+	if outWidth > ip.AnimatedPreviewWidth {
+		outWidth = ip.AnimatedPreviewWidth
+		outHeight = max(1, height*outWidth/width)
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	preview := &gif.GIF{
+		LoopCount: anim.LoopCount,
+		Config:    image.Config{Width: outWidth, Height: outHeight},
+	}
+	for i, frame := range anim.Image[:min(len(anim.Image), maxPreviewFrames)] {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		var disposal byte
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous []byte
+		if disposal == gif.DisposalPrevious {
+			previous = append([]byte{}, canvas.Pix...)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		scaled := imaging.Resize(canvas, outWidth, outHeight, imaging.Linear)
+		paletted := image.NewPaletted(scaled.Bounds(), previewPalette)
+		draw.FloydSteinberg.Draw(paletted, scaled.Bounds(), scaled, image.Point{})
+		preview.Image = append(preview.Image, paletted)
+		preview.Delay = append(preview.Delay, anim.Delay[i])
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			copy(canvas.Pix, previous)
+		}
+	}
+
+	previewPath := fmt.Sprintf("%s-preview.gif", fileID)
+	w, err := ip.storage.CreateFile(previewPath)
+	if err != nil {
+		log.Printf("Failed to create animated preview: %v", err)
+		return nil
+	}
+	if err := gif.EncodeAll(w, preview); err != nil {
+		w.Close()
+		ip.storage.DeleteFile(previewPath)
+		log.Printf("Failed to save animated preview: %v", err)
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Failed to save animated preview: %v", err)
+		return nil
+	}
+
+	return &database.Artifact{
+		Name:        animatedPreviewName,
+		Kind:        database.ArtifactPreview,
+		Path:        previewPath,
+		ContentType: "image/gif",
+		Width:       outWidth,
+		Height:      outHeight,
+	}
+}
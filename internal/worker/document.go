@@ -0,0 +1,459 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/disintegration/imaging"
+	"github.com/ledongthuc/pdf"
+)
+
+// JobTypeDocument reads PDFs: page count, document info, text and a preview
+const JobTypeDocument = "document"
+
+// documentPreviewName is the artifact name of document previews
+const documentPreviewName = "preview"
+
+// DocumentProcessor reads a PDF's page count, document info and the plain
+// text of each page, and synthesizes a preview of the first page. It is
+// pure Go and doesn't rasterize: the preview shows the page's text as
+// bars where the glyphs are, and its rectangles, on white paper.
+type DocumentProcessor struct {
+	storage Storage
+
+	// MaxPages is how many pages text is extracted from (default 1000)
+	MaxPages int
+	// MaxTextBytes caps the text kept across all pages (default 1 MiB). It
+	// is stored with the job result and returned by GetFileMetadata.
+	MaxTextBytes int
+	// PreviewWidth is the width of the first-page preview; 0 disables it
+	PreviewWidth int
+}
+
+func NewDocumentProcessor(storage Storage) *DocumentProcessor {
+	return &DocumentProcessor{
+		storage:      storage,
+		MaxPages:     1000,
+		MaxTextBytes: 1 << 20,
+		PreviewWidth: 400,
+	}
+}
+
+// Process reads the PDF. Files the PDF reader can't parse, including
+// encrypted ones, fail permanently.
+func (dp *DocumentProcessor) Process(ctx context.Context, file *database.FileRecord) (*database.JobResult, error) {
+	// The PDF reader needs random access, which storage readers don't offer
+	tmp, size, err := dp.spool(ctx, file.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	return dp.read(ctx, file.ID, tmp, size)
+}
+
+// spool copies the file from storage to a temporary file
+func (dp *DocumentProcessor) spool(ctx context.Context, fileID string) (*os.File, int64, error) {
+	r, err := dp.storage.ReadFile(fileID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("open file: %w", err)
+	}
+	defer r.Close()
+
+	tmp, err := os.CreateTemp("", "document-*.pdf")
+	if err != nil {
+		return nil, 0, fmt.Errorf("create temporary file: %w", err)
+	}
+	size, err := io.Copy(tmp, &ctxReader{ctx: ctx, r: r})
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, 0, fmt.Errorf("read file: %w", err)
+	}
+	return tmp, size, nil
+}
+
+// read parses the PDF. The PDF reader reports malformed files by
+// panicking, so panics are turned into permanent errors.
+func (dp *DocumentProcessor) read(ctx context.Context, fileID string, f io.ReaderAt, size int64) (result *database.JobResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			result, err = nil, Permanent(fmt.Errorf("parse PDF: %v", r))
+		}
+	}()
+
+	reader, err := pdf.NewReader(f, size)
+	if err != nil {
+		return nil, Permanent(fmt.Errorf("parse PDF: %w", err))
+	}
+
+	doc := documentInfo(reader.Trailer().Key("Info"))
+	doc.PageCount = reader.NumPage()
+	if doc.PageCount == 0 {
+		return nil, Permanent(fmt.Errorf("PDF has no pages"))
+	}
+	result = &database.JobResult{Document: doc}
+
+	budget := dp.MaxTextBytes
+	for num := 1; num <= min(doc.PageCount, dp.MaxPages); num++ {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("extract text: %w", err)
+		}
+		page, err := findPage(reader, num)
+		if err != nil {
+			return nil, err
+		}
+		if page.V.IsNull() {
+			break
+		}
+
+		width, height := pageSize(page)
+		text, err := page.GetPlainText(nil)
+		if err != nil {
+			log.Printf("Failed to extract text of page %d of %s: %v", num, fileID, err)
+		}
+		text = cleanText(text)
+		truncated := len(text) > budget
+		if truncated {
+			text = truncateUTF8(text, budget)
+		}
+		budget -= len(text)
+
+		doc.Pages = append(doc.Pages, database.DocumentPage{
+			Number:        num,
+			Width:         width,
+			Height:        height,
+			Text:          text,
+			TextTruncated: truncated,
+		})
+	}
+
+	if dp.PreviewWidth > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("generate preview: %w", err)
+		}
+		page, err := findPage(reader, 1)
+		if err != nil {
+			return nil, err
+		}
+		if artifact := dp.savePreview(fileID, page); artifact != nil {
+			result.Artifacts = append(result.Artifacts, *artifact)
+		}
+	}
+	return result, nil
+}
+
+// maxPageTreeDepth bounds how deep the page tree may nest. The PDF reader
+// follows /Kids and /Parent without guarding against cycles, so deeper trees
+// are treated as malformed.
+const maxPageTreeDepth = 32
+
+// findPage looks up page num (1-based) the way pdf.Reader.Page does, but
+// rejects page trees nested deeper than maxPageTreeDepth, which a cycle
+// always is. It also checks the page's /Parent chain, which the reader
+// walks to find inherited resources. A missing page yields a null page.
+func findPage(reader *pdf.Reader, num int) (pdf.Page, error) {
+	num--
+	node := reader.Trailer().Key("Root").Key("Pages")
+	depth := 0
+Search:
+	for node.Key("Type").Name() == "Pages" {
+		if depth++; depth > maxPageTreeDepth {
+			return pdf.Page{}, Permanent(fmt.Errorf("parse PDF: page tree nested deeper than %d levels", maxPageTreeDepth))
+		}
+		if int(node.Key("Count").Int64()) < num {
+			return pdf.Page{}, nil
+		}
+		kids := node.Key("Kids")
+		for i := 0; i < kids.Len(); i++ {
+			kid := kids.Index(i)
+			switch kid.Key("Type").Name() {
+			case "Pages":
+				count := int(kid.Key("Count").Int64())
+				if num < count {
+					node = kid
+					continue Search
+				}
+				num -= count
+			case "Page":
+				if num == 0 {
+					return pdf.Page{V: kid}, checkParents(kid)
+				}
+				num--
+			}
+		}
+		break
+	}
+	return pdf.Page{}, nil
+}
+
+// checkParents rejects pages whose /Parent chain is longer than the page
+// tree may be deep
+func checkParents(page pdf.Value) error {
+	v := page.Key("Parent")
+	for depth := 0; !v.IsNull(); depth++ {
+		if depth >= maxPageTreeDepth {
+			return Permanent(fmt.Errorf("parse PDF: page /Parent chain longer than %d levels", maxPageTreeDepth))
+		}
+		v = v.Key("Parent")
+	}
+	return nil
+}
+
+// documentInfo reads the document information dictionary
+func documentInfo(info pdf.Value) *database.DocumentMetadata {
+	text := func(key string) string {
+		return cleanText(info.Key(key).Text())
+	}
+	return &database.DocumentMetadata{
+		Title:      text("Title"),
+		Author:     text("Author"),
+		Subject:    text("Subject"),
+		Keywords:   text("Keywords"),
+		Creator:    text("Creator"),
+		Producer:   text("Producer"),
+		CreatedAt:  parsePDFDate(info.Key("CreationDate").Text()),
+		ModifiedAt: parsePDFDate(info.Key("ModDate").Text()),
+	}
+}
+
+// defaultPageSize is US Letter, for pages without a valid MediaBox
+var defaultPageSize = pdf.Rect{Max: pdf.Point{X: 612, Y: 792}}
+
+// mediaBox returns the page's MediaBox, which it may inherit from its
+// ancestors in the page tree
+func mediaBox(page pdf.Page) pdf.Rect {
+	for v, depth := page.V, 0; !v.IsNull() && depth <= maxPageTreeDepth; v, depth = v.Key("Parent"), depth+1 {
+		box := v.Key("MediaBox")
+		if box.Len() != 4 {
+			continue
+		}
+		rect := pdf.Rect{
+			Min: pdf.Point{X: min(box.Index(0).Float64(), box.Index(2).Float64()), Y: min(box.Index(1).Float64(), box.Index(3).Float64())},
+			Max: pdf.Point{X: max(box.Index(0).Float64(), box.Index(2).Float64()), Y: max(box.Index(1).Float64(), box.Index(3).Float64())},
+		}
+		if rect.Max.X-rect.Min.X >= 1 && rect.Max.Y-rect.Min.Y >= 1 {
+			return rect
+		}
+		break
+	}
+	return defaultPageSize
+}
+
+// pageRotation returns how far the page is turned clockwise for display:
+// 0, 90, 180 or 270 degrees
+func pageRotation(page pdf.Page) int {
+	rotate := int(page.V.Key("Rotate").Int64()) % 360
+	if rotate < 0 {
+		rotate += 360
+	}
+	return rotate / 90 * 90
+}
+
+// pageSize returns the page's width and height in points, as displayed
+func pageSize(page pdf.Page) (float64, float64) {
+	box := mediaBox(page)
+	width, height := box.Max.X-box.Min.X, box.Max.Y-box.Min.Y
+	if pageRotation(page)%180 != 0 {
+		return height, width
+	}
+	return width, height
+}
+
+// Preview colors
+var (
+	previewPaper = color.NRGBA{255, 255, 255, 255}
+	previewText  = color.NRGBA{70, 70, 70, 255}
+	previewRule  = color.NRGBA{190, 190, 190, 255}
+)
+
+// previewOversample is how much larger than the output the preview is
+// drawn, so scaling it down smooths the edges
+const previewOversample = 3
+
+// maxPreviewAspect caps a preview's height at this many times its width.
+// Taller pages, e.g. a MediaBox of [0 0 1 20000], are cropped rather than
+// drawn on a canvas too large to allocate.
+const maxPreviewAspect = 4
+
+// savePreview writes a PNG sketch of the page; it returns nil on failure,
+// which only costs the preview
+func (dp *DocumentProcessor) savePreview(fileID string, page pdf.Page) *database.Artifact {
+	img, err := sketchPage(page, dp.PreviewWidth)
+	if err != nil {
+		log.Printf("Failed to sketch first page of %s: %v", fileID, err)
+		return nil
+	}
+
+	previewPath := fmt.Sprintf("%s-preview.png", fileID)
+	w, err := dp.storage.CreateFile(previewPath)
+	if err != nil {
+		log.Printf("Failed to create document preview: %v", err)
+		return nil
+	}
+	if err := imaging.Encode(w, img, imaging.PNG); err != nil {
+		w.Close()
+		dp.storage.DeleteFile(previewPath)
+		log.Printf("Failed to save document preview: %v", err)
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Failed to save document preview: %v", err)
+		return nil
+	}
+
+	return &database.Artifact{
+		Name:        documentPreviewName,
+		Kind:        database.ArtifactPreview,
+		Path:        previewPath,
+		ContentType: "image/png",
+		Width:       img.Bounds().Dx(),
+		Height:      img.Bounds().Dy(),
+	}
+}
+
+// sketchPage draws the page width pixels wide: its text as a bar per word
+// (x-height tall, on the baseline) and the outlines of its rectangles
+func sketchPage(page pdf.Page, width int) (img *image.NRGBA, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, fmt.Errorf("read page content: %v", r)
+		}
+	}()
+	content := page.Content()
+
+	box := mediaBox(page)
+	pageW, pageH := box.Max.X-box.Min.X, box.Max.Y-box.Min.Y
+	displayW, _ := pageSize(page)
+	scale := float64(width*previewOversample) / displayW
+	// The displayed width is already right, so this only crops the height
+	limit := float64(width * previewOversample * maxPreviewAspect)
+	canvasW := max(1, int(min(pageW*scale+0.5, limit)))
+	canvasH := max(1, int(min(pageH*scale+0.5, limit)))
+	canvas := imaging.New(canvasW, canvasH, previewPaper)
+
+	// PDF y grows upwards from the box's corner; image y grows downwards
+	toImage := func(x, y float64) (int, int) {
+		return int((x - box.Min.X) * scale), int((box.Max.Y - y) * scale)
+	}
+	fill := func(r image.Rectangle, c color.Color) {
+		draw.Draw(canvas, r.Canon().Intersect(canvas.Bounds()), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	for _, rect := range content.Rect {
+		x0, y0 := toImage(rect.Min.X, rect.Min.Y)
+		x1, y1 := toImage(rect.Max.X, rect.Max.Y)
+		r := image.Rect(x0, y0, x1, y1).Canon()
+		fill(image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+previewOversample), previewRule)
+		fill(image.Rect(r.Min.X, r.Max.Y-previewOversample, r.Max.X, r.Max.Y), previewRule)
+		fill(image.Rect(r.Min.X, r.Min.Y, r.Min.X+previewOversample, r.Max.Y), previewRule)
+		fill(image.Rect(r.Max.X-previewOversample, r.Min.Y, r.Max.X, r.Max.Y), previewRule)
+	}
+	// Without glyph widths (e.g. the standard 14 fonts) the reader doesn't
+	// advance the text position, so glyphs are given half an em each
+	var lastX, penX, penY float64
+	for _, glyph := range content.Text {
+		x, advance := glyph.X, glyph.W
+		if advance <= 0 {
+			if glyph.X == lastX && glyph.Y == penY {
+				x = penX
+			}
+			advance = glyph.FontSize / 2
+		}
+		lastX, penX, penY = glyph.X, x+advance, glyph.Y
+		if strings.TrimSpace(glyph.S) == "" || glyph.FontSize <= 0 {
+			continue
+		}
+
+		x0, baseline := toImage(x, glyph.Y)
+		x1, top := toImage(x+advance, glyph.Y+glyph.FontSize/2)
+		fill(image.Rect(x0, top, max(x1, x0+1), baseline), previewText)
+	}
+
+	// imaging rotates counter-clockwise
+	switch pageRotation(page) {
+	case 90:
+		canvas = imaging.Rotate270(canvas)
+	case 180:
+		canvas = imaging.Rotate180(canvas)
+	case 270:
+		canvas = imaging.Rotate90(canvas)
+	}
+	return imaging.Resize(canvas, width, 0, imaging.Box), nil
+}
+
+// cleanText trims s and makes it safe to store as JSONB text: valid UTF-8
+// without NUL characters
+func cleanText(s string) string {
+	s = strings.ToValidUTF8(s, "�")
+	s = strings.ReplaceAll(s, "\x00", "")
+	return strings.TrimSpace(s)
+}
+
+// truncateUTF8 cuts s to at most n bytes without splitting a character
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// parsePDFDate parses a PDF date, "D:YYYYMMDDHHmmSSOHH'mm'", where every
+// part after the year is optional. It returns nil if s isn't one.
+func parsePDFDate(s string) *time.Time {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	digits := len(s) - len(strings.TrimLeft(s, "0123456789"))
+	if digits < 4 {
+		return nil
+	}
+
+	// Year, month, day, hour, minute, second
+	fields := [6]int{0, 1, 1, 0, 0, 0}
+	widths := [6]int{4, 2, 2, 2, 2, 2}
+	pos := 0
+	for i, width := range widths {
+		if pos+width > digits {
+			break
+		}
+		fields[i], _ = strconv.Atoi(s[pos : pos+width])
+		pos += width
+	}
+	if fields[1] < 1 || fields[1] > 12 || fields[2] < 1 || fields[2] > 31 ||
+		fields[3] > 23 || fields[4] > 59 || fields[5] > 59 {
+		return nil
+	}
+
+	// Time zone: Z, or +/- then HH'mm'; none means UTC
+	loc := time.UTC
+	if rest := s[digits:]; rest != "" && (rest[0] == '+' || rest[0] == '-') {
+		offset := strings.ReplaceAll(rest[1:], "'", "")
+		hours, _ := strconv.Atoi(offset[:min(2, len(offset))])
+		var minutes int
+		if len(offset) >= 4 {
+			minutes, _ = strconv.Atoi(offset[2:4])
+		}
+		seconds := hours*3600 + minutes*60
+		if rest[0] == '-' {
+			seconds = -seconds
+		}
+		loc = time.FixedZone("", seconds)
+	}
+
+	t := time.Date(fields[0], time.Month(fields[1]), fields[2], fields[3], fields[4], fields[5], 0, loc)
+	return &t
+}
@@ -0,0 +1,203 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"testing"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testPDF builds a PDF with a page per content stream, sharing a US Letter
+// MediaBox and Helvetica as /F1. Object 3 is the font, pages start at 4.
+func testPDF(info string, pages ...string) []byte {
+	var objects []string
+	kids := ""
+	for i := range pages {
+		kids += fmt.Sprintf("%d 0 R ", 4+2*i)
+	}
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d /MediaBox [0 0 612 792] >>", kids, len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	)
+	for i, content := range pages {
+		extra := ""
+		if i == 1 {
+			extra = "/Rotate 90"
+		}
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R %s >>", 5+2*i, extra),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects = append(objects, info)
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n",
+		len(objects)+1, len(objects), xref)
+	return buf.Bytes()
+}
+
+func processDocument(t *testing.T, data []byte, configure func(*worker.DocumentProcessor)) (*database.JobResult, *storage.FilesystemStorage, error) {
+	store := storage.NewFilesystemStorage(t.TempDir())
+	w, err := store.CreateFile("doc")
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	processor := worker.NewDocumentProcessor(store)
+	if configure != nil {
+		configure(processor)
+	}
+	result, err := processor.Process(context.Background(),
+		&database.FileRecord{ID: "doc", ContentType: "application/pdf"})
+	return result, store, err
+}
+
+func TestDocumentProcessor(t *testing.T) {
+	data := testPDF(
+		"<< /Title (Quarterly report) /Author (Ada) /Producer (testPDF) /CreationDate (D:20240102030405+01'00') /ModDate (D:2024) >>",
+		"BT /F1 24 Tf 72 700 Td (Hello PDF) Tj ET\n0 0 0 RG 72 100 468 300 re S",
+		"BT /F1 12 Tf 72 500 Td (Second page) Tj ET",
+	)
+	result, store, err := processDocument(t, data, nil)
+	require.NoError(t, err)
+
+	doc := result.Document
+	require.NotNil(t, doc)
+	assert.Equal(t, 2, doc.PageCount)
+	assert.Equal(t, "Quarterly report", doc.Title)
+	assert.Equal(t, "Ada", doc.Author)
+	assert.Equal(t, "testPDF", doc.Producer)
+	require.NotNil(t, doc.CreatedAt)
+	assert.True(t, time.Date(2024, 1, 2, 2, 4, 5, 0, time.UTC).Equal(*doc.CreatedAt), doc.CreatedAt)
+	require.NotNil(t, doc.ModifiedAt)
+	assert.True(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Equal(*doc.ModifiedAt))
+
+	require.Len(t, doc.Pages, 2)
+	assert.Equal(t, database.DocumentPage{Number: 1, Width: 612, Height: 792, Text: "Hello PDF"}, doc.Pages[0])
+	// Rotated pages report their size as displayed
+	assert.Equal(t, database.DocumentPage{Number: 2, Width: 792, Height: 612, Text: "Second page"}, doc.Pages[1])
+
+	preview := result.Artifact("preview")
+	require.NotNil(t, preview)
+	assert.Equal(t, database.ArtifactPreview, preview.Kind)
+	assert.Equal(t, "image/png", preview.ContentType)
+	r, err := store.ReadFile(preview.Path)
+	require.NoError(t, err)
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 400, 518), img.Bounds())
+	assert.Equal(t, []int{400, 518}, []int{preview.Width, preview.Height})
+
+	// The title is sketched near the top left, the page below it is blank
+	dark := func(x0, y0, x1, y1 int) int {
+		n := 0
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				if r, _, _, _ := img.At(x, y).RGBA(); r < 0x8000 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+	assert.Greater(t, dark(40, 50, 160, 70), 100)
+	assert.Zero(t, dark(200, 150, 400, 250))
+}
+
+func TestDocumentProcessorLimits(t *testing.T) {
+	data := testPDF("<< >>",
+		"BT /F1 12 Tf 72 700 Td (Caf\xe9 au lait) Tj ET",
+		"BT /F1 12 Tf 72 700 Td (More text) Tj ET",
+	)
+	result, _, err := processDocument(t, data, func(dp *worker.DocumentProcessor) {
+		dp.MaxPages = 1
+		dp.MaxTextBytes = 4
+		dp.PreviewWidth = 0
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, result.Document.PageCount)
+	assert.Empty(t, result.Document.Title)
+	assert.Nil(t, result.Document.CreatedAt)
+	require.Len(t, result.Document.Pages, 1)
+	assert.True(t, result.Document.Pages[0].TextTruncated)
+	assert.Equal(t, "Caf", result.Document.Pages[0].Text, "cut before the two-byte é")
+	assert.Empty(t, result.Artifacts)
+}
+
+func TestDocumentProcessorExtremeMediaBox(t *testing.T) {
+	// Same length as the MediaBox testPDF writes, so the xref stays valid
+	for _, box := range []string{"[0 0 1 20000]", "[0 0 20000 1]"} {
+		data := bytes.Replace(testPDF("<< >>",
+			"BT /F1 12 Tf 0 10 Td (Tall) Tj ET",
+			"BT /F1 12 Tf 0 0 Td (Rotated) Tj ET",
+		), []byte("[0 0 612 792]"), []byte(box), 1)
+		result, _, err := processDocument(t, data, nil)
+		require.NoError(t, err, box)
+
+		require.Len(t, result.Document.Pages, 2, box)
+		preview := result.Artifact("preview")
+		require.NotNil(t, preview, box)
+		assert.Equal(t, 400, preview.Width, box)
+		assert.LessOrEqual(t, preview.Height, 1600, box)
+	}
+}
+
+func TestDocumentProcessorPageTreeCycle(t *testing.T) {
+	data := testPDF("<< >>",
+		"BT /F1 12 Tf 0 0 Td (One) Tj ET",
+		"BT /F1 12 Tf 0 0 Td (Two) Tj ET",
+	)
+	// Same length replacements, so the xref stays valid
+	for name, cyclic := range map[string][]byte{
+		"pages lists itself": bytes.Replace(data, []byte("/Kids [4 0 R "), []byte("/Kids [2 0 R "), 1),
+		"page is own parent": bytes.Replace(data, []byte("/Parent 2 0 R"), []byte("/Parent 4 0 R"), 1),
+	} {
+		done := make(chan error, 1)
+		go func() {
+			_, _, err := processDocument(t, cyclic, nil)
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			require.Error(t, err, name)
+			assert.True(t, worker.IsPermanent(err), name)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: processing did not finish", name)
+		}
+	}
+}
+
+func TestDocumentProcessorMalformedIsPermanent(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"not a pdf": []byte("just some text"),
+		"truncated": testPDF("<< >>", "BT ET")[:200],
+	} {
+		_, _, err := processDocument(t, data, nil)
+		require.Error(t, err, name)
+		assert.True(t, worker.IsPermanent(err), name)
+	}
+}
@@ -0,0 +1,318 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"image"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// metadataPrefixSize is how much of a file is kept for EXIF/XMP parsing.
+// Both live in JPEG APP1 segments (64 KiB max each), or PNG/WebP chunks
+// before the image data, near the start.
+const metadataPrefixSize = 256 << 10
+
+// prefixBuffer keeps the first max bytes written to it and discards the rest
+type prefixBuffer struct {
+	bytes.Buffer
+	max int
+}
+
+func (b *prefixBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); room > 0 {
+		b.Buffer.Write(p[:min(room, len(p))])
+	}
+	return len(p), nil
+}
+
+// extractImageMetadata reads EXIF and XMP from the start of an image file.
+// It returns nil if the file has neither.
+func extractImageMetadata(prefix []byte) *database.ImageMetadata {
+	meta := &database.ImageMetadata{}
+	found := false
+
+	if x, err := exif.Decode(bytes.NewReader(exifData(prefix))); err == nil {
+		found = true
+		readExif(x, meta)
+	}
+	if xmp := extractXMP(prefix); len(xmp) > 0 {
+		found = true
+		meta.XMP = xmp
+	}
+
+	if !found {
+		return nil
+	}
+	return meta
+}
+
+// exifData returns the EXIF block of a PNG (eXIf chunk) or WebP (EXIF
+// chunk); other formats are handed to the EXIF decoder as they are
+func exifData(prefix []byte) []byte {
+	var (
+		chunks     []byte
+		headerSize int
+		order      binary.ByteOrder
+		exifType   string
+	)
+	switch {
+	case bytes.HasPrefix(prefix, []byte("\x89PNG\r\n\x1a\n")):
+		// length, type, data, CRC
+		chunks, headerSize, order, exifType = prefix[8:], 8, binary.BigEndian, "eXIf"
+	case len(prefix) >= 12 && string(prefix[:4]) == "RIFF" && string(prefix[8:12]) == "WEBP":
+		// type, length, data, padding
+		chunks, headerSize, order, exifType = prefix[12:], 8, binary.LittleEndian, "EXIF"
+	default:
+		return prefix
+	}
+
+	for len(chunks) >= headerSize {
+		var chunkType string
+		var size int
+		if exifType == "eXIf" {
+			size, chunkType = int(order.Uint32(chunks)), string(chunks[4:8])
+		} else {
+			chunkType, size = string(chunks[:4]), int(order.Uint32(chunks[4:]))
+		}
+		if size < 0 || size > len(chunks)-headerSize {
+			break
+		}
+		if chunkType == exifType {
+			return chunks[headerSize : headerSize+size]
+		}
+
+		next := headerSize + size
+		if exifType == "eXIf" {
+			next += 4 // CRC
+		} else {
+			next += size & 1 // Padding
+		}
+		if next > len(chunks) {
+			break
+		}
+		chunks = chunks[next:]
+	}
+	return nil
+}
+
+func readExif(x *exif.Exif, meta *database.ImageMetadata) {
+	str := func(name exif.FieldName) string {
+		tag, err := x.Get(name)
+		if err != nil {
+			return ""
+		}
+		s, err := tag.StringVal()
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	}
+	float := func(name exif.FieldName) float64 {
+		tag, err := x.Get(name)
+		if err != nil {
+			return 0
+		}
+		r, err := tag.Rat(0)
+		if err != nil {
+			return 0
+		}
+		f, _ := r.Float64()
+		return f
+	}
+	integer := func(name exif.FieldName) int {
+		tag, err := x.Get(name)
+		if err != nil {
+			return 0
+		}
+		n, err := tag.Int(0)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+
+	meta.CameraMake = str(exif.Make)
+	meta.CameraModel = str(exif.Model)
+	meta.LensModel = str(exif.LensModel)
+	meta.Software = str(exif.Software)
+	meta.Orientation = integer(exif.Orientation)
+	meta.ISO = integer(exif.ISOSpeedRatings)
+	meta.FNumber = float(exif.FNumber)
+	meta.FocalLength = float(exif.FocalLength)
+
+	if tag, err := x.Get(exif.ExposureTime); err == nil {
+		if num, den, err := tag.Rat2(0); err == nil && den != 0 {
+			meta.ExposureTime = formatExposure(num, den)
+		}
+	}
+
+	if t, err := x.DateTime(); err == nil {
+		// Without an offset in the file, goexif uses the server's zone; take it as UTC instead
+		if t.Location() == time.Local {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+		}
+		meta.CapturedAt = &t
+	}
+
+	if lat, long, err := x.LatLong(); err == nil {
+		gps := &database.GPSPosition{Latitude: lat, Longitude: long}
+		if alt := float(exif.GPSAltitude); alt != 0 {
+			// Ref 1 means below sea level
+			if integer(exif.GPSAltitudeRef) == 1 {
+				alt = -alt
+			}
+			gps.Altitude = &alt
+		}
+		meta.GPS = gps
+	}
+}
+
+// formatExposure renders an exposure time as photographers write it, e.g. "1/250" or "2"
+func formatExposure(num, den int64) string {
+	switch {
+	case num%den == 0:
+		return strconv.FormatInt(num/den, 10)
+	case den%num == 0:
+		return "1/" + strconv.FormatInt(den/num, 10)
+	}
+	return strconv.FormatFloat(float64(num)/float64(den), 'g', 3, 64)
+}
+
+// xmpNamespaces maps the XMP namespaces we read to their usual prefixes
+var xmpNamespaces = map[string]string{
+	"http://purl.org/dc/elements/1.1/":            "dc",
+	"http://ns.adobe.com/xap/1.0/":                "xmp",
+	"http://ns.adobe.com/photoshop/1.0/":          "photoshop",
+	"http://ns.adobe.com/lightroom/1.0/":          "lr",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/": "Iptc4xmpCore",
+}
+
+// xmpProperties are the XMP properties we keep, by "prefix:name"
+var xmpProperties = map[string]bool{
+	"dc:title":               true,
+	"dc:description":         true,
+	"dc:creator":             true,
+	"dc:rights":              true,
+	"dc:subject":             true,
+	"xmp:CreateDate":         true,
+	"xmp:ModifyDate":         true,
+	"xmp:CreatorTool":        true,
+	"xmp:Rating":             true,
+	"xmp:Label":              true,
+	"photoshop:City":         true,
+	"photoshop:State":        true,
+	"photoshop:Country":      true,
+	"photoshop:Headline":     true,
+	"lr:hierarchicalSubject": true,
+	"Iptc4xmpCore:Location":  true,
+}
+
+// extractXMP returns selected XMP properties from an embedded xmpmeta
+// packet. Properties may be attributes of rdf:Description or elements; list
+// values (rdf:Bag/Seq/Alt) are joined with ", ".
+func extractXMP(data []byte) map[string]string {
+	start := bytes.Index(data, []byte("<x:xmpmeta"))
+	if start < 0 {
+		return nil
+	}
+	end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+	if end < 0 {
+		return nil
+	}
+	packet := data[start : start+end+len("</x:xmpmeta>")]
+
+	props := make(map[string]string)
+	add := func(name xml.Name, value string) {
+		prefix, ok := xmpNamespaces[name.Space]
+		if !ok {
+			return
+		}
+		key := prefix + ":" + name.Local
+		value = strings.TrimSpace(value)
+		if !xmpProperties[key] || value == "" {
+			return
+		}
+		if existing, ok := props[key]; ok {
+			value = existing + ", " + value
+		}
+		props[key] = value
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	var (
+		current *xml.Name // Property element we're inside
+		text    strings.Builder
+	)
+	for {
+		tok, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "Description" {
+				for _, attr := range t.Attr {
+					add(attr.Name, attr.Value)
+				}
+				continue
+			}
+			if _, ok := xmpNamespaces[t.Name.Space]; ok && current == nil {
+				name := t.Name
+				current = &name
+				text.Reset()
+			}
+			// rdf:li items of a list each become a value
+			if t.Name.Local == "li" {
+				text.Reset()
+			}
+		case xml.CharData:
+			if current != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if current == nil {
+				continue
+			}
+			if t.Name.Local == "li" {
+				add(*current, text.String())
+				text.Reset()
+			} else if t.Name == *current {
+				add(*current, text.String())
+				current = nil
+			}
+		}
+	}
+
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
+
+// orient applies an EXIF orientation tag so the image displays upright
+func orient(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
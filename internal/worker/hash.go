@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/imagehash"
+)
+
+// JobTypeImageHash computes perceptual hashes and a BlurHash of images
+const JobTypeImageHash = "image_hash"
+
+// HashProcessor computes an image's perceptual hashes, used to find near
+// duplicates, and its BlurHash placeholder. Images are hashed upright, so
+// a rotated copy with an EXIF orientation still matches.
+type HashProcessor struct {
+	storage Storage
+
+	// MaxPixels is the largest image decoded (default DefaultMaxImagePixels)
+	MaxPixels int64
+}
+
+func NewHashProcessor(storage Storage) *HashProcessor {
+	return &HashProcessor{storage: storage, MaxPixels: DefaultMaxImagePixels}
+}
+
+func (hp *HashProcessor) Process(ctx context.Context, file *database.FileRecord) (*database.JobResult, error) {
+	r, err := hp.storage.ReadFile(file.ID)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer r.Close()
+
+	decoded, err := DecodeImage(ctx, r, hp.MaxPixels)
+	if err != nil {
+		return nil, err
+	}
+	img := decoded.Image
+
+	return &database.JobResult{
+		Hashes: &database.ImageHashes{
+			PHash:    imagehash.PHash(img).String(),
+			DHash:    imagehash.DHash(img).String(),
+			BlurHash: imagehash.BlurHash(img),
+		},
+	}, nil
+}
@@ -0,0 +1,83 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/imagehash"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/disintegration/imaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func hashImage(t *testing.T, img image.Image, segments ...[]byte) *database.ImageHashes {
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}))
+	data := append(append([]byte{}, buf.Bytes()[:2]...), bytes.Join(segments, nil)...)
+	data = append(data, buf.Bytes()[2:]...)
+
+	store := storage.NewFilesystemStorage(t.TempDir())
+	w, err := store.CreateFile("img")
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	result, err := worker.NewHashProcessor(store).Process(context.Background(),
+		&database.FileRecord{ID: "img", ContentType: "image/jpeg"})
+	require.NoError(t, err)
+	require.NotNil(t, result.Hashes)
+	return result.Hashes
+}
+
+// photo is a gradient sky with a sun and a hill, smooth like most photos
+func photo() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 320, 200))
+	for y := 0; y < 200; y++ {
+		for x := 0; x < 320; x++ {
+			c := color.NRGBA{uint8(60 + y/2), uint8(120 + y/2), 250, 255}
+			if (x-90)*(x-90)+(y-60)*(y-60) < 30*30 {
+				c = color.NRGBA{250, 200, 40, 255}
+			}
+			if y > 140+x/8 {
+				c = color.NRGBA{40, uint8(100 + x/4), 30, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func hashDistance(t *testing.T, a, b string) int {
+	ha, err := imagehash.ParseHash(a)
+	require.NoError(t, err)
+	hb, err := imagehash.ParseHash(b)
+	require.NoError(t, err)
+	return imagehash.Distance(ha, hb)
+}
+
+func TestHashProcessor(t *testing.T) {
+	original := photo()
+	hashes := hashImage(t, original)
+	assert.Len(t, hashes.BlurHash, 28)
+
+	// A smaller copy, and one stored sideways with an EXIF orientation,
+	// are near duplicates
+	small := hashImage(t, imaging.Resize(original, 150, 0, imaging.Lanczos))
+	assert.LessOrEqual(t, hashDistance(t, hashes.PHash, small.PHash), 4)
+	assert.LessOrEqual(t, hashDistance(t, hashes.DHash, small.DHash), 4)
+
+	sideways := hashImage(t, imaging.Rotate90(original), exifSegment(6, "X"))
+	assert.LessOrEqual(t, hashDistance(t, hashes.PHash, sideways.PHash), 4)
+	assert.Equal(t, hashes.BlurHash[:1], sideways.BlurHash[:1], "hashed landscape")
+
+	other := hashImage(t, imaging.FlipH(original))
+	assert.Greater(t, hashDistance(t, hashes.PHash, other.PHash), 8)
+}
@@ -1,74 +1,166 @@
 package worker
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
+	_ "image/gif"
 	_ "image/jpeg"
 	_ "image/png"
-	"log"
-	"os"
-	"path/filepath"
+	"io"
 
-	"github.com/disintegration/imaging"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
 )
 
+// ImageProcessor reads JPEG, PNG, GIF, WebP, BMP and TIFF images. Animated
+// GIFs are thumbnailed from their first frame.
 type ImageProcessor struct {
-	storagePath string
+	storage Storage
+
+	// Presets are the thumbnails made for every image
+	Presets []ThumbnailPreset
+
+	// AnimatedPreviewWidth is the maximum width of the animated GIF preview
+	// made for animated GIFs; 0 disables previews
+	AnimatedPreviewWidth int
+
+	// MaxPixels is the largest image decoded (default DefaultMaxImagePixels)
+	MaxPixels int64
 }
 
-func NewImageProcessor(storagePath string) *ImageProcessor {
-	return &ImageProcessor{storagePath: storagePath}
+func NewImageProcessor(storage Storage) *ImageProcessor {
+	return &ImageProcessor{
+		storage:              storage,
+		Presets:              DefaultThumbnailPresets(),
+		AnimatedPreviewWidth: 400,
+		MaxPixels:            DefaultMaxImagePixels,
+	}
 }
 
-func (ip *ImageProcessor) ProcessImage(ctx context.Context, fileID, contentType string) (
-	thumbSmall, thumbMed, thumbLarge string,
-	width, height int,
-	err error,
-) {
-	filePath := filepath.Join(ip.storagePath, fileID)
-	file, err := os.Open(filePath)
+// Process decodes the image once, reads its EXIF/XMP metadata and writes a
+// thumbnail per preset, turned upright per the EXIF orientation, next to
+// it. Data that can't be decoded as a supported image fails permanently.
+func (ip *ImageProcessor) Process(ctx context.Context, file *database.FileRecord) (*database.JobResult, error) {
+	r, err := ip.storage.ReadFile(file.ID)
 	if err != nil {
-		return "", "", "", 0, 0, fmt.Errorf("open file: %w", err)
+		return nil, fmt.Errorf("open file: %w", err)
 	}
-	defer file.Close()
+	defer r.Close()
 
-	img, _, err := image.DecodeConfig(file)
+	decoded, err := DecodeImage(ctx, r, ip.MaxPixels)
 	if err != nil {
-		return "", "", "", 0, 0, fmt.Errorf("decode image: %w", err)
+		return nil, err
 	}
+	origImg := decoded.Image
+	result := &database.JobResult{Image: decoded.Metadata}
 
-	width, height = img.Width, img.Height
+	// Dimensions as displayed, i.e. after orientation
+	bounds := origImg.Bounds()
+	result.Width, result.Height = bounds.Dx(), bounds.Dy()
 
-	file.Seek(0, 0)
-	origImg, _, err := image.Decode(file)
-	if err != nil {
-		return "", "", "", 0, 0, fmt.Errorf("decode: %w", err)
+	// Resizing can't be interrupted, so check for timeout/lease loss between presets
+	for _, preset := range ip.Presets {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("generate thumbnails: %w", err)
+		}
+		if artifact := ip.saveThumbnail(file.ID, origImg, preset); artifact != nil {
+			result.Artifacts = append(result.Artifacts, *artifact)
+		}
 	}
 
-	thumbSmall = ip.saveThumbnail(fileID, origImg, 150, "small")
-	thumbMed = ip.saveThumbnail(fileID, origImg, 400, "medium")
-	thumbLarge = ip.saveThumbnail(fileID, origImg, 800, "large")
+	if decoded.Format == "gif" && ip.AnimatedPreviewWidth > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("generate animated preview: %w", err)
+		}
+		if artifact := ip.saveAnimatedPreview(ctx, file.ID); artifact != nil {
+			result.Artifacts = append(result.Artifacts, *artifact)
+		}
+	}
 
-	return thumbSmall, thumbMed, thumbLarge, width, height, nil
+	return result, nil
 }
 
-func (ip *ImageProcessor) saveThumbnail(fileID string, img image.Image, maxWidth int, size string) string {
-	bounds := img.Bounds()
-	origWidth := bounds.Max.X - bounds.Min.X
-	origHeight := bounds.Max.Y - bounds.Min.Y
+// DecodedImage is an image turned upright per its EXIF orientation
+type DecodedImage struct {
+	Image    image.Image
+	Format   string                  // As registered with the image package, e.g. "jpeg"
+	Metadata *database.ImageMetadata // EXIF/XMP metadata, or nil if there is none
+}
+
+// DefaultMaxImagePixels is the default limit on the pixels of an image
+// decoded, 8192x8192. Decoding takes 4-8 bytes per pixel however small the
+// file is.
+const DefaultMaxImagePixels = 8192 * 8192
 
-	newHeight := (origHeight * maxWidth) / origWidth
+// ErrTooManyPixels is returned, wrapped with Permanent, by DecodeImage for
+// images larger than its limit
+var ErrTooManyPixels = errors.New("image has too many pixels")
 
-	thumb := imaging.Resize(img, maxWidth, newHeight, imaging.Lanczos)
+// DecodeImage decodes an image and its EXIF/XMP metadata from r. Images
+// whose header declares more than maxPixels pixels (0 = no limit) are
+// rejected before any are decoded. Errors caused by the data itself (an
+// unsupported format, no pixels, too many) are Permanent; read errors are
+// not.
+func DecodeImage(ctx context.Context, r io.Reader, maxPixels int64) (*DecodedImage, error) {
+	// Storage readers are not guaranteed to be seekable, so keep the start
+	// of the file for the metadata while decoding, and the header for the
+	// decoder after checking the dimensions
+	prefix := &prefixBuffer{max: metadataPrefixSize}
+	src := &ctxReader{ctx: ctx, r: r}
+	tee := io.TeeReader(src, prefix)
+	decodeErr := func(err error) error {
+		// If reading went fine, the data itself is bad and retrying won't help
+		if src.err == nil {
+			return Permanent(fmt.Errorf("decode image: %w", err))
+		}
+		return fmt.Errorf("decode image: %w", err)
+	}
 
-	thumbPath := fmt.Sprintf("%s-thumb-%s.jpg", fileID, size)
-	fullPath := filepath.Join(ip.storagePath, thumbPath)
-	err := imaging.Save(thumb, fullPath)
+	var header bytes.Buffer
+	cfg, _, err := image.DecodeConfig(io.TeeReader(tee, &header))
 	if err != nil {
-		log.Printf("Failed to save thumbnail: %v", err)
-		return ""
+		return nil, decodeErr(err)
+	}
+	if pixels := int64(cfg.Width) * int64(cfg.Height); maxPixels > 0 && pixels > maxPixels {
+		return nil, Permanent(fmt.Errorf("%w: %dx%d is more than %d", ErrTooManyPixels, cfg.Width, cfg.Height, maxPixels))
 	}
 
-	return thumbPath
+	img, format, err := image.Decode(io.MultiReader(&header, tee))
+	if err != nil {
+		return nil, decodeErr(err)
+	}
+
+	decoded := &DecodedImage{Image: img, Format: format, Metadata: extractImageMetadata(prefix.Bytes())}
+	if decoded.Metadata != nil {
+		decoded.Image = orient(img, decoded.Metadata.Orientation)
+	}
+	if bounds := decoded.Image.Bounds(); bounds.Empty() {
+		return nil, Permanent(fmt.Errorf("image is %dx%d", bounds.Dx(), bounds.Dy()))
+	}
+	return decoded, nil
+}
+
+// ctxReader fails reads once ctx is done, so a slow decode stops promptly.
+// It remembers the first read error other than EOF.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+	err error
+}
+
+func (cr *ctxReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		cr.err = err
+		return 0, err
+	}
+	n, err := cr.r.Read(p)
+	if err != nil && err != io.EOF && cr.err == nil {
+		cr.err = err
+	}
+	return n, err
 }
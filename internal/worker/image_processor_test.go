@@ -0,0 +1,250 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+// exifSegment builds an APP1 EXIF segment with Orientation and a short Model
+func exifSegment(orientation uint16, model string) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM\x00\x2a")
+	binary.Write(&tiff, binary.BigEndian, uint32(8)) // IFD0 offset
+	binary.Write(&tiff, binary.BigEndian, uint16(2)) // Entry count
+
+	// Orientation: SHORT, value inline
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0112, 3})
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, []uint16{orientation, 0})
+
+	// Model: ASCII of at most 4 bytes including the NUL, value inline
+	value := make([]byte, 4)
+	copy(value, model)
+	binary.Write(&tiff, binary.BigEndian, []uint16{0x0110, 2})
+	binary.Write(&tiff, binary.BigEndian, uint32(len(model)+1))
+	tiff.Write(value)
+
+	binary.Write(&tiff, binary.BigEndian, uint32(0)) // No next IFD
+
+	return app1(append([]byte("Exif\x00\x00"), tiff.Bytes()...))
+}
+
+func xmpSegment(packet string) []byte {
+	return app1(append([]byte("http://ns.adobe.com/xap/1.0/\x00"), packet...))
+}
+
+func app1(payload []byte) []byte {
+	seg := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(seg[2:], uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// testJPEG encodes a w x h image and inserts segments right after SOI
+func testJPEG(t *testing.T, w, h int, segments ...[]byte) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	require.NoError(t, jpeg.Encode(&buf, img, nil))
+
+	encoded := buf.Bytes()
+	out := append([]byte{}, encoded[:2]...)
+	for _, seg := range segments {
+		out = append(out, seg...)
+	}
+	return append(out, encoded[2:]...)
+}
+
+func processImage(t *testing.T, data []byte) (*database.JobResult, *storage.FilesystemStorage) {
+	store := storage.NewFilesystemStorage(t.TempDir())
+	w, err := store.CreateFile("img")
+	require.NoError(t, err)
+	_, err = w.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	result, err := worker.NewImageProcessor(store).Process(context.Background(),
+		&database.FileRecord{ID: "img", ContentType: "image/jpeg"})
+	require.NoError(t, err)
+	return result, store
+}
+
+func TestImageProcessorOrientsAndExtractsMetadata(t *testing.T) {
+	const packet = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/" xmp:Rating="4" xmp:Secret="x">
+   <dc:subject><rdf:Bag><rdf:li>cats</rdf:li><rdf:li>dogs</rdf:li></rdf:Bag></dc:subject>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+	// Orientation 6 means the stored image must be rotated 90° clockwise
+	data := testJPEG(t, 300, 200, exifSegment(6, "X1"), xmpSegment(packet))
+	result, store := processImage(t, data)
+
+	// Dimensions are reported as displayed
+	assert.Equal(t, 200, result.Width)
+	assert.Equal(t, 300, result.Height)
+
+	require.NotNil(t, result.Image)
+	assert.Equal(t, 6, result.Image.Orientation)
+	assert.Equal(t, "X1", result.Image.CameraModel)
+	assert.Equal(t, map[string]string{
+		"xmp:Rating": "4",
+		"dc:subject": "cats, dogs",
+	}, result.Image.XMP)
+
+	// Thumbnails are upright too
+	small := result.Artifact("small")
+	require.NotNil(t, small)
+	r, err := store.ReadFile(small.Path)
+	require.NoError(t, err)
+	defer r.Close()
+	cfg, err := jpeg.DecodeConfig(r)
+	require.NoError(t, err)
+	assert.Less(t, cfg.Width, cfg.Height)
+	assert.Equal(t, small.Width, cfg.Width)
+}
+
+func TestImageProcessorWithoutMetadata(t *testing.T) {
+	result, _ := processImage(t, testJPEG(t, 300, 200))
+
+	assert.Nil(t, result.Image)
+	assert.Equal(t, 300, result.Width)
+	assert.Equal(t, 200, result.Height)
+}
+
+// lossless1x1WebP is a 1x1 lossless WebP image
+const lossless1x1WebP = "UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA=="
+
+func TestImageProcessorFormats(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 300, 200))
+	encoded := map[string]func() []byte{
+		"gif": func() []byte {
+			var buf bytes.Buffer
+			require.NoError(t, gif.Encode(&buf, img, nil))
+			return buf.Bytes()
+		},
+		"bmp": func() []byte {
+			var buf bytes.Buffer
+			require.NoError(t, bmp.Encode(&buf, img))
+			return buf.Bytes()
+		},
+		"tiff": func() []byte {
+			var buf bytes.Buffer
+			require.NoError(t, tiff.Encode(&buf, img, nil))
+			return buf.Bytes()
+		},
+	}
+	for format, encode := range encoded {
+		t.Run(format, func(t *testing.T) {
+			result, _ := processImage(t, encode())
+			assert.Equal(t, 300, result.Width)
+			assert.Equal(t, 200, result.Height)
+			assert.Len(t, result.Artifacts, 3)
+		})
+	}
+
+	t.Run("webp", func(t *testing.T) {
+		data, err := base64.StdEncoding.DecodeString(lossless1x1WebP)
+		require.NoError(t, err)
+		result, _ := processImage(t, data)
+		assert.Equal(t, 1, result.Width)
+		assert.Equal(t, 1, result.Height)
+	})
+}
+
+func TestImageProcessorAnimatedGIF(t *testing.T) {
+	anim := &gif.GIF{}
+	for _, c := range []color.Color{color.White, color.Black, color.Gray{128}} {
+		frame := image.NewPaletted(image.Rect(0, 0, 600, 300), color.Palette{c})
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	require.NoError(t, gif.EncodeAll(&buf, anim))
+
+	result, store := processImage(t, buf.Bytes())
+	assert.Equal(t, 600, result.Width)
+	assert.Equal(t, 300, result.Height)
+
+	preview := result.Artifact("animated")
+	require.NotNil(t, preview)
+	assert.Equal(t, "image/gif", preview.ContentType)
+	assert.Equal(t, 400, preview.Width)
+	assert.Equal(t, 200, preview.Height)
+
+	r, err := store.ReadFile(preview.Path)
+	require.NoError(t, err)
+	defer r.Close()
+	decoded, err := gif.DecodeAll(r)
+	require.NoError(t, err)
+	assert.Len(t, decoded.Image, 3)
+	assert.Equal(t, []int{10, 10, 10}, decoded.Delay)
+}
+
+func TestImageProcessorUnsupportedFormatIsPermanent(t *testing.T) {
+	store := storage.NewFilesystemStorage(t.TempDir())
+	w, err := store.CreateFile("img")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("\x00\x00\x00\x18ftypheic not really an image"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	_, err = worker.NewImageProcessor(store).Process(context.Background(),
+		&database.FileRecord{ID: "img", ContentType: "image/heic"})
+	require.Error(t, err)
+	assert.True(t, worker.IsPermanent(err))
+
+	// A missing file may be a storage hiccup, so it is retried
+	_, err = worker.NewImageProcessor(store).Process(context.Background(),
+		&database.FileRecord{ID: "missing", ContentType: "image/png"})
+	require.Error(t, err)
+	assert.False(t, worker.IsPermanent(err))
+}
+
+func TestDecodeImageRejectsTooManyPixels(t *testing.T) {
+	// A tiny PNG whose header claims 40000x40000 pixels
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))))
+	data := buf.Bytes()
+	ihdr := data[12:29] // Chunk type and data, which the CRC covers
+	binary.BigEndian.PutUint32(ihdr[4:], 40000)
+	binary.BigEndian.PutUint32(ihdr[8:], 40000)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(ihdr))
+
+	_, err := worker.DecodeImage(context.Background(), bytes.NewReader(data), worker.DefaultMaxImagePixels)
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, worker.ErrTooManyPixels), err)
+	assert.True(t, worker.IsPermanent(err))
+
+	// Images within the limit decode as before, metadata included
+	decoded, err := worker.DecodeImage(context.Background(),
+		bytes.NewReader(testJPEG(t, 4, 2, exifSegment(6, "X"))), 8)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 4), decoded.Image.Bounds())
+	require.NotNil(t, decoded.Metadata)
+	assert.Equal(t, 6, decoded.Metadata.Orientation)
+}
@@ -0,0 +1,118 @@
+package worker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+// defaultWorkerID names this process in job leases, e.g. "pod-7c9f-1-3fa2b1c0"
+func defaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(suffix))
+}
+
+// heartbeat extends job's lease until the returned stop func is called. If
+// the lease is lost (it expired and the job was requeued), it calls cancel so
+// the job stops instead of racing its new owner.
+func (pw *ProcessingWorker) heartbeat(ctx context.Context, job *database.ProcessingJob, cancel context.CancelFunc) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(pw.config.LeaseDuration / 3)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			err := pw.config.DB.HeartbeatJob(ctx, job.ID, pw.config.WorkerID, pw.config.LeaseDuration)
+			if errors.Is(err, database.ErrLeaseLost) {
+				log.Printf("Job %d: lease lost, canceling", job.ID)
+				cancel()
+				return
+			}
+			if err != nil && ctx.Err() == nil {
+				// Transient; if it persists the lease expires and the next beat reports it lost
+				log.Printf("Job %d: heartbeat failed: %v", job.ID, err)
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// reapLeases periodically releases jobs whose lease expired (their worker
+// died or hung), counting the lost attempt. They are retried with their job
+// type's backoff, or dead-lettered if that was their last attempt, so a job
+// that crashes its worker doesn't do so forever. Every replica runs it; the
+// jobs are locked while they are released.
+func (pw *ProcessingWorker) reapLeases(ctx context.Context) {
+	defer pw.wg.Done()
+
+	ticker := time.NewTicker(pw.config.ReapInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pw.done:
+			return
+		case <-ticker.C:
+		}
+
+		jobs, err := pw.config.DB.RequeueExpiredJobs(ctx, 100, pw.retryAt)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Error requeuing expired jobs: %v", err)
+			}
+			continue
+		}
+		for _, job := range jobs {
+			owner := "unknown"
+			if job.WorkerID != nil {
+				owner = *job.WorkerID
+			}
+			if job.Status == "dead_letter" {
+				log.Printf("Job %d for file %s: lease expired on worker %s, failed permanently after %d attempt(s)",
+					job.ID, job.FileID, owner, job.RetryCount)
+				continue
+			}
+			log.Printf("Job %d for file %s: lease expired on worker %s, retrying at %s after %d failed attempt(s)",
+				job.ID, job.FileID, owner, job.RunAfter.Format(time.RFC3339), job.RetryCount)
+		}
+	}
+}
+
+// retryAt schedules the next attempt of a job with job.RetryCount failed
+// attempts per its job type's policy, or returns nil if it has none left
+func (pw *ProcessingWorker) retryAt(job *database.ProcessingJob) *time.Time {
+	policy := pw.retryPolicy(job.JobType)
+	if policy.Exhausted(job.RetryCount) {
+		return nil
+	}
+	t := time.Now().Add(policy.Backoff(job.RetryCount))
+	return &t
+}
@@ -2,115 +2,348 @@ package worker
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"runtime"
+	"sync"
 	"time"
 
 	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/events"
 )
 
+// Storage is the file store the worker reads originals from and writes
+// derived files (thumbnails) to. Satisfied by every storage backend.
+type Storage interface {
+	CreateFile(fileID string) (io.WriteCloser, error)
+	ReadFile(fileID string) (io.ReadCloser, error)
+	DeleteFile(fileID string) error
+}
+
 type WorkerConfig struct {
-	DB              *database.PostgresDB
-	StoragePath     string
-	PollInterval    time.Duration
+	DB      *database.PostgresDB
+	Storage Storage
+	// Events wakes idle workers as soon as a job is inserted (optional)
+	Events *events.Bus
+	// Concurrency is the number of jobs processed in parallel (default: NumCPU)
+	Concurrency int
+	// PollInterval is the fallback check for runnable jobs, e.g. retries
+	// becoming due or notifications lost while the listener reconnects
+	PollInterval time.Duration
+	// ShutdownTimeout is how long Stop waits for in-flight jobs to finish
 	ShutdownTimeout time.Duration
+	// WorkerID identifies this process in job leases (default: host-pid-random)
+	WorkerID string
+	// LeaseDuration is how long a claimed job stays ours without a heartbeat
+	LeaseDuration time.Duration
+	// JobTimeout caps how long a single job may run
+	JobTimeout time.Duration
+	// ReapInterval is how often expired leases are returned to pending
+	ReapInterval time.Duration
+	// Retry is the retry policy for job types missing from RetryPolicies
+	// (default: DefaultRetryPolicy)
+	Retry RetryPolicy
+	// RetryPolicies overrides Retry per job type
+	RetryPolicies map[string]RetryPolicy
+	// Processors runs each job type (default: DefaultRegistry). It should be
+	// the registry the file server queues jobs from.
+	Processors *Registry
 }
 
 type ProcessingWorker struct {
 	config *WorkerConfig
 	done   chan struct{}
+	wake   chan struct{}
+	wg     sync.WaitGroup
+	cancel context.CancelCauseFunc
 }
 
+// errStopped is the cause of jobs being canceled by Stop
+var errStopped = errors.New("processing worker stopped")
+
 func NewProcessingWorker(config *WorkerConfig) *ProcessingWorker {
+	if config.Concurrency <= 0 {
+		config.Concurrency = runtime.NumCPU()
+	}
 	if config.PollInterval == 0 {
 		config.PollInterval = 2 * time.Second
 	}
 	if config.ShutdownTimeout == 0 {
 		config.ShutdownTimeout = 10 * time.Second
 	}
+	if config.WorkerID == "" {
+		config.WorkerID = defaultWorkerID()
+	}
+	if config.LeaseDuration == 0 {
+		config.LeaseDuration = 30 * time.Second
+	}
+	if config.JobTimeout == 0 {
+		config.JobTimeout = 5 * time.Minute
+	}
+	if config.ReapInterval == 0 {
+		config.ReapInterval = config.LeaseDuration
+	}
+	if config.Retry.MaxAttempts == 0 {
+		config.Retry = DefaultRetryPolicy
+	}
+	if config.Processors == nil {
+		config.Processors = DefaultRegistry(config.Storage, nil)
+	}
 	return &ProcessingWorker{
 		config: config,
 		done:   make(chan struct{}),
+		wake:   make(chan struct{}, config.Concurrency),
 	}
 }
 
 func (pw *ProcessingWorker) Start(ctx context.Context) {
-	go pw.run(ctx)
-	log.Println("Processing worker started")
+	// Jobs run on their own context so Stop can let them finish, and cancel
+	// them only once ShutdownTimeout has passed
+	ctx, pw.cancel = context.WithCancelCause(ctx)
+
+	for i := 0; i < pw.config.Concurrency; i++ {
+		pw.wg.Add(1)
+		go pw.runWorker(ctx)
+	}
+
+	pw.wg.Add(1)
+	go pw.dispatch()
+
+	pw.wg.Add(1)
+	go pw.reapLeases(ctx)
+
+	// Pick up any backlog left from before the restart right away
+	pw.wakeAll()
+
+	log.Printf("Processing worker %s started (%d workers)", pw.config.WorkerID, pw.config.Concurrency)
 }
 
+// Stop stops claiming new jobs and waits up to ShutdownTimeout for in-flight
+// jobs to finish; jobs still running after that are canceled
 func (pw *ProcessingWorker) Stop() {
 	close(pw.done)
-	log.Println("Processing worker stopped")
+
+	finished := make(chan struct{})
+	go func() {
+		pw.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		log.Println("Processing worker stopped")
+	case <-time.After(pw.config.ShutdownTimeout):
+		log.Printf("Processing worker: jobs still running after %s, canceling them", pw.config.ShutdownTimeout)
+		pw.cancel(errStopped)
+		<-finished
+		log.Println("Processing worker stopped")
+	}
+	pw.cancel(errStopped)
 }
 
-func (pw *ProcessingWorker) run(ctx context.Context) {
+// dispatch wakes idle workers on job notifications and on every poll tick
+func (pw *ProcessingWorker) dispatch() {
+	defer pw.wg.Done()
+
+	var notifications <-chan events.JobEvent
+	if pw.config.Events != nil {
+		ch, unsubscribe := pw.config.Events.SubscribeAll()
+		defer unsubscribe()
+		notifications = ch
+	}
+
 	ticker := time.NewTicker(pw.config.PollInterval)
 	defer ticker.Stop()
 
-	imageProc := NewImageProcessor(pw.config.StoragePath)
-
 	for {
 		select {
 		case <-pw.done:
 			return
+		case ev := <-notifications:
+			// Only new or requeued jobs can be claimed; Resync sends an empty status
+			if ev.Status != "" && ev.Status != "pending" {
+				continue
+			}
 		case <-ticker.C:
-			pw.processNext(ctx, imageProc)
+		}
+		pw.wakeAll()
+	}
+}
+
+// wakeAll gives every worker a token; busy workers find it after their current job
+func (pw *ProcessingWorker) wakeAll() {
+	for i := 0; i < pw.config.Concurrency; i++ {
+		select {
+		case pw.wake <- struct{}{}:
+		default:
+			return
+		}
+	}
+}
+
+// runWorker claims and processes jobs until none are left, then sleeps until woken
+func (pw *ProcessingWorker) runWorker(ctx context.Context) {
+	defer pw.wg.Done()
+
+	for {
+		select {
+		case <-pw.done:
+			return
+		case <-pw.wake:
+		}
+
+		for {
+			select {
+			case <-pw.done:
+				return
+			default:
+			}
+			if !pw.processNext(ctx) {
+				break
+			}
 		}
 	}
 }
 
-func (pw *ProcessingWorker) processNext(ctx context.Context, imageProc *ImageProcessor) {
-	job, err := pw.config.DB.GetNextPendingJob(ctx)
+// processNext claims and runs one job; it reports whether a job was found
+func (pw *ProcessingWorker) processNext(ctx context.Context) bool {
+	job, err := pw.config.DB.ClaimNextJob(ctx, pw.config.WorkerID, pw.config.LeaseDuration)
 	if err != nil {
-		log.Printf("Error getting next job: %v", err)
-		return
+		if ctx.Err() == nil {
+			log.Printf("Error claiming next job: %v", err)
+		}
+		return false
 	}
 	if job == nil {
-		return
+		return false
 	}
 
-	// Check if exceeded retries
-	if job.RetryCount >= job.MaxRetries {
-		log.Printf("Job %d exceeded max retries, marking as failed", job.ID)
-		pw.config.DB.UpdateJobStatus(ctx, job.ID, "failed", "Max retries exceeded")
-		return
+	log.Printf("Processing %s job %d for file %s", job.JobType, job.ID, job.FileID)
+
+	// Bound the job's runtime, and stop it early if we lose the lease
+	jobCtx, cancel := context.WithTimeout(ctx, pw.config.JobTimeout)
+	defer cancel()
+	stopHeartbeat := pw.heartbeat(jobCtx, job, cancel)
+	defer stopHeartbeat()
+
+	policy := pw.retryPolicy(job.JobType)
+
+	// Jobs requeued by the lease reaper may already have used up their attempts
+	if policy.Exhausted(job.RetryCount) {
+		pw.deadLetter(jobCtx, job)
+		return true
 	}
 
-	log.Printf("Processing job %d for file %s", job.ID, job.FileID)
+	file, err := pw.config.DB.GetFile(jobCtx, job.FileID)
+	if err == sql.ErrNoRows {
+		pw.failAttempt(jobCtx, job, policy, Permanent(errors.New("file not found")))
+		return true
+	}
+	if err != nil {
+		pw.failAttempt(jobCtx, job, policy, fmt.Errorf("load file: %w", err))
+		return true
+	}
 
-	pw.config.DB.UpdateJobStatus(ctx, job.ID, "processing", "")
+	// Only happens if the registry changed since the job was queued
+	processor := pw.config.Processors.Lookup(job.JobType, file.ContentType)
+	if processor == nil {
+		err := fmt.Errorf("no %q processor for %s", job.JobType, file.ContentType)
+		pw.failAttempt(jobCtx, job, policy, Permanent(err))
+		return true
+	}
 
-	file, err := pw.config.DB.GetFile(ctx, job.FileID)
+	result, err := processor.Process(jobCtx, file)
 	if err != nil {
-		log.Printf("File not found: %v", err)
-		pw.config.DB.UpdateJobStatus(ctx, job.ID, "pending", "File not found")
+		log.Printf("Processing job %d failed: %v", job.ID, err)
+		pw.failAttempt(jobCtx, job, policy, err)
+		return true
+	}
+	if pw.completeJob(jobCtx, job, result) {
+		log.Printf("Completed job %d", job.ID)
+	}
+	return true
+}
+
+func (pw *ProcessingWorker) retryPolicy(jobType string) RetryPolicy {
+	if policy, ok := pw.config.RetryPolicies[jobType]; ok {
+		return policy
+	}
+	return pw.config.Retry
+}
+
+// failAttempt schedules a retry with backoff, or dead-letters the job if the
+// error is permanent or this was its last allowed attempt
+func (pw *ProcessingWorker) failAttempt(ctx context.Context, job *database.ProcessingJob, policy RetryPolicy, cause error) {
+	// A job canceled by Stop didn't fail; it goes back to the queue as it was
+	if errors.Is(context.Cause(ctx), errStopped) {
+		pw.releaseJob(ctx, job)
 		return
 	}
 
-	fileType := database.DeriveFileType(file.ContentType)
+	errorMsg := cause.Error()
+	if ctx.Err() == context.DeadlineExceeded {
+		errorMsg = fmt.Sprintf("timed out after %s: %s", pw.config.JobTimeout, errorMsg)
+	}
 
-	switch fileType {
-	case database.FileTypeImage:
-		pw.processImage(ctx, job, imageProc, file)
-	default:
-		log.Printf("Skipping processing for non-image: %s", fileType)
-		pw.config.DB.CompleteJob(ctx, job.ID, "", "", "", 0, 0)
+	// Record the outcome even if the job was canceled by a timeout
+	ctx = context.WithoutCancel(ctx)
+
+	var retryAt *time.Time
+	failures := job.RetryCount + 1
+	if IsPermanent(cause) || policy.Exhausted(failures) {
+		log.Printf("Job %d failed permanently after %d attempt(s): %s", job.ID, failures, errorMsg)
+	} else {
+		t := time.Now().Add(policy.Backoff(failures))
+		retryAt = &t
+		log.Printf("Job %d attempt %d failed, retrying at %s: %s",
+			job.ID, failures, t.Format(time.RFC3339), errorMsg)
+	}
+
+	err := pw.config.DB.FailJobAttempt(ctx, job.ID, pw.config.WorkerID, errorMsg, retryAt)
+	if errors.Is(err, database.ErrLeaseLost) {
+		log.Printf("Job %d: lease lost, leaving it to its new owner", job.ID)
+	} else if err != nil {
+		log.Printf("Failed to update job %d: %v", job.ID, err)
 	}
 }
 
-func (pw *ProcessingWorker) processImage(ctx context.Context, job *database.ProcessingJob, imageProc *ImageProcessor, file *database.FileRecord) {
-	thumbSmall, thumbMed, thumbLarge, width, height, err := imageProc.ProcessImage(ctx, job.FileID, file.ContentType)
-	if err != nil {
-		log.Printf("Image processing failed: %v", err)
-		pw.config.DB.UpdateJobStatus(ctx, job.ID, "pending", err.Error())
-		return
+// releaseJob returns a job to the queue without using up an attempt
+func (pw *ProcessingWorker) releaseJob(ctx context.Context, job *database.ProcessingJob) {
+	log.Printf("Job %d interrupted by shutdown, releasing it", job.ID)
+
+	err := pw.config.DB.ReleaseJob(context.WithoutCancel(ctx), job.ID, pw.config.WorkerID)
+	if err != nil && !errors.Is(err, database.ErrLeaseLost) {
+		log.Printf("Failed to release job %d: %v", job.ID, err)
 	}
+}
+
+// deadLetter gives up on a job without running it, keeping its last error
+func (pw *ProcessingWorker) deadLetter(ctx context.Context, job *database.ProcessingJob) {
+	errorMsg := "out of attempts"
+	if job.ErrorMessage != nil {
+		errorMsg = *job.ErrorMessage
+	}
+	log.Printf("Job %d failed permanently after %d attempt(s): %s", job.ID, job.RetryCount, errorMsg)
 
-	err = pw.config.DB.CompleteJob(ctx, job.ID, thumbSmall, thumbMed, thumbLarge, width, height)
+	err := pw.config.DB.UpdateJobStatus(context.WithoutCancel(ctx), job.ID, pw.config.WorkerID, "dead_letter", errorMsg)
+	if err != nil && !errors.Is(err, database.ErrLeaseLost) {
+		log.Printf("Failed to update job %d: %v", job.ID, err)
+	}
+}
+
+// completeJob stores the result if we still hold the job's lease
+func (pw *ProcessingWorker) completeJob(ctx context.Context, job *database.ProcessingJob, result *database.JobResult) bool {
+	err := pw.config.DB.CompleteJob(context.WithoutCancel(ctx), job.ID, pw.config.WorkerID, result)
+	if errors.Is(err, database.ErrLeaseLost) {
+		log.Printf("Job %d: lease lost, discarding results", job.ID)
+		return false
+	}
 	if err != nil {
 		log.Printf("Failed to save job results: %v", err)
-		return
+		return false
 	}
-
-	log.Printf(" Completed job %d: generated thumbnails", job.ID)
+	return true
 }
@@ -0,0 +1,243 @@
+package worker_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	pbv1 "github.com/PaulBabatuyi/UploadStream-gRPC/gen/fileservice/v1"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestDB(t *testing.T) *database.PostgresDB {
+	dbURL := os.Getenv("UPLOADSTREAM")
+	if dbURL == "" {
+		t.Skip("UPLOADSTREAM env not set")
+	}
+
+	db, err := database.NewPostgresDB(dbURL)
+	require.NoError(t, err)
+	return db
+}
+
+// createJobs saves n small files and queues a processing job for each
+func createJobs(t *testing.T, db *database.PostgresDB, n int) map[int64]bool {
+	ctx := context.Background()
+	owner := database.Owner{UserID: uuid.New().String()}
+
+	jobs := make(map[int64]bool, n)
+	for i := 0; i < n; i++ {
+		fileID := uuid.New().String()
+		err := db.SaveFile(ctx, fileID, owner, &pbv1.FileMetadata{
+			Filename:    "job.txt",
+			ContentType: "text/plain",
+			Size:        1,
+		}, 1, database.Checksum{}, nil, database.QuotaLimits{})
+		require.NoError(t, err)
+
+		jobID, err := db.CreateProcessingJob(ctx, fileID, "thumbnail")
+		require.NoError(t, err)
+		jobs[jobID] = true
+	}
+	return jobs
+}
+
+func TestClaimNextJobConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	created := createJobs(t, db, 20)
+
+	var (
+		mu      sync.Mutex
+		claimed = map[int64]int{}
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := db.ClaimNextJob(ctx, "test-worker", time.Minute)
+				if !assert.NoError(t, err) || job == nil {
+					return
+				}
+				mu.Lock()
+				claimed[job.ID]++
+				mu.Unlock()
+				db.CompleteJob(ctx, job.ID, "test-worker", nil)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every job is claimed by exactly one worker
+	for jobID := range created {
+		assert.Equal(t, 1, claimed[jobID], "job %d", jobID)
+	}
+	for jobID, n := range claimed {
+		assert.Equal(t, 1, n, "job %d claimed more than once", jobID)
+	}
+}
+
+// drainJobs completes anything queued by other tests so the next claim is ours
+func drainJobs(t *testing.T, db *database.PostgresDB) {
+	ctx := context.Background()
+	for {
+		job, err := db.ClaimNextJob(ctx, "drain", time.Minute)
+		require.NoError(t, err)
+		if job == nil {
+			return
+		}
+		db.CompleteJob(ctx, job.ID, "drain", nil)
+	}
+}
+
+func TestExpiredLeaseIsRequeued(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	drainJobs(t, db)
+	created := createJobs(t, db, 1)
+
+	job, err := db.ClaimNextJob(ctx, "dead-worker", time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	require.True(t, created[job.ID])
+
+	time.Sleep(1500 * time.Millisecond)
+
+	var backoffFor []int
+	requeued, err := db.RequeueExpiredJobs(ctx, 100, func(j *database.ProcessingJob) *time.Time {
+		backoffFor = append(backoffFor, j.RetryCount)
+		runAfter := time.Now()
+		return &runAfter
+	})
+	require.NoError(t, err)
+	assert.Contains(t, backoffFor, 1, "the retry is scheduled counting the lost attempt")
+
+	var found *database.ProcessingJob
+	for _, j := range requeued {
+		if j.ID == job.ID {
+			found = j
+		}
+	}
+	require.NotNil(t, found, "expired job was not requeued")
+	assert.Equal(t, "pending", found.Status)
+	assert.Equal(t, 1, found.RetryCount)
+	require.NotNil(t, found.WorkerID)
+	assert.Equal(t, "dead-worker", *found.WorkerID)
+
+	// The old owner can no longer heartbeat or finish the job
+	assert.ErrorIs(t, db.HeartbeatJob(ctx, job.ID, "dead-worker", time.Minute), database.ErrLeaseLost)
+	assert.ErrorIs(t, db.CompleteJob(ctx, job.ID, "dead-worker", nil), database.ErrLeaseLost)
+
+	// A job out of attempts is dead-lettered instead of requeued
+	job, err = db.ClaimNextJob(ctx, "dead-worker", time.Second)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+	require.True(t, created[job.ID])
+	time.Sleep(1500 * time.Millisecond)
+	_, err = db.RequeueExpiredJobs(ctx, 100, func(*database.ProcessingJob) *time.Time { return nil })
+	require.NoError(t, err)
+
+	stored, err := db.GetJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "dead_letter", stored.Status)
+	assert.Equal(t, 2, stored.RetryCount)
+	attempts, err := db.ListJobAttempts(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Len(t, attempts, 2)
+}
+
+func TestFailedJobBacksOffAndDeadLetters(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	drainJobs(t, db)
+	createJobs(t, db, 1)
+
+	job, err := db.ClaimNextJob(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+
+	// A retry in the future isn't claimable yet
+	retryAt := time.Now().Add(time.Hour)
+	require.NoError(t, db.FailJobAttempt(ctx, job.ID, "w1", "boom 1", &retryAt))
+	next, err := db.ClaimNextJob(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, next)
+
+	// Giving up moves the job to dead_letter with its history
+	require.NoError(t, db.FailJobAttempt(ctx, job.ID, "", "boom 2", nil))
+
+	stored, err := db.GetJob(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "dead_letter", stored.Status)
+	assert.Equal(t, 2, stored.RetryCount)
+	require.NotNil(t, stored.ErrorMessage)
+	assert.Equal(t, "boom 2", *stored.ErrorMessage)
+
+	attempts, err := db.ListJobAttempts(ctx, job.ID)
+	require.NoError(t, err)
+	require.Len(t, attempts, 2)
+	assert.Equal(t, "boom 1", attempts[0].ErrorMessage)
+	require.NotNil(t, attempts[0].WorkerID)
+	assert.Equal(t, "w1", *attempts[0].WorkerID)
+
+	dead, err := db.ListDeadLetterJobs(ctx, 0, 100)
+	require.NoError(t, err)
+	assert.Contains(t, jobIDs(dead), job.ID)
+
+	// Requeueing makes it runnable again with fresh attempts
+	require.NoError(t, db.RequeueJob(ctx, job.ID))
+	assert.ErrorIs(t, db.RequeueJob(ctx, job.ID), sql.ErrNoRows)
+
+	next, err = db.ClaimNextJob(ctx, "w2", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, job.ID, next.ID)
+	assert.Equal(t, 0, next.RetryCount)
+}
+
+func TestReleasedJobKeepsItsAttempts(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+
+	drainJobs(t, db)
+	createJobs(t, db, 1)
+
+	job, err := db.ClaimNextJob(ctx, "w1", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, job)
+
+	// Only the lease holder can release the job
+	assert.ErrorIs(t, db.ReleaseJob(ctx, job.ID, "w2"), database.ErrLeaseLost)
+	require.NoError(t, db.ReleaseJob(ctx, job.ID, "w1"))
+	assert.ErrorIs(t, db.ReleaseJob(ctx, job.ID, "w1"), database.ErrLeaseLost)
+
+	// It is claimable right away, with no attempt recorded
+	next, err := db.ClaimNextJob(ctx, "w2", time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, next)
+	assert.Equal(t, job.ID, next.ID)
+	assert.Equal(t, 0, next.RetryCount)
+
+	attempts, err := db.ListJobAttempts(ctx, job.ID)
+	require.NoError(t, err)
+	assert.Empty(t, attempts)
+}
+
+func jobIDs(jobs []*database.ProcessingJob) []int64 {
+	ids := make([]int64, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+	}
+	return ids
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+// Processor derives results (attributes, thumbnails, ...) from an uploaded
+// file. Returning an error wrapped with Permanent dead-letters the job;
+// any other error is retried per the worker's retry policy.
+type Processor interface {
+	Process(ctx context.Context, file *database.FileRecord) (*database.JobResult, error)
+}
+
+// ProcessorFunc adapts a function to Processor
+type ProcessorFunc func(ctx context.Context, file *database.FileRecord) (*database.JobResult, error)
+
+func (f ProcessorFunc) Process(ctx context.Context, file *database.FileRecord) (*database.JobResult, error) {
+	return f(ctx, file)
+}
+
+// JobTypeThumbnail generates image thumbnails
+const JobTypeThumbnail = "thumbnail"
+
+// Registry maps job types to processors. Each upload gets one job per job
+// type that has a processor for its content type. Within a job type,
+// lookups prefer an exact content type ("image/png"), then a wildcard
+// ("image/*"), then the file's FileType, then "*/*".
+type Registry struct {
+	jobTypes map[string]*processors
+}
+
+type processors struct {
+	byContentType map[string]Processor
+	byFileType    map[database.FileType]Processor
+}
+
+func NewRegistry() *Registry {
+	return &Registry{jobTypes: make(map[string]*processors)}
+}
+
+// DefaultRegistry generates thumbnails for images, per presets or
+// DefaultThumbnailPresets if nil, and hashes them. PDFs get a
+// DocumentProcessor, plain text and JSON files a TextProcessor.
+func DefaultRegistry(storage Storage, presets []ThumbnailPreset) *Registry {
+	images := NewImageProcessor(storage)
+	if presets != nil {
+		images.Presets = presets
+	}
+
+	registry := NewRegistry()
+	registry.RegisterFileType(JobTypeThumbnail, database.FileTypeImage, images)
+	registry.RegisterFileType(JobTypeImageHash, database.FileTypeImage, NewHashProcessor(storage))
+	registry.Register(JobTypeDocument, "application/pdf", NewDocumentProcessor(storage))
+	text := NewTextProcessor(storage)
+	registry.Register(JobTypeText, "text/plain", text)
+	registry.Register(JobTypeText, "application/json", text)
+	return registry
+}
+
+func (r *Registry) jobType(jobType string) *processors {
+	ps, ok := r.jobTypes[jobType]
+	if !ok {
+		ps = &processors{
+			byContentType: make(map[string]Processor),
+			byFileType:    make(map[database.FileType]Processor),
+		}
+		r.jobTypes[jobType] = ps
+	}
+	return ps
+}
+
+// Register sets jobType's processor for a content type, for a whole family
+// with a "type/*" pattern, or for every file with "*/*". Registering a
+// pattern again replaces it.
+func (r *Registry) Register(jobType, pattern string, p Processor) {
+	r.jobType(jobType).byContentType[strings.ToLower(pattern)] = p
+}
+
+// RegisterFileType sets jobType's processor for every content type of
+// fileType that has no more specific registration
+func (r *Registry) RegisterFileType(jobType string, fileType database.FileType, p Processor) {
+	r.jobType(jobType).byFileType[fileType] = p
+}
+
+// Lookup returns jobType's processor for contentType, or nil if there is none
+func (r *Registry) Lookup(jobType, contentType string) Processor {
+	ps, ok := r.jobTypes[jobType]
+	if !ok {
+		return nil
+	}
+
+	contentType = strings.ToLower(strings.TrimSpace(contentType))
+	// Ignore parameters such as "; charset=utf-8"
+	if i := strings.IndexByte(contentType, ';'); i >= 0 {
+		contentType = strings.TrimSpace(contentType[:i])
+	}
+
+	if p, ok := ps.byContentType[contentType]; ok {
+		return p
+	}
+	if i := strings.IndexByte(contentType, '/'); i >= 0 {
+		if p, ok := ps.byContentType[contentType[:i]+"/*"]; ok {
+			return p
+		}
+	}
+	if p, ok := ps.byFileType[database.DeriveFileType(contentType)]; ok {
+		return p
+	}
+	return ps.byContentType["*/*"]
+}
+
+// JobTypes returns the job types to queue for an upload of contentType
+func (r *Registry) JobTypes(contentType string) []string {
+	var jobTypes []string
+	for jobType := range r.jobTypes {
+		if r.Lookup(jobType, contentType) != nil {
+			jobTypes = append(jobTypes, jobType)
+		}
+	}
+	slices.Sort(jobTypes)
+	return jobTypes
+}
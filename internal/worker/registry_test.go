@@ -0,0 +1,55 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/stretchr/testify/assert"
+)
+
+// named is a processor whose result identifies it
+func named(name string) worker.Processor {
+	return worker.ProcessorFunc(func(context.Context, *database.FileRecord) (*database.JobResult, error) {
+		return &database.JobResult{Attributes: map[string]string{"processor": name}}, nil
+	})
+}
+
+func lookupName(t *testing.T, registry *worker.Registry, contentType string) string {
+	p := registry.Lookup("test", contentType)
+	if p == nil {
+		return ""
+	}
+	result, err := p.Process(context.Background(), &database.FileRecord{ContentType: contentType})
+	assert.NoError(t, err)
+	return result.Attributes["processor"]
+}
+
+func TestRegistryLookup(t *testing.T) {
+	registry := worker.NewRegistry()
+	registry.RegisterFileType("test", database.FileTypeImage, named("images"))
+	registry.Register("test", "image/*", named("image-wildcard"))
+	registry.Register("test", "image/svg+xml", named("svg"))
+	registry.RegisterFileType("test", database.FileTypeArchive, named("archives"))
+
+	assert.Equal(t, "svg", lookupName(t, registry, "image/svg+xml"))
+	assert.Equal(t, "svg", lookupName(t, registry, "Image/SVG+XML; charset=utf-8"))
+	assert.Equal(t, "image-wildcard", lookupName(t, registry, "image/png"))
+	assert.Equal(t, "archives", lookupName(t, registry, "application/zip"))
+	assert.Equal(t, "", lookupName(t, registry, "text/plain"))
+}
+
+func TestRegistryJobTypes(t *testing.T) {
+	registry := worker.NewRegistry()
+	registry.RegisterFileType("thumbnail", database.FileTypeImage, named("thumbs"))
+	registry.Register("hash", "*/*", named("hash"))
+	registry.Register("hash", "image/*", named("hash"))
+	registry.Register("exif", "image/jpeg", named("exif"))
+
+	assert.Equal(t, []string{"exif", "hash", "thumbnail"}, registry.JobTypes("image/jpeg"))
+	assert.Equal(t, []string{"hash", "thumbnail"}, registry.JobTypes("image/png"))
+	assert.Equal(t, []string{"hash"}, registry.JobTypes("text/plain"))
+	assert.Empty(t, worker.NewRegistry().JobTypes("text/plain"))
+	assert.Nil(t, registry.Lookup("unknown", "image/png"))
+}
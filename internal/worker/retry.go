@@ -0,0 +1,142 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// RetryPolicy decides how often and how soon a failed job is retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of runs before the job is dead-lettered
+	MaxAttempts int
+	// InitialBackoff is the delay before the second attempt
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between attempts
+	MaxBackoff time.Duration
+	// Multiplier grows the delay after each failed attempt (default 2)
+	Multiplier float64
+	// Jitter randomizes each delay by up to this fraction (0-1), so jobs
+	// that failed together don't all retry at the same moment
+	Jitter float64
+}
+
+// DefaultRetryPolicy is used for job types without a policy of their own
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    5,
+	InitialBackoff: 10 * time.Second,
+	MaxBackoff:     30 * time.Minute,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// Backoff returns the delay before the next attempt, after `failures` failed ones
+func (p RetryPolicy) Backoff(failures int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(p.InitialBackoff) * math.Pow(multiplier, float64(max(failures-1, 0)))
+	if p.MaxBackoff > 0 {
+		delay = min(delay, float64(p.MaxBackoff))
+	}
+	if p.Jitter > 0 {
+		delay *= 1 + p.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Exhausted reports whether a job with `failures` failed attempts may not run again
+func (p RetryPolicy) Exhausted(failures int) bool {
+	return failures >= p.MaxAttempts
+}
+
+// Validate checks that the policy allows at least one attempt and that its
+// delays and jitter make sense
+func (p RetryPolicy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return fmt.Errorf("max_attempts must be at least 1")
+	case p.InitialBackoff < 0 || p.MaxBackoff < 0:
+		return fmt.Errorf("backoffs must not be negative")
+	case p.Multiplier < 0:
+		return fmt.Errorf("multiplier must not be negative")
+	case p.Jitter < 0 || p.Jitter > 1:
+		return fmt.Errorf("jitter must be between 0 and 1")
+	}
+	return nil
+}
+
+// ParseRetryPolicies reads a JSON object of retry policies by job type, e.g.
+// {"document": {"max_attempts": 2, "initial_backoff": "1m"}}. Fields left
+// out are taken from base.
+func ParseRetryPolicies(data []byte, base RetryPolicy) (map[string]RetryPolicy, error) {
+	var raw map[string]struct {
+		MaxAttempts    *int     `json:"max_attempts"`
+		InitialBackoff *string  `json:"initial_backoff"`
+		MaxBackoff     *string  `json:"max_backoff"`
+		Multiplier     *float64 `json:"multiplier"`
+		Jitter         *float64 `json:"jitter"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("parse retry policies: %w", err)
+	}
+
+	policies := make(map[string]RetryPolicy, len(raw))
+	for jobType, r := range raw {
+		policy := base
+		if r.MaxAttempts != nil {
+			policy.MaxAttempts = *r.MaxAttempts
+		}
+		for _, d := range []struct {
+			value *string
+			dst   *time.Duration
+		}{
+			{r.InitialBackoff, &policy.InitialBackoff},
+			{r.MaxBackoff, &policy.MaxBackoff},
+		} {
+			if d.value == nil {
+				continue
+			}
+			delay, err := time.ParseDuration(*d.value)
+			if err != nil {
+				return nil, fmt.Errorf("retry policy %q: %w", jobType, err)
+			}
+			*d.dst = delay
+		}
+		if r.Multiplier != nil {
+			policy.Multiplier = *r.Multiplier
+		}
+		if r.Jitter != nil {
+			policy.Jitter = *r.Jitter
+		}
+		if err := policy.Validate(); err != nil {
+			return nil, fmt.Errorf("retry policy %q: %w", jobType, err)
+		}
+		policies[jobType] = policy
+	}
+	return policies, nil
+}
+
+// permanentError marks a failure that retrying can't fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent wraps err so the job is dead-lettered without further attempts
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err, or an error it wraps, came from Permanent
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
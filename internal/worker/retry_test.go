@@ -0,0 +1,67 @@
+package worker_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := worker.RetryPolicy{
+		MaxAttempts:    4,
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Second,
+		Multiplier:     2,
+	}
+
+	assert.Equal(t, time.Second, policy.Backoff(1))
+	assert.Equal(t, 2*time.Second, policy.Backoff(2))
+	assert.Equal(t, 4*time.Second, policy.Backoff(3))
+	assert.Equal(t, 5*time.Second, policy.Backoff(4), "capped at MaxBackoff")
+
+	assert.False(t, policy.Exhausted(3))
+	assert.True(t, policy.Exhausted(4))
+}
+
+func TestRetryPolicyJitter(t *testing.T) {
+	policy := worker.RetryPolicy{
+		MaxAttempts:    3,
+		InitialBackoff: 10 * time.Second,
+		Jitter:         0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(1)
+		assert.GreaterOrEqual(t, delay, 5*time.Second)
+		assert.LessOrEqual(t, delay, 15*time.Second)
+	}
+}
+
+func TestParseRetryPolicies(t *testing.T) {
+	policies, err := worker.ParseRetryPolicies([]byte(`{
+		"document": {"max_attempts": 2, "initial_backoff": "1m"},
+		"thumbnail": {"jitter": 0}
+	}`), worker.DefaultRetryPolicy)
+	require.NoError(t, err)
+
+	document := worker.DefaultRetryPolicy
+	document.MaxAttempts = 2
+	document.InitialBackoff = time.Minute
+	thumbnail := worker.DefaultRetryPolicy
+	thumbnail.Jitter = 0
+	assert.Equal(t, map[string]worker.RetryPolicy{"document": document, "thumbnail": thumbnail}, policies)
+
+	for _, data := range []string{
+		`{"document": {"max_attempts": 0}}`,
+		`{"document": {"initial_backoff": "soon"}}`,
+		`{"document": {"jitter": 2}}`,
+		`{"document": {"attempts": 3}}`,
+		`[]`,
+	} {
+		_, err := worker.ParseRetryPolicies([]byte(data), worker.DefaultRetryPolicy)
+		assert.Error(t, err, data)
+	}
+}
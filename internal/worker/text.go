@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+// JobTypeText extracts the text of plain text and JSON files for search
+const JobTypeText = "text"
+
+// TextProcessor extracts the text of plain text and JSON files, which
+// CompleteJob indexes for SearchFiles. JSON is reduced to its keys and
+// string values so punctuation and numbers don't pollute the index.
+type TextProcessor struct {
+	storage Storage
+
+	// MaxTextBytes caps the text extracted (default
+	// database.MaxSearchTextBytes, all that is indexed)
+	MaxTextBytes int
+	// MaxJSONBytes caps how much of a JSON file is read looking for
+	// strings (default 16 MiB)
+	MaxJSONBytes int64
+}
+
+func NewTextProcessor(storage Storage) *TextProcessor {
+	return &TextProcessor{
+		storage:      storage,
+		MaxTextBytes: database.MaxSearchTextBytes,
+		MaxJSONBytes: 16 << 20,
+	}
+}
+
+// Process extracts the text. Malformed JSON fails permanently; JSON cut
+// short, e.g. by MaxJSONBytes, keeps the strings before the cut.
+func (tp *TextProcessor) Process(ctx context.Context, file *database.FileRecord) (*database.JobResult, error) {
+	r, err := tp.storage.ReadFile(file.ID)
+	if err != nil {
+		return nil, fmt.Errorf("open file: %w", err)
+	}
+	defer r.Close()
+	src := &ctxReader{ctx: ctx, r: r}
+
+	var text string
+	if strings.Contains(strings.ToLower(file.ContentType), "json") {
+		text, err = tp.jsonText(io.LimitReader(src, tp.MaxJSONBytes))
+	} else {
+		text, err = tp.plainText(src)
+	}
+	if src.err != nil {
+		return nil, fmt.Errorf("read file: %w", src.err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &database.JobResult{Text: text}, nil
+}
+
+func (tp *TextProcessor) plainText(r io.Reader) (string, error) {
+	// Read a little past the limit so the cut can back up to a whole character
+	data, err := io.ReadAll(io.LimitReader(r, int64(tp.MaxTextBytes+utf8.UTFMax)))
+	if err != nil {
+		return "", fmt.Errorf("read file: %w", err)
+	}
+	return cleanText(truncateUTF8(string(data), tp.MaxTextBytes)), nil
+}
+
+func (tp *TextProcessor) jsonText(r io.Reader) (string, error) {
+	dec := json.NewDecoder(r)
+	var text strings.Builder
+	for text.Len() < tp.MaxTextBytes {
+		token, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			break // Cut short; keep what was read
+		}
+		if err != nil {
+			return "", Permanent(fmt.Errorf("parse JSON: %w", err))
+		}
+		if s, ok := token.(string); ok && strings.TrimSpace(s) != "" {
+			if text.Len() > 0 {
+				text.WriteByte('\n')
+			}
+			text.WriteString(s)
+		}
+	}
+	return cleanText(truncateUTF8(text.String(), tp.MaxTextBytes)), nil
+}
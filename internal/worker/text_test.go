@@ -0,0 +1,69 @@
+package worker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func extractText(t *testing.T, contentType, data string, configure func(*worker.TextProcessor)) (string, error) {
+	store := storage.NewFilesystemStorage(t.TempDir())
+	w, err := store.CreateFile("text")
+	require.NoError(t, err)
+	_, err = w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	processor := worker.NewTextProcessor(store)
+	if configure != nil {
+		configure(processor)
+	}
+	result, err := processor.Process(context.Background(),
+		&database.FileRecord{ID: "text", ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	return result.Text, nil
+}
+
+func TestTextProcessor(t *testing.T) {
+	text, err := extractText(t, "text/plain", "  Hello\x00 world\n", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello world", text)
+
+	text, err = extractText(t, "text/plain", "Café au lait", func(tp *worker.TextProcessor) {
+		tp.MaxTextBytes = 4
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Caf", text, "cut before the two-byte é")
+}
+
+func TestTextProcessorJSON(t *testing.T) {
+	data := `{"title": "Launch plan", "budget": 1200, "owners": ["ada", "grace"], "draft": true, "notes": " "}`
+	text, err := extractText(t, "application/json; charset=utf-8", data, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "title\nLaunch plan\nbudget\nowners\nada\ngrace\ndraft\nnotes", text)
+
+	// JSON cut short keeps the strings before the cut
+	text, err = extractText(t, "application/json", data, func(tp *worker.TextProcessor) {
+		tp.MaxJSONBytes = 50
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "title\nLaunch plan\nbudget\nowners", text)
+
+	_, err = extractText(t, "application/json", `{"title": nope}`, nil)
+	require.Error(t, err)
+	assert.True(t, worker.IsPermanent(err))
+}
+
+func TestDefaultRegistryText(t *testing.T) {
+	registry := worker.DefaultRegistry(storage.NewFilesystemStorage(t.TempDir()), nil)
+	assert.Equal(t, []string{worker.JobTypeText}, registry.JobTypes("text/plain; charset=utf-8"))
+	assert.Equal(t, []string{worker.JobTypeText}, registry.JobTypes("application/json"))
+	assert.Empty(t, registry.JobTypes("text/csv"))
+}
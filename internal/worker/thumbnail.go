@@ -0,0 +1,305 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"regexp"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/webp"
+	"github.com/disintegration/imaging"
+)
+
+// Thumbnail fit modes
+const (
+	// FitContain scales the image to fit inside the preset's box
+	FitContain = "fit"
+	// FitFill scales the image to cover the box and crops the center
+	FitFill = "fill"
+	// FitSmartCrop is FitFill, but keeps the most detailed part of the image
+	FitSmartCrop = "smart-crop"
+)
+
+// Thumbnail output formats
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp" // Lossless
+)
+
+// maxThumbnailSize bounds preset dimensions
+const maxThumbnailSize = 4096
+
+// presetNamePattern keeps preset names safe to use in storage keys
+var presetNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ThumbnailPreset describes one thumbnail made for every image. The
+// thumbnail is stored as the artifact named after the preset.
+type ThumbnailPreset struct {
+	Name string `json:"name"`
+	// Width and Height of the box; with FitContain either may be 0 to
+	// leave that side unbounded
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Fit    string `json:"fit,omitempty"`    // FitContain (default), FitFill or FitSmartCrop
+	Format string `json:"format,omitempty"` // FormatJPEG (default), FormatPNG or FormatWebP
+	// Quality is the JPEG quality, 1-100 (0: 95). PNG and WebP are lossless.
+	Quality int `json:"quality,omitempty"`
+	// Upscale enlarges images smaller than the box; otherwise they are
+	// kept at their size
+	Upscale bool `json:"upscale,omitempty"`
+}
+
+// DefaultThumbnailPresets are the small, medium and large JPEGs, 150, 400
+// and 800 pixels wide
+func DefaultThumbnailPresets() []ThumbnailPreset {
+	return []ThumbnailPreset{
+		{Name: "small", Width: 150, Fit: FitContain, Format: FormatJPEG},
+		{Name: "medium", Width: 400, Fit: FitContain, Format: FormatJPEG},
+		{Name: "large", Width: 800, Fit: FitContain, Format: FormatJPEG},
+	}
+}
+
+// ParseThumbnailPresets reads a JSON array of presets, filling in defaults
+// and validating them
+func ParseThumbnailPresets(data []byte) ([]ThumbnailPreset, error) {
+	var presets []ThumbnailPreset
+	if err := json.Unmarshal(data, &presets); err != nil {
+		return nil, fmt.Errorf("parse thumbnail presets: %w", err)
+	}
+	if len(presets) == 0 {
+		return nil, fmt.Errorf("no thumbnail presets")
+	}
+
+	seen := make(map[string]bool)
+	for i := range presets {
+		p := &presets[i]
+		if p.Fit == "" {
+			p.Fit = FitContain
+		}
+		if p.Format == "" {
+			p.Format = FormatJPEG
+		}
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("thumbnail preset %q: %w", p.Name, err)
+		}
+		if seen[p.Name] {
+			return nil, fmt.Errorf("duplicate thumbnail preset %q", p.Name)
+		}
+		seen[p.Name] = true
+	}
+	return presets, nil
+}
+
+// Validate checks the preset's fields; Fit and Format must be set
+func (p *ThumbnailPreset) Validate() error {
+	if !presetNamePattern.MatchString(p.Name) {
+		return fmt.Errorf("name must be 1-32 lowercase letters, digits, '-' or '_'")
+	}
+	if p.Name == animatedPreviewName {
+		return fmt.Errorf("name %q is reserved", p.Name)
+	}
+	if p.Width < 0 || p.Height < 0 || p.Width > maxThumbnailSize || p.Height > maxThumbnailSize {
+		return fmt.Errorf("width and height must be between 0 and %d", maxThumbnailSize)
+	}
+	switch p.Fit {
+	case FitContain:
+		if p.Width == 0 && p.Height == 0 {
+			return fmt.Errorf("width or height is required")
+		}
+	case FitFill, FitSmartCrop:
+		if p.Width == 0 || p.Height == 0 {
+			return fmt.Errorf("%s needs both width and height", p.Fit)
+		}
+	default:
+		return fmt.Errorf("unknown fit %q", p.Fit)
+	}
+	switch p.Format {
+	case FormatJPEG, FormatPNG, FormatWebP:
+	default:
+		return fmt.Errorf("unknown format %q", p.Format)
+	}
+	if p.Quality < 0 || p.Quality > 100 {
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	return nil
+}
+
+// fitSize returns the size to scale a srcW x srcH image to for FitContain
+func (p *ThumbnailPreset) fitSize(srcW, srcH int) (int, int) {
+	var w, h int
+	if p.Height == 0 || (p.Width != 0 && srcW*p.Height >= srcH*p.Width) {
+		w, h = p.Width, max(1, srcH*p.Width/srcW)
+	} else {
+		w, h = max(1, srcW*p.Height/srcH), p.Height
+	}
+	if !p.Upscale && (w > srcW || h > srcH) {
+		return srcW, srcH
+	}
+	return w, h
+}
+
+// coverSize returns the size to scale a srcW x srcH image to so it covers
+// the box, and the size to crop that to
+func (p *ThumbnailPreset) coverSize(srcW, srcH int) (scaledW, scaledH, cropW, cropH int) {
+	if srcW*p.Height >= srcH*p.Width {
+		scaledW, scaledH = max(p.Width, srcW*p.Height/srcH), p.Height
+	} else {
+		scaledW, scaledH = p.Width, max(p.Height, srcH*p.Width/srcW)
+	}
+	if !p.Upscale && (scaledW > srcW || scaledH > srcH) {
+		scaledW, scaledH = srcW, srcH
+	}
+	return scaledW, scaledH, min(p.Width, scaledW), min(p.Height, scaledH)
+}
+
+// Render scales and crops img per the preset
+func (p *ThumbnailPreset) Render(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+
+	if p.Fit == FitContain {
+		w, h := p.fitSize(srcW, srcH)
+		return imaging.Resize(img, w, h, imaging.Lanczos)
+	}
+
+	scaledW, scaledH, cropW, cropH := p.coverSize(srcW, srcH)
+	scaled := imaging.Resize(img, scaledW, scaledH, imaging.Lanczos)
+	if p.Fit == FitSmartCrop {
+		return imaging.Crop(scaled, smartCropRect(scaled, cropW, cropH))
+	}
+	return imaging.CropCenter(scaled, cropW, cropH)
+}
+
+// Encode writes img in the preset's format
+func (p *ThumbnailPreset) Encode(w io.Writer, img image.Image) error {
+	switch p.Format {
+	case FormatPNG:
+		return imaging.Encode(w, img, imaging.PNG)
+	case FormatWebP:
+		return webp.Encode(w, img)
+	default:
+		if p.Quality > 0 {
+			return imaging.Encode(w, img, imaging.JPEG, imaging.JPEGQuality(p.Quality))
+		}
+		return imaging.Encode(w, img, imaging.JPEG)
+	}
+}
+
+var thumbnailFormats = map[string]struct{ ext, contentType string }{
+	FormatJPEG: {"jpg", "image/jpeg"},
+	FormatPNG:  {"png", "image/png"},
+	FormatWebP: {"webp", "image/webp"},
+}
+
+// ContentType is the MIME type of the preset's format
+func (p *ThumbnailPreset) ContentType() string {
+	return thumbnailFormats[p.Format].contentType
+}
+
+// Extension is the file extension of the preset's format, without the dot
+func (p *ThumbnailPreset) Extension() string {
+	return thumbnailFormats[p.Format].ext
+}
+
+func (ip *ImageProcessor) saveThumbnail(fileID string, img image.Image, preset ThumbnailPreset) *database.Artifact {
+	if img.Bounds().Empty() {
+		return nil
+	}
+	thumb := preset.Render(img)
+
+	thumbPath := fmt.Sprintf("%s-thumb-%s.%s", fileID, preset.Name, preset.Extension())
+	w, err := ip.storage.CreateFile(thumbPath)
+	if err != nil {
+		log.Printf("Failed to create thumbnail: %v", err)
+		return nil
+	}
+	if err := preset.Encode(w, thumb); err != nil {
+		w.Close()
+		ip.storage.DeleteFile(thumbPath)
+		log.Printf("Failed to save thumbnail: %v", err)
+		return nil
+	}
+	if err := w.Close(); err != nil {
+		log.Printf("Failed to save thumbnail: %v", err)
+		return nil
+	}
+
+	return &database.Artifact{
+		Name:        preset.Name,
+		Kind:        database.ArtifactThumbnail,
+		Path:        thumbPath,
+		ContentType: preset.ContentType(),
+		Width:       thumb.Bounds().Dx(),
+		Height:      thumb.Bounds().Dy(),
+	}
+}
+
+// smartCropRect picks the w x h window of img with the most detail, measured
+// as the luminance gradient. Rows and columns are scored separately, which
+// is cheap and good enough to keep the subject of a photo in frame.
+func smartCropRect(img *image.NRGBA, w, h int) image.Rectangle {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	luma := make([]int, width*height)
+	for y := 0; y < height; y++ {
+		row := img.Pix[y*img.Stride:]
+		for x := 0; x < width; x++ {
+			r, g, b := int(row[4*x]), int(row[4*x+1]), int(row[4*x+2])
+			luma[y*width+x] = (299*r + 587*g + 114*b) / 1000
+		}
+	}
+
+	colEnergy := make([]int, width)
+	rowEnergy := make([]int, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var e int
+			if x+1 < width {
+				e += abs(luma[y*width+x+1] - luma[y*width+x])
+			}
+			if y+1 < height {
+				e += abs(luma[(y+1)*width+x] - luma[y*width+x])
+			}
+			colEnergy[x] += e
+			rowEnergy[y] += e
+		}
+	}
+
+	x := bestWindow(colEnergy, w)
+	y := bestWindow(rowEnergy, h)
+	return image.Rect(x, y, x+w, y+h).Add(bounds.Min)
+}
+
+// bestWindow returns the start of the size-long run of energy with the
+// highest sum; ties go to the run nearest the center
+func bestWindow(energy []int, size int) int {
+	if size >= len(energy) {
+		return 0
+	}
+	center := (len(energy) - size) / 2
+
+	sum := 0
+	for _, e := range energy[:size] {
+		sum += e
+	}
+	best, bestSum := 0, sum
+	for start := 1; start+size <= len(energy); start++ {
+		sum += energy[start+size-1] - energy[start-1]
+		if sum > bestSum || (sum == bestSum && abs(start-center) < abs(best-center)) {
+			best, bestSum = start, sum
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
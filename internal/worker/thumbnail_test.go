@@ -0,0 +1,172 @@
+package worker_test
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/storage"
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/worker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	xwebp "golang.org/x/image/webp"
+)
+
+// thumbnailsOf processes img (stored as PNG) with the given presets
+func thumbnailsOf(t *testing.T, img image.Image, presets string) (*database.JobResult, *storage.FilesystemStorage) {
+	parsed, err := worker.ParseThumbnailPresets([]byte(presets))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	store := storage.NewFilesystemStorage(t.TempDir())
+	w, err := store.CreateFile("img")
+	require.NoError(t, err)
+	_, err = w.Write(buf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	processor := worker.NewImageProcessor(store)
+	processor.Presets = parsed
+	result, err := processor.Process(context.Background(),
+		&database.FileRecord{ID: "img", ContentType: "image/png"})
+	require.NoError(t, err)
+	return result, store
+}
+
+func decodeArtifact(t *testing.T, store *storage.FilesystemStorage, artifact *database.Artifact) image.Image {
+	require.NotNil(t, artifact)
+	r, err := store.ReadFile(artifact.Path)
+	require.NoError(t, err)
+	defer r.Close()
+	img, _, err := image.Decode(r)
+	require.NoError(t, err)
+	assert.Equal(t, artifact.Width, img.Bounds().Dx())
+	assert.Equal(t, artifact.Height, img.Bounds().Dy())
+	return img
+}
+
+// detailedImage is flat gray, with a checkerboard near the right edge
+func detailedImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.NRGBA{128, 128, 128, 255}
+			if x >= 220 && x < 280 && (x/4+y/4)%2 == 0 {
+				c = color.NRGBA{255, 0, 0, 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+// redPixels counts the checkerboard pixels in img
+func redPixels(img image.Image) int {
+	n := 0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, _, _ := img.At(x, y).RGBA()
+			if r > 0xc000 && g < 0x4000 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestThumbnailPresets(t *testing.T) {
+	result, store := thumbnailsOf(t, detailedImage(), `[
+		{"name": "wide", "width": 150},
+		{"name": "tall", "height": 50, "format": "png"},
+		{"name": "center", "width": 100, "height": 100, "fit": "fill", "format": "png"},
+		{"name": "smart", "width": 100, "height": 100, "fit": "smart-crop", "format": "webp"},
+		{"name": "big", "width": 600, "upscale": true, "quality": 50}
+	]`)
+	require.Len(t, result.Artifacts, 5)
+
+	wide := result.Artifact("wide")
+	assert.Equal(t, "image/jpeg", wide.ContentType)
+	assert.Equal(t, "img-thumb-wide.jpg", wide.Path)
+	assert.Equal(t, database.ArtifactThumbnail, wide.Kind)
+	assert.Equal(t, []int{150, 50}, []int{wide.Width, wide.Height})
+	decodeArtifact(t, store, wide)
+
+	tall := result.Artifact("tall")
+	assert.Equal(t, "image/png", tall.ContentType)
+	assert.Equal(t, []int{150, 50}, []int{tall.Width, tall.Height})
+	decodeArtifact(t, store, tall)
+
+	// Filling crops the flat center, smart cropping finds the detail
+	center := decodeArtifact(t, store, result.Artifact("center"))
+	assert.Equal(t, image.Rect(0, 0, 100, 100), center.Bounds())
+	assert.Zero(t, redPixels(center))
+
+	smart := result.Artifact("smart")
+	assert.Equal(t, "image/webp", smart.ContentType)
+	r, err := store.ReadFile(smart.Path)
+	require.NoError(t, err)
+	defer r.Close()
+	smartImg, err := xwebp.Decode(r)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 100, 100), smartImg.Bounds())
+	assert.Greater(t, redPixels(smartImg), 1000)
+
+	big := result.Artifact("big")
+	assert.Equal(t, []int{600, 200}, []int{big.Width, big.Height})
+}
+
+func TestThumbnailPresetsDontUpscale(t *testing.T) {
+	result, _ := thumbnailsOf(t, detailedImage(), `[
+		{"name": "fit", "width": 1000},
+		{"name": "fill", "width": 200, "height": 200, "fit": "fill"}
+	]`)
+
+	fit := result.Artifact("fit")
+	assert.Equal(t, []int{300, 100}, []int{fit.Width, fit.Height})
+	// Too short to fill, so only cropped
+	fill := result.Artifact("fill")
+	assert.Equal(t, []int{200, 100}, []int{fill.Width, fill.Height})
+}
+
+func TestThumbnailOfDegenerateImage(t *testing.T) {
+	// One pixel wide: heights must not round down to zero
+	result, _ := thumbnailsOf(t, image.NewNRGBA(image.Rect(0, 0, 1, 500)), `[
+		{"name": "a", "height": 100},
+		{"name": "b", "width": 100, "height": 100, "fit": "smart-crop", "upscale": true}
+	]`)
+	assert.Equal(t, []int{1, 100}, []int{result.Artifact("a").Width, result.Artifact("a").Height})
+	assert.Equal(t, []int{100, 100}, []int{result.Artifact("b").Width, result.Artifact("b").Height})
+
+	result, _ = thumbnailsOf(t, image.NewNRGBA(image.Rect(0, 0, 2000, 1)), `[{"name": "a", "width": 100}]`)
+	assert.Equal(t, []int{100, 1}, []int{result.Artifact("a").Width, result.Artifact("a").Height})
+}
+
+func TestParseThumbnailPresets(t *testing.T) {
+	presets, err := worker.ParseThumbnailPresets([]byte(`[{"name": "x", "width": 10}]`))
+	require.NoError(t, err)
+	assert.Equal(t, []worker.ThumbnailPreset{
+		{Name: "x", Width: 10, Fit: worker.FitContain, Format: worker.FormatJPEG},
+	}, presets)
+
+	for name, raw := range map[string]string{
+		"empty":        `[]`,
+		"bad name":     `[{"name": "../x", "width": 10}]`,
+		"reserved":     `[{"name": "animated", "width": 10}]`,
+		"duplicate":    `[{"name": "x", "width": 10}, {"name": "x", "width": 20}]`,
+		"no size":      `[{"name": "x"}]`,
+		"fill no size": `[{"name": "x", "width": 10, "fit": "fill"}]`,
+		"bad fit":      `[{"name": "x", "width": 10, "fit": "stretch"}]`,
+		"bad format":   `[{"name": "x", "width": 10, "format": "avif"}]`,
+		"bad quality":  `[{"name": "x", "width": 10, "quality": 101}]`,
+		"too big":      `[{"name": "x", "width": 100000}]`,
+	} {
+		_, err := worker.ParseThumbnailPresets([]byte(raw))
+		assert.Error(t, err, name)
+	}
+}
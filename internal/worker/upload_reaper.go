@@ -0,0 +1,81 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/PaulBabatuyi/UploadStream-gRPC/internal/database"
+)
+
+type ReaperConfig struct {
+	DB        *database.PostgresDB
+	Storage   Storage
+	Interval  time.Duration
+	BatchSize int
+}
+
+// UploadSessionReaper garbage-collects expired resumable upload sessions
+type UploadSessionReaper struct {
+	config *ReaperConfig
+	done   chan struct{}
+}
+
+func NewUploadSessionReaper(config *ReaperConfig) *UploadSessionReaper {
+	if config.Interval == 0 {
+		config.Interval = 10 * time.Minute
+	}
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	return &UploadSessionReaper{
+		config: config,
+		done:   make(chan struct{}),
+	}
+}
+
+func (r *UploadSessionReaper) Start(ctx context.Context) {
+	go r.run(ctx)
+	log.Println("Upload session reaper started")
+}
+
+func (r *UploadSessionReaper) Stop() {
+	close(r.done)
+	log.Println("Upload session reaper stopped")
+}
+
+func (r *UploadSessionReaper) run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.reapExpired(ctx)
+		}
+	}
+}
+
+func (r *UploadSessionReaper) reapExpired(ctx context.Context) {
+	sessions, err := r.config.DB.ListExpiredUploadSessions(ctx, r.config.BatchSize)
+	if err != nil {
+		log.Printf("Error listing expired upload sessions: %v", err)
+		return
+	}
+
+	for _, session := range sessions {
+		if err := r.config.Storage.DeleteFile(session.StoragePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to delete partial upload %s: %v", session.UploadID, err)
+			continue
+		}
+		if err := r.config.DB.DeleteUploadSession(ctx, session.UploadID); err != nil {
+			log.Printf("Failed to delete upload session %s: %v", session.UploadID, err)
+			continue
+		}
+		log.Printf("Reaped expired upload session %s", session.UploadID)
+	}
+}
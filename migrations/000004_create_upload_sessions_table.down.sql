@@ -0,0 +1 @@
+DROP TABLE IF EXISTS upload_sessions;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE upload_sessions (
+    upload_id     TEXT PRIMARY KEY,
+    user_id       TEXT NOT NULL,
+    filename      TEXT NOT NULL,
+    content_type  TEXT NOT NULL,
+    size          BIGINT NOT NULL CHECK (size > 0),
+    storage_path  TEXT NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT NOW(),
+    updated_at    TIMESTAMPTZ DEFAULT NOW(),
+    expires_at    TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_upload_sessions_expires_at ON upload_sessions(expires_at);
@@ -0,0 +1,2 @@
+ALTER TABLE files DROP COLUMN IF EXISTS crc32c;
+ALTER TABLE files DROP COLUMN IF EXISTS sha256;
\ No newline at end of file
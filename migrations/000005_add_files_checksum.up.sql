@@ -0,0 +1,2 @@
+ALTER TABLE files ADD COLUMN sha256 TEXT;
+ALTER TABLE files ADD COLUMN crc32c BIGINT;
@@ -0,0 +1,14 @@
+CREATE TABLE api_keys (
+    id            UUID PRIMARY KEY,
+    owner_id      TEXT NOT NULL,
+    name          TEXT NOT NULL DEFAULT '',
+    prefix        TEXT NOT NULL,               -- First characters of the key, for display
+    key_hash      BYTEA NOT NULL UNIQUE,       -- SHA-256 of the full key; the key itself is never stored
+    scopes        TEXT[] NOT NULL,
+    created_at    TIMESTAMPTZ DEFAULT NOW(),
+    expires_at    TIMESTAMPTZ,                 -- NULL = never expires
+    last_used_at  TIMESTAMPTZ,
+    revoked_at    TIMESTAMPTZ
+);
+
+CREATE INDEX idx_api_keys_owner_id ON api_keys(owner_id);
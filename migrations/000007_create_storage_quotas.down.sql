@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS storage_quotas;
+DROP TABLE IF EXISTS storage_usage;
+ALTER TABLE api_keys DROP COLUMN IF EXISTS tenant_id;
+ALTER TABLE files DROP COLUMN IF EXISTS tenant_id;
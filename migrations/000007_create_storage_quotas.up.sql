@@ -0,0 +1,30 @@
+ALTER TABLE files ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE api_keys ADD COLUMN tenant_id TEXT NOT NULL DEFAULT '';
+
+-- Running totals of live (not soft-deleted) files, kept in step with files
+-- by SaveFile/DeleteFile so quota checks don't have to SUM() on every upload
+CREATE TABLE storage_usage (
+    owner_type  TEXT NOT NULL CHECK (owner_type IN ('user', 'tenant')),
+    owner_id    TEXT NOT NULL,
+    bytes_used  BIGINT NOT NULL DEFAULT 0 CHECK (bytes_used >= 0),
+    file_count  BIGINT NOT NULL DEFAULT 0 CHECK (file_count >= 0),
+    updated_at  TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (owner_type, owner_id)
+);
+
+-- Per-user / per-tenant overrides of the server's default limits.
+-- NULL = use the default, 0 = unlimited
+CREATE TABLE storage_quotas (
+    owner_type  TEXT NOT NULL CHECK (owner_type IN ('user', 'tenant')),
+    owner_id    TEXT NOT NULL,
+    max_bytes   BIGINT CHECK (max_bytes >= 0),
+    max_files   BIGINT CHECK (max_files >= 0),
+    updated_at  TIMESTAMPTZ DEFAULT NOW(),
+    PRIMARY KEY (owner_type, owner_id)
+);
+
+INSERT INTO storage_usage (owner_type, owner_id, bytes_used, file_count)
+SELECT 'user', user_id, SUM(size), COUNT(*)
+FROM files
+WHERE deleted_at IS NULL
+GROUP BY user_id;
@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS processing_jobs_notify ON processing_jobs;
+DROP FUNCTION IF EXISTS notify_processing_job_event();
@@ -0,0 +1,18 @@
+-- Publish processing job state changes so servers can push them to WatchFile
+-- streams instead of clients polling GetFileMetadata
+CREATE OR REPLACE FUNCTION notify_processing_job_event() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'INSERT' OR OLD.status IS DISTINCT FROM NEW.status THEN
+        PERFORM pg_notify('processing_job_events', json_build_object(
+            'job_id', NEW.id,
+            'file_id', NEW.file_id,
+            'status', NEW.status
+        )::text);
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER processing_jobs_notify
+    AFTER INSERT OR UPDATE OF status ON processing_jobs
+    FOR EACH ROW EXECUTE FUNCTION notify_processing_job_event();
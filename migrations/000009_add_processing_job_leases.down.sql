@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_jobs_lease_expires_at;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS lease_expires_at;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS worker_id;
@@ -0,0 +1,7 @@
+-- A worker owns a 'processing' job only while its lease is current. Workers
+-- extend the lease with heartbeats; jobs whose lease runs out (the worker
+-- died or hung) are returned to 'pending' by the lease reaper.
+ALTER TABLE processing_jobs ADD COLUMN worker_id TEXT;
+ALTER TABLE processing_jobs ADD COLUMN lease_expires_at TIMESTAMPTZ;
+
+CREATE INDEX idx_jobs_lease_expires_at ON processing_jobs(lease_expires_at) WHERE status = 'processing';
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS processing_job_attempts;
+DROP INDEX IF EXISTS idx_jobs_dead_letter;
+DROP INDEX IF EXISTS idx_jobs_runnable;
+
+ALTER TABLE processing_jobs ADD COLUMN max_retries INT DEFAULT 3;
+UPDATE processing_jobs SET status = 'failed' WHERE status = 'dead_letter';
+
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS started_at;
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS run_after;
@@ -0,0 +1,27 @@
+-- Retries are scheduled by the worker's retry policy: a failed attempt sets
+-- run_after (exponential backoff with jitter) and an exhausted job moves to
+-- the terminal 'dead_letter' state instead of lingering in 'pending'.
+ALTER TABLE processing_jobs ADD COLUMN run_after TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE processing_jobs ADD COLUMN started_at TIMESTAMPTZ;
+
+UPDATE processing_jobs SET status = 'dead_letter'
+WHERE status = 'failed' OR (status = 'pending' AND retry_count >= max_retries);
+
+-- Max attempts now come from the worker's per-type retry policy
+ALTER TABLE processing_jobs DROP COLUMN max_retries;
+
+CREATE INDEX idx_jobs_runnable ON processing_jobs(run_after) WHERE status = 'pending';
+CREATE INDEX idx_jobs_dead_letter ON processing_jobs(updated_at DESC) WHERE status = 'dead_letter';
+
+-- One row per failed attempt, kept after requeueing so dead-lettered jobs
+-- can be diagnosed
+CREATE TABLE processing_job_attempts (
+    id            BIGSERIAL PRIMARY KEY,
+    job_id        BIGINT NOT NULL REFERENCES processing_jobs(id) ON DELETE CASCADE,
+    worker_id     TEXT,
+    started_at    TIMESTAMPTZ,
+    finished_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    error_message TEXT NOT NULL
+);
+
+CREATE INDEX idx_job_attempts_job_id ON processing_job_attempts(job_id, id);
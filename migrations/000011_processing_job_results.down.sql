@@ -0,0 +1,15 @@
+ALTER TABLE processing_jobs ADD COLUMN thumbnail_small TEXT;
+ALTER TABLE processing_jobs ADD COLUMN thumbnail_medium TEXT;
+ALTER TABLE processing_jobs ADD COLUMN thumbnail_large TEXT;
+ALTER TABLE processing_jobs ADD COLUMN original_width INT;
+ALTER TABLE processing_jobs ADD COLUMN original_height INT;
+
+UPDATE processing_jobs SET
+    original_width = (result->>'width')::INT,
+    original_height = (result->>'height')::INT,
+    thumbnail_small = (SELECT a->>'path' FROM jsonb_array_elements(result->'artifacts') a WHERE a->>'name' = 'small'),
+    thumbnail_medium = (SELECT a->>'path' FROM jsonb_array_elements(result->'artifacts') a WHERE a->>'name' = 'medium'),
+    thumbnail_large = (SELECT a->>'path' FROM jsonb_array_elements(result->'artifacts') a WHERE a->>'name' = 'large')
+WHERE result IS NOT NULL;
+
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS result;
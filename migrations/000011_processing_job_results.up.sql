@@ -0,0 +1,21 @@
+-- Processor output is stored as one JSONB document instead of fixed
+-- thumbnail/dimension columns, so new processors don't need schema changes:
+-- {"width": 0, "height": 0, "attributes": {...}, "artifacts": [{"name": ..., "path": ...}]}
+ALTER TABLE processing_jobs ADD COLUMN result JSONB;
+
+UPDATE processing_jobs SET result = jsonb_strip_nulls(jsonb_build_object(
+    'width', original_width,
+    'height', original_height,
+    'artifacts', (
+        SELECT jsonb_agg(jsonb_build_object('name', a.name, 'path', a.path, 'content_type', 'image/jpeg'))
+        FROM (VALUES ('small', thumbnail_small), ('medium', thumbnail_medium), ('large', thumbnail_large)) AS a(name, path)
+        WHERE a.path IS NOT NULL AND a.path <> ''
+    )
+))
+WHERE status = 'completed';
+
+ALTER TABLE processing_jobs DROP COLUMN thumbnail_small;
+ALTER TABLE processing_jobs DROP COLUMN thumbnail_medium;
+ALTER TABLE processing_jobs DROP COLUMN thumbnail_large;
+ALTER TABLE processing_jobs DROP COLUMN original_width;
+ALTER TABLE processing_jobs DROP COLUMN original_height;
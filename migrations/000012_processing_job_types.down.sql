@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_jobs_file_id_job_type;
+CREATE INDEX idx_jobs_file_id ON processing_jobs(file_id);
+ALTER TABLE processing_jobs DROP COLUMN IF EXISTS job_type;
@@ -0,0 +1,12 @@
+-- A file can have several independent processing jobs (thumbnails, EXIF,
+-- hashing, ...), one per job type. Existing jobs were all thumbnail jobs.
+ALTER TABLE processing_jobs ADD COLUMN job_type TEXT NOT NULL DEFAULT 'thumbnail';
+ALTER TABLE processing_jobs ALTER COLUMN job_type DROP DEFAULT;
+
+-- Keep only the newest job if a file somehow got more than one
+DELETE FROM processing_jobs j
+USING processing_jobs newer
+WHERE newer.file_id = j.file_id AND newer.job_type = j.job_type AND newer.id > j.id;
+
+DROP INDEX IF EXISTS idx_jobs_file_id;
+CREATE UNIQUE INDEX idx_jobs_file_id_job_type ON processing_jobs(file_id, job_type);
@@ -0,0 +1,7 @@
+ALTER TABLE files
+    DROP COLUMN IF EXISTS original_sha256,
+    DROP COLUMN IF EXISTS original_size,
+    DROP COLUMN IF EXISTS metadata_removed,
+    DROP COLUMN IF EXISTS metadata_policy;
+
+DROP TABLE IF EXISTS tenant_settings;
@@ -0,0 +1,15 @@
+-- Per-tenant settings. The metadata policy is the minimum every upload by
+-- the tenant's users gets.
+CREATE TABLE tenant_settings (
+    tenant_id        TEXT PRIMARY KEY,
+    metadata_policy  TEXT NOT NULL CHECK (metadata_policy IN ('keep', 'strip_location', 'strip_all')),
+    updated_at       TIMESTAMPTZ DEFAULT NOW()
+);
+
+-- How an upload was rewritten to remove metadata before it was stored.
+-- NULL for files stored as uploaded; size and sha256 describe the stored file.
+ALTER TABLE files
+    ADD COLUMN metadata_policy TEXT,
+    ADD COLUMN metadata_removed TEXT[],
+    ADD COLUMN original_size BIGINT,
+    ADD COLUMN original_sha256 TEXT;
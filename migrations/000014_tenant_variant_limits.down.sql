@@ -0,0 +1,6 @@
+DELETE FROM tenant_settings WHERE metadata_policy IS NULL;
+
+ALTER TABLE tenant_settings
+    DROP COLUMN IF EXISTS variant_max_height,
+    DROP COLUMN IF EXISTS variant_max_width,
+    ALTER COLUMN metadata_policy SET NOT NULL;
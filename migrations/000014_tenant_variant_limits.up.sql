@@ -0,0 +1,6 @@
+-- Per-tenant caps on GetImageVariant dimensions; NULL means the server
+-- default. A tenant may now have limits without a metadata policy.
+ALTER TABLE tenant_settings
+    ALTER COLUMN metadata_policy DROP NOT NULL,
+    ADD COLUMN variant_max_width INTEGER CHECK (variant_max_width > 0),
+    ADD COLUMN variant_max_height INTEGER CHECK (variant_max_height > 0);
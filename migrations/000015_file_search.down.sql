@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_files_tags;
+DROP INDEX IF EXISTS idx_files_search;
+DROP TRIGGER IF EXISTS files_search_vector ON files;
+DROP FUNCTION IF EXISTS files_search_vector();
+
+ALTER TABLE files
+    DROP COLUMN IF EXISTS search_vector,
+    DROP COLUMN IF EXISTS content_text,
+    DROP COLUMN IF EXISTS custom_metadata,
+    DROP COLUMN IF EXISTS tags;
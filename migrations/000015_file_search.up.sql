@@ -0,0 +1,40 @@
+-- Labels set at upload, and the text the worker extracted from the file
+-- (text and JSON files, PDF pages). All of it is searchable through
+-- search_vector.
+ALTER TABLE files
+    ADD COLUMN tags TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN custom_metadata JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN content_text TEXT,
+    ADD COLUMN search_vector TSVECTOR;
+
+-- Filename and tags rank above custom metadata (keys and values), which
+-- ranks above content. Punctuation in filenames separates words, so
+-- "q3_report.pdf" matches "report".
+CREATE OR REPLACE FUNCTION files_search_vector() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('english', regexp_replace(NEW.filename, '[[:punct:]]+', ' ', 'g')), 'A') ||
+        setweight(to_tsvector('english', array_to_string(NEW.tags, ' ')), 'A') ||
+        setweight(jsonb_to_tsvector('english', NEW.custom_metadata, '["key", "string"]'), 'B') ||
+        setweight(to_tsvector('english', COALESCE(NEW.content_text, '')), 'C');
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER files_search_vector
+    BEFORE INSERT OR UPDATE OF filename, tags, custom_metadata, content_text ON files
+    FOR EACH ROW EXECUTE FUNCTION files_search_vector();
+
+-- Backfill text already extracted from PDFs, capped like new text
+-- (database.MaxSearchTextBytes). The assignment fires the trigger for every
+-- other file too.
+UPDATE files f
+SET content_text = left((
+    SELECT string_agg(page->>'text', E'\n\n' ORDER BY page_number)
+    FROM processing_jobs j,
+         jsonb_array_elements(j.result->'document'->'pages') WITH ORDINALITY AS p(page, page_number)
+    WHERE j.file_id = f.id AND j.status = 'completed'
+), 262144);
+
+CREATE INDEX idx_files_search ON files USING GIN (search_vector) WHERE deleted_at IS NULL;
+CREATE INDEX idx_files_tags ON files USING GIN (tags) WHERE deleted_at IS NULL;
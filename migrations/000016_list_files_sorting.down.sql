@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_files_user_size;
+DROP INDEX IF EXISTS idx_files_user_filename;
+DROP INDEX IF EXISTS idx_files_user_uploaded_at;
@@ -0,0 +1,5 @@
+-- ListFiles sorts a user's files by upload time, name (case-insensitively,
+-- by code point) or size, with the ID breaking ties
+CREATE INDEX idx_files_user_uploaded_at ON files(user_id, uploaded_at, id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_files_user_filename ON files(user_id, (lower(filename)) COLLATE "C", id) WHERE deleted_at IS NULL;
+CREATE INDEX idx_files_user_size ON files(user_id, size, id) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE upload_sessions
+    DROP COLUMN IF EXISTS active_until,
+    DROP COLUMN IF EXISTS claim_id;
@@ -0,0 +1,5 @@
+-- One stream at a time may write a resumable upload: it claims the session
+-- with a random claim_id and keeps extending active_until while it streams
+ALTER TABLE upload_sessions
+    ADD COLUMN claim_id TEXT,
+    ADD COLUMN active_until TIMESTAMPTZ;
@@ -0,0 +1,10 @@
+-- Cached GetImageVariant renditions. Their bytes count towards the owner's
+-- storage usage, and they are removed with the file.
+CREATE TABLE image_variants (
+    storage_key TEXT PRIMARY KEY,
+    file_id     UUID NOT NULL REFERENCES files(id) ON DELETE CASCADE,
+    size        BIGINT NOT NULL CHECK (size >= 0),
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_image_variants_file_id ON image_variants(file_id);